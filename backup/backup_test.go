@@ -0,0 +1,156 @@
+package backup
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestBip39Vectors(t *testing.T) {
+	t.Parallel()
+	vectors := map[string]string{
+		strings.Repeat("\x00", 16): "abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon about",
+		strings.Repeat("\x7f", 16): "legal winner thank year wave sausage worth useful legal winner thank yellow",
+	}
+	for data, expected := range vectors {
+		encoded, err := EncodeWords([]byte(data))
+		if err != nil {
+			t.Errorf("Failed to encode data because %s", err)
+			continue
+		}
+		words := strings.Join(encoded, " ")
+		if words != expected {
+			t.Errorf("Encoded words didn't match expected\ngot:      %s\nexpected: %s", words, expected)
+		}
+	}
+}
+
+func TestWordsRoundtrip(t *testing.T) {
+	t.Parallel()
+	for length := 1; length < 40; length++ {
+		data := make([]byte, length)
+		for i := range data {
+			data[i] = byte(i*37 + length)
+		}
+		encoded, err := EncodeWords(data)
+		if checksumBits(length) > 11 {
+			if err == nil {
+				t.Errorf("Encoding %d bytes should have failed", length)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Failed to encode %d bytes because %s", length, err)
+			continue
+		}
+		decoded, err := DecodeWords(encoded)
+		if err != nil {
+			t.Errorf("Failed to decode %d bytes because %s", length, err)
+			continue
+		}
+		if !bytes.Equal(data, decoded) {
+			t.Errorf("Decoded data didn't match expected\ngot:      %#v\nexpected: %#v", decoded, data)
+		}
+	}
+}
+
+func TestWordsChecksum(t *testing.T) {
+	t.Parallel()
+	words, _ := EncodeWords([]byte("0123456789abcdef"))
+	if words[3] == "zoo" {
+		words[3] = "zone"
+	} else {
+		words[3] = "zoo"
+	}
+	if _, err := DecodeWords(words); err == nil {
+		t.Error("A changed word wasn't detected by the checksum")
+	}
+}
+
+func TestWordsAbbreviated(t *testing.T) {
+	t.Parallel()
+	data := []byte("0123456789abcdef")
+	words, _ := EncodeWords(data)
+	for i := range words {
+		if len(words[i]) > 4 {
+			words[i] = strings.ToUpper(words[i][:4])
+		}
+	}
+	decoded, err := DecodeWords(words)
+	if err != nil {
+		t.Errorf("Failed to decode abbreviated words because %s", err)
+		return
+	}
+	if !bytes.Equal(data, decoded) {
+		t.Errorf("Decoded data didn't match expected\ngot:      %#v\nexpected: %#v", decoded, data)
+	}
+}
+
+func TestShamir(t *testing.T) {
+	t.Parallel()
+	secret := []byte("0123456789abcdef")
+	shares, err := Split(secret, 5, 3)
+	if err != nil {
+		t.Errorf("Failed to split secret because %s", err)
+		return
+	}
+
+	for _, subset := range [][]int{{0, 1, 2}, {4, 2, 0}, {1, 3, 4}, {0, 1, 2, 3, 4}} {
+		selected := [][]byte{}
+		for _, i := range subset {
+			selected = append(selected, shares[i])
+		}
+		combined, err := Combine(selected)
+		if err != nil {
+			t.Errorf("Failed to combine shares %v because %s", subset, err)
+			continue
+		}
+		if !bytes.Equal(secret, combined) {
+			t.Errorf("Shares %v combined to %#v", subset, combined)
+		}
+	}
+
+	if _, err = Combine(shares[:2]); err == nil {
+		t.Error("Combining fewer shares than the threshold didn't fail")
+	}
+	if _, err = Combine([][]byte{shares[0], shares[0], shares[1]}); err == nil {
+		t.Error("Combining duplicate shares didn't fail")
+	}
+}
+
+func TestSheetRoundtrip(t *testing.T) {
+	t.Parallel()
+	key := []byte("0123456789abcdef")
+	sheets, err := NewSheets(key, "c2FsdA==", 19, 3, 2)
+	if err != nil {
+		t.Errorf("Failed to create sheets because %s", err)
+		return
+	}
+
+	parsed := []Sheet{}
+	for _, sheet := range sheets[1:] {
+		if !IsSheet(sheet.String()) {
+			t.Errorf("%q wasn't recognized as a sheet", sheet.String())
+		}
+		p, err := ParseSheet(sheet.String())
+		if err != nil {
+			t.Errorf("Failed to parse sheet because %s", err)
+			return
+		}
+		parsed = append(parsed, p)
+	}
+
+	salt, pow, recovered, err := Recover(parsed)
+	if err != nil {
+		t.Errorf("Failed to recover key because %s", err)
+		return
+	}
+	if salt != "c2FsdA==" || pow != 19 || !bytes.Equal(key, recovered) {
+		t.Errorf("Recovered values didn't match expected, got %s %d %#v", salt, pow, recovered)
+	}
+
+	buffer := new(bytes.Buffer)
+	if err = sheets[0].Render(buffer); err != nil {
+		t.Errorf("Failed to render sheet because %s", err)
+	}
+}
@@ -0,0 +1,119 @@
+package backup
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+)
+
+// Shares are laid out as {{threshold}}{{x}}{{y...}} where y is one byte per byte of the secret.
+// The arithmetic is done in GF(2^8) using the same polynomial as AES.
+
+var expTable, logTable [256]byte
+
+func init() {
+	x := byte(1)
+	for i := 0; i < 255; i++ {
+		expTable[i] = x
+		logTable[x] = byte(i)
+		// multiply by the generator, 3
+		x ^= xtime(x)
+	}
+	expTable[255] = expTable[0]
+}
+
+func xtime(x byte) byte {
+	if x&0x80 != 0 {
+		return x<<1 ^ 0x1b
+	}
+	return x << 1
+}
+
+func gfMul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return expTable[(int(logTable[a])+int(logTable[b]))%255]
+}
+
+func gfDiv(a, b byte) byte {
+	if a == 0 {
+		return 0
+	}
+	return expTable[(int(logTable[a])-int(logTable[b])+255)%255]
+}
+
+// Split splits the secret into count shares, any threshold of which can be combined to recreate
+// the secret. A threshold of 1 results in shares that each contain the secret.
+func Split(secret []byte, count, threshold int) ([][]byte, error) {
+	if threshold < 1 || threshold > count || count > 255 {
+		return [][]byte{}, fmt.Errorf("Can't split a secret into %d shares with a threshold of %d", count, threshold)
+	}
+
+	coefficients := make([]byte, threshold-1)
+	shares := make([][]byte, count)
+	for i := range shares {
+		shares[i] = make([]byte, len(secret)+2)
+		shares[i][0] = byte(threshold)
+		shares[i][1] = byte(i + 1)
+	}
+
+	for b, value := range secret {
+		if _, err := rand.Read(coefficients); err != nil {
+			return [][]byte{}, err
+		}
+		for _, share := range shares {
+			x := share[1]
+			// Horner's method, starting from the highest degree coefficient
+			y := byte(0)
+			for c := len(coefficients) - 1; c >= 0; c-- {
+				y = gfMul(y, x) ^ coefficients[c]
+			}
+			share[b+2] = gfMul(y, x) ^ value
+		}
+	}
+	for i := range coefficients {
+		coefficients[i] = 0
+	}
+	return shares, nil
+}
+
+// Combine recreates the secret from the shares. It fails if there are fewer shares than the
+// threshold they were split with.
+func Combine(shares [][]byte) ([]byte, error) {
+	if len(shares) == 0 {
+		return []byte{}, errors.New("No shares to combine")
+	}
+	threshold := int(shares[0][0])
+	length := len(shares[0])
+	seen := make(map[byte]bool)
+	for _, share := range shares {
+		if len(share) != length || len(share) < 3 || int(share[0]) != threshold {
+			return []byte{}, errors.New("The shares are not from the same split")
+		}
+		if seen[share[1]] || share[1] == 0 {
+			return []byte{}, fmt.Errorf("Share %d was provided more than once", share[1])
+		}
+		seen[share[1]] = true
+	}
+	if len(shares) < threshold {
+		return []byte{}, fmt.Errorf("%d shares are required but only %d were provided", threshold, len(shares))
+	}
+	shares = shares[:threshold]
+
+	secret := make([]byte, length-2)
+	for i, share := range shares {
+		// lagrange basis polynomial for this share evaluated at 0
+		basis := byte(1)
+		for j, other := range shares {
+			if i == j {
+				continue
+			}
+			basis = gfMul(basis, gfDiv(other[1], other[1]^share[1]))
+		}
+		for b := range secret {
+			secret[b] ^= gfMul(basis, share[b+2])
+		}
+	}
+	return secret, nil
+}
@@ -0,0 +1,135 @@
+package backup
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/skip2/go-qrcode"
+)
+
+const sheetPrefix = "ejrnl-backup"
+const sheetVersion = "1"
+
+// Sheet contains everything that is needed to recover access to a journal, or one share of it.
+type Sheet struct {
+	Salt string
+	Pow  uint
+	// Share is the journal's key as produced by Split
+	Share []byte
+}
+
+// Threshold returns the number of sheets that are required to recover the key.
+func (s Sheet) Threshold() int {
+	return int(s.Share[0])
+}
+
+// Index returns the sheet's share number.
+func (s Sheet) Index() int {
+	return int(s.Share[1])
+}
+
+// NewSheets creates count recovery sheets for the key, any threshold of which can recover it.
+func NewSheets(key []byte, salt string, pow uint, count, threshold int) ([]Sheet, error) {
+	shares, err := Split(key, count, threshold)
+	if err != nil {
+		return []Sheet{}, err
+	}
+	if _, err = EncodeWords(shares[0]); err != nil {
+		return []Sheet{}, err
+	}
+	sheets := make([]Sheet, len(shares))
+	for i, share := range shares {
+		sheets[i] = Sheet{Salt: salt, Pow: pow, Share: share}
+	}
+	return sheets, nil
+}
+
+// Recover combines the sheets and returns the salt, work factor and key of the journal.
+func Recover(sheets []Sheet) (string, uint, []byte, error) {
+	if len(sheets) == 0 {
+		return "", 0, []byte{}, errors.New("No sheets were provided")
+	}
+	shares := make([][]byte, len(sheets))
+	for i, sheet := range sheets {
+		if sheet.Salt != sheets[0].Salt || sheet.Pow != sheets[0].Pow {
+			return "", 0, []byte{}, errors.New("The sheets are from different journals")
+		}
+		shares[i] = sheet.Share
+	}
+	key, err := Combine(shares)
+	return sheets[0].Salt, sheets[0].Pow, key, err
+}
+
+// Words returns the sheet's share as a checksummed list of words.
+func (s Sheet) Words() []string {
+	// NewSheets and ParseWords have already checked that the share can be encoded
+	words, _ := EncodeWords(s.Share)
+	return words
+}
+
+// String returns the sheet in the single line format that is stored in the QR code.
+func (s Sheet) String() string {
+	return fmt.Sprintf("%s %s %d %s %s", sheetPrefix, sheetVersion, s.Pow, s.Salt, strings.Join(s.Words(), " "))
+}
+
+// IsSheet returns whether the line is in the format produced by Sheet.String.
+func IsSheet(line string) bool {
+	return strings.HasPrefix(strings.TrimSpace(line), sheetPrefix+" ")
+}
+
+// ParseSheet parses a sheet in the format produced by Sheet.String.
+func ParseSheet(line string) (Sheet, error) {
+	fields := strings.Fields(line)
+	if len(fields) < 5 || fields[0] != sheetPrefix {
+		return Sheet{}, errors.New("The line isn't an ejrnl backup")
+	}
+	if fields[1] != sheetVersion {
+		return Sheet{}, fmt.Errorf("Unsupported backup version %s", fields[1])
+	}
+	pow, err := strconv.ParseUint(fields[2], 10, 32)
+	if err != nil {
+		return Sheet{}, fmt.Errorf("Failed to parse the work factor because %s", err)
+	}
+	return ParseWords(strings.Join(fields[4:], " "), fields[3], uint(pow))
+}
+
+// ParseWords parses a sheet from its word list. The salt and work factor must be provided
+// separately.
+func ParseWords(line, salt string, pow uint) (Sheet, error) {
+	share, err := DecodeWords(strings.Fields(line))
+	if err != nil {
+		return Sheet{}, err
+	}
+	if len(share) < 3 || share[0] == 0 || share[1] == 0 {
+		return Sheet{}, errors.New("The words don't contain a valid key")
+	}
+	return Sheet{Salt: salt, Pow: pow, Share: share}, nil
+}
+
+// Render writes a printable version of the sheet, including a QR code of Sheet.String.
+func (s Sheet) Render(w io.Writer) error {
+	code, err := qrcode.New(s.String(), qrcode.Medium)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintln(w, "ejrnl key recovery sheet")
+	if s.Threshold() > 1 {
+		fmt.Fprintf(w, "Share %d, %d shares are required to recover the key\n", s.Index(), s.Threshold())
+	}
+	fmt.Fprintf(w, "Salt: %s\n", s.Salt)
+	fmt.Fprintf(w, "Work factor: %d\n\n", s.Pow)
+	for i, word := range s.Words() {
+		fmt.Fprintf(w, "%2d. %-10s", i+1, word)
+		if i%4 == 3 {
+			fmt.Fprintln(w)
+		}
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w)
+	_, err = fmt.Fprint(w, code.ToSmallString(false))
+	return err
+}
@@ -0,0 +1,104 @@
+package backup
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// checksumBits returns the number of checksum bits that are appended to data of the specified
+// length. It is the smallest number, that is at least 4, which makes the total a multiple of 11
+// bits. For 16 to 32 byte keys this is the same as bip39.
+func checksumBits(length int) uint {
+	bits := uint(4)
+	for (uint(length)*8+bits)%11 != 0 {
+		bits++
+	}
+	return bits
+}
+
+// EncodeWords encodes data as a list of words. The end of the list contains a checksum so that
+// transcription errors can be detected. Lengths that would need more than 11 checksum bits can't
+// be told apart from the next length when decoding so, they are rejected.
+func EncodeWords(data []byte) ([]string, error) {
+	csBits := checksumBits(len(data))
+	if len(data) == 0 || csBits > 11 {
+		return []string{}, fmt.Errorf("Can't encode %d bytes as words", len(data))
+	}
+	sum := sha256.Sum256(data)
+	total := uint(len(data))*8 + csBits
+
+	bit := func(i uint) uint {
+		if i < uint(len(data))*8 {
+			return uint(data[i/8]>>(7-i%8)) & 1
+		}
+		i -= uint(len(data)) * 8
+		return uint(sum[i/8]>>(7-i%8)) & 1
+	}
+
+	encoded := make([]string, 0, total/11)
+	for i := uint(0); i < total; i += 11 {
+		index := uint(0)
+		for j := uint(0); j < 11; j++ {
+			index = index<<1 | bit(i+j)
+		}
+		encoded = append(encoded, wordList[index])
+	}
+	return encoded, nil
+}
+
+// DecodeWords reverses EncodeWords. Only the first 4 letters of each word are significant so,
+// abbreviated words are accepted.
+func DecodeWords(encoded []string) ([]byte, error) {
+	if len(encoded) == 0 {
+		return []byte{}, errors.New("No words to decode")
+	}
+	totalBits := uint(len(encoded)) * 11
+	length := int(totalBits / 8)
+	for length > 0 && (uint(length)*8+checksumBits(length) != totalBits || checksumBits(length) > 11) {
+		length--
+	}
+	if length == 0 {
+		return []byte{}, fmt.Errorf("%d words isn't a valid length", len(encoded))
+	}
+
+	bits := make([]byte, 0, totalBits)
+	for _, word := range encoded {
+		index, err := wordIndex(word)
+		if err != nil {
+			return []byte{}, err
+		}
+		for j := 10; j >= 0; j-- {
+			bits = append(bits, byte(index>>uint(j))&1)
+		}
+	}
+
+	data := make([]byte, length)
+	for i := range data {
+		for j := 0; j < 8; j++ {
+			data[i] = data[i]<<1 | bits[i*8+j]
+		}
+	}
+
+	sum := sha256.Sum256(data)
+	for i := uint(0); i < checksumBits(length); i++ {
+		if bits[uint(length)*8+i] != (sum[i/8]>>(7-i%8))&1 {
+			return []byte{}, errors.New("The checksum didn't match, one or more words are incorrect")
+		}
+	}
+	return data, nil
+}
+
+func wordIndex(word string) (int, error) {
+	word = strings.ToLower(strings.TrimSpace(word))
+	if len(word) < 3 {
+		return 0, fmt.Errorf("%q isn't a valid word", word)
+	}
+	for i, candidate := range wordList {
+		if candidate == word || (len(word) >= 4 && len(candidate) >= 4 && candidate[:4] == word[:4]) {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("%q isn't a valid word", word)
+}
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"bufio"
+	"context"
 	"errors"
 	"fmt"
 	"io/ioutil"
@@ -11,12 +13,16 @@ import (
 	"path"
 	"strings"
 	"syscall"
+	"time"
 
 	"github.com/howeyc/gopass"
 	"github.com/urfave/cli"
 	"gopkg.in/yaml.v2"
 
 	"github.com/btobolaski/ejrnl"
+	"github.com/btobolaski/ejrnl/backup"
+	"github.com/btobolaski/ejrnl/compression"
+	"github.com/btobolaski/ejrnl/crypto"
 	"github.com/btobolaski/ejrnl/server"
 	"github.com/btobolaski/ejrnl/storage"
 	"github.com/btobolaski/ejrnl/workflows"
@@ -24,10 +30,106 @@ import (
 
 var version = "0.0.1"
 
+// notebook is the name of the notebook selected with --notebook. readConfig and writeConfig use its
+// config instead of the default notebook's.
+var notebook string
+
 var tempFlag = cli.StringFlag{
-	Name:  "temp-dir",
-	Usage: "Specifies the temporary directory to write the temporary files to.",
-	Value: os.TempDir(),
+	Name: "temp-dir",
+	Usage: "Specifies the directory that entries are written to, unencrypted, while they're edited. By default a " +
+		"memory backed directory, such as /dev/shm, is used",
+	EnvVar: "EJRNL_TEMP_DIR",
+}
+
+// journalFlags configure a new journal. They're read by newConfig.
+var journalFlags = []cli.Flag{
+	cli.UintFlag{
+		Name:  "pow",
+		Usage: "Configures the workfactor for scrypt",
+		Value: 19,
+	},
+	cli.StringFlag{
+		Name:  "destination",
+		Usage: "Configures where the journal is stored",
+		Value: "~/journal",
+	},
+	cli.StringFlag{
+		Name:  "compression",
+		Usage: fmt.Sprintf("Configures the compression codec, one of %s", strings.Join(compression.Codecs(), ", ")),
+		Value: compression.DefaultCodec,
+	},
+	cli.StringFlag{
+		Name:  "padding",
+		Usage: "Pads entries to hide their length, one of none, pow2 or a bucket size in bytes",
+		Value: "none",
+	},
+	cli.StringFlag{
+		Name:  "backend",
+		Usage: "Configures how the journal is stored, one of files, sqlite or s3",
+		Value: storage.FilesBackend,
+	},
+	cli.StringFlag{
+		Name:  "s3-endpoint",
+		Usage: "The url of the S3 compatible service for the s3 backend",
+		Value: "https://s3.amazonaws.com",
+	},
+	cli.StringFlag{
+		Name:  "s3-region",
+		Usage: "The region of the bucket for the s3 backend",
+		Value: "us-east-1",
+	},
+	cli.StringFlag{
+		Name:  "s3-bucket",
+		Usage: "The bucket for the s3 backend",
+	},
+	cli.StringFlag{
+		Name:  "s3-prefix",
+		Usage: "Prepended to the names of the journal's objects for the s3 backend",
+	},
+	cli.BoolFlag{
+		Name:  "git",
+		Usage: "Commits every change to git. Only supported by the files backend",
+	},
+	cli.StringFlag{
+		Name:  "git-remote",
+		Usage: "The repository, which can be a local path, that push and pull use",
+	},
+	cli.BoolFlag{
+		Name:  "secure-delete",
+		Usage: "Overwrites files before they're removed or replaced. " + crypto.ShredLimits,
+	},
+}
+
+// filterFlags select entries by their metadata. They're read by readFilter.
+var filterFlags = []cli.Flag{
+	cli.StringSliceFlag{
+		Name:  "tag",
+		Usage: "Only entries with the tag. Can be repeated",
+	},
+	cli.StringFlag{
+		Name:  "title",
+		Usage: "Only entries whose title contains this",
+	},
+	cli.StringFlag{
+		Name:  "location",
+		Usage: "Only entries whose location's name contains this",
+	},
+	cli.StringFlag{
+		Name:  "mood",
+		Usage: "Only entries with this mood",
+	},
+	cli.StringSliceFlag{
+		Name:  "field",
+		Usage: "Only entries with the field, as name=value or just name for any value. Can be repeated",
+	},
+	cli.StringFlag{
+		Name:  "since",
+		Usage: "Only entries from this day, as 2006-01-02, or later",
+	},
+	cli.StringFlag{
+		Name:  "until",
+		Usage: "Only entries from this day, as 2006-01-02, or earlier",
+	},
 }
 
 func main() {
@@ -48,6 +150,14 @@ func main() {
 			Usage: "Specifies the config file to use",
 			Value: "~/.config/ejrnl/ejrnl.yml",
 		},
+		cli.StringFlag{
+			Name:  "notebook",
+			Usage: "The name of the notebook to use instead of the default",
+		},
+		cli.StringFlag{
+			Name:  "timezone",
+			Usage: "Show dates in the local zone or the original zone that each entry was written in. Overrides the config file",
+		},
 	}
 	app.Before = func(c *cli.Context) error {
 		user, err := user.Current()
@@ -55,61 +165,102 @@ func main() {
 			return err
 		}
 		configPath = strings.Replace(c.String("config"), "~", user.HomeDir, -1)
+		notebook = c.String("notebook")
 		return nil
 	}
 	app.Commands = []cli.Command{
 		{
 			Name:  "init",
 			Usage: "Creates a new journal",
-			Flags: []cli.Flag{
-				cli.UintFlag{
-					Name:  "pow",
-					Usage: "Configures the workfactor for scrypt",
-					Value: 19,
-				},
-				cli.StringFlag{
-					Name:  "destination",
-					Usage: "Configures where the journal is stored",
-					Value: "~/journal",
-				},
-			},
+			Flags: journalFlags,
 			Action: func(c *cli.Context) error {
-				if err := os.MkdirAll(path.Dir(configPath), 0750); err != nil {
-					return err
-				}
 				if _, err := os.Stat(configPath); !os.IsNotExist(err) {
 					return errors.New("Configuration directory already exists")
 				}
-				config := workflows.DefaultConfig()
-				config.StorageDirectory = c.String("destination")
-				config.Pow = c.Uint("pow")
-				data, err := yaml.Marshal(config)
-				if err != nil {
-					return err
-				}
-				err = ioutil.WriteFile(configPath, data, 0600)
+				config, err := newConfig(c, c.String("destination"))
 				if err != nil {
 					return err
 				}
-				password, err := getPassword("Password: ")
-				if err != nil {
-					return err
-				}
-				confirm, err := getPassword("Confirm:   ")
-				if err != nil {
-					return err
-				}
-				if password != confirm {
-					return errors.New("Passwords didn't match")
-				}
-				confirm = ""
-
-				driver, err := storage.NewDriver(config, password)
-				password = ""
-				if _, ok := err.(*storage.NeedsInit); !ok {
+				if err = writeConfig(configPath, config); err != nil {
 					return err
 				}
-				return workflows.Init(driver)
+				return createJournal(config)
+			},
+		},
+		{
+			Name:  "notebooks",
+			Usage: "Manages the notebooks, separate journals configured in the same file",
+			Subcommands: []cli.Command{
+				{
+					Name:  "list",
+					Usage: "Lists the notebooks and where they're stored",
+					Action: func(c *cli.Context) error {
+						file, err := readConfigFile(configPath)
+						if err != nil {
+							return err
+						}
+						for _, name := range file.NotebookNames() {
+							config, _ := file.Notebook(name)
+							fmt.Printf("%s - %s\n", name, config.StorageDirectory)
+						}
+						return nil
+					},
+				},
+				{
+					Name:  "add",
+					Usage: "Creates a new notebook. Takes the notebook's name as an argument.",
+					Flags: journalFlags,
+					Action: func(c *cli.Context) error {
+						if len(c.Args()) != 1 {
+							return errors.New("add takes 1 argument which is the notebook's name")
+						}
+						name := c.Args()[0]
+						file, err := readConfigFile(configPath)
+						if err != nil {
+							return err
+						}
+						destination := c.String("destination")
+						if !c.IsSet("destination") {
+							destination = fmt.Sprintf("%s-%s", destination, name)
+						}
+						config, err := newConfig(c, destination)
+						if err != nil {
+							return err
+						}
+						if err = file.AddNotebook(name, config); err != nil {
+							return err
+						}
+						if err = writeConfigFile(configPath, file); err != nil {
+							return err
+						}
+						return createJournal(config)
+					},
+				},
+				{
+					Name:  "remove",
+					Usage: "Removes a notebook from the config. Its journal isn't deleted. Takes the notebook's name as an argument.",
+					Action: func(c *cli.Context) error {
+						if len(c.Args()) != 1 {
+							return errors.New("remove takes 1 argument which is the notebook's name")
+						}
+						file, err := readConfigFile(configPath)
+						if err != nil {
+							return err
+						}
+						config, err := file.Notebook(c.Args()[0])
+						if err != nil {
+							return err
+						}
+						if err = file.RemoveNotebook(c.Args()[0]); err != nil {
+							return err
+						}
+						if err = writeConfigFile(configPath, file); err != nil {
+							return err
+						}
+						fmt.Printf("Removed the notebook. Its journal is still at %s\n", config.StorageDirectory)
+						return nil
+					},
+				},
 			},
 		},
 		{
@@ -123,6 +274,7 @@ func main() {
 				if err != nil {
 					return err
 				}
+				defer driver.Close()
 
 				return workflows.Import(c.Args()[0], driver)
 			},
@@ -130,39 +282,126 @@ func main() {
 		{
 			Name:  "print",
 			Usage: "Prints out the most recent entries",
-			Flags: []cli.Flag{
+			Flags: append([]cli.Flag{
 				cli.IntFlag{
 					Name:  "count",
 					Usage: "The number of entries to output. If it is 0 or less, all entries are output",
 					Value: 0,
 				},
-			},
+			}, filterFlags...),
 			Action: func(c *cli.Context) error {
 				driver, err := standardLoad(configPath)
 				if err != nil {
 					return err
 				}
+				defer driver.Close()
 
-				return workflows.Print(driver, c.Int("count"))
+				ctx, stop := interruptContext()
+				defer stop()
+				filter, err := readFilter(c, configPath)
+				if err != nil {
+					return err
+				}
+				return workflows.Print(ctx, driver, c.Int("count"), filter)
 			},
 		},
 		{
 			Name:  "list",
-			Usage: "Lists the ids and dates of the most recent entries",
-			Flags: []cli.Flag{
+			Usage: "Lists the ids, dates and titles of the most recent entries",
+			Flags: append([]cli.Flag{
 				cli.IntFlag{
 					Name:  "count",
 					Value: 0,
 					Usage: "The number of results to return. If it is <= 0, it returns all of the entries",
 				},
+			}, filterFlags...),
+			Action: func(c *cli.Context) error {
+				driver, err := standardLoad(configPath)
+				if err != nil {
+					return err
+				}
+				defer driver.Close()
+
+				ctx, stop := interruptContext()
+				defer stop()
+				filter, err := readFilter(c, configPath)
+				if err != nil {
+					return err
+				}
+				return workflows.ListEntries(ctx, driver, c.Int("count"), filter)
+			},
+		},
+		{
+			Name:  "backlinks",
+			Usage: "Lists the entries that link to an entry. Takes an id as an argument.",
+			Action: func(c *cli.Context) error {
+				if len(c.Args()) != 1 {
+					return errors.New("backlinks takes 1 argument which is an entry's id")
+				}
+				driver, err := standardLoad(configPath)
+				if err != nil {
+					return err
+				}
+				defer driver.Close()
+
+				ctx, stop := interruptContext()
+				defer stop()
+				zone, err := displayZone(c, configPath)
+				if err != nil {
+					return err
+				}
+				return workflows.PrintBacklinks(ctx, driver, c.Args()[0], zone)
+			},
+		},
+		{
+			Name:  "train-dictionary",
+			Usage: "Trains a new zstd dictionary from the journal's entries which is used for new writes",
+			Action: func(c *cli.Context) error {
+				driver, err := standardLoad(configPath)
+				if err != nil {
+					return err
+				}
+				defer driver.Close()
+
+				files, ok := driver.(*storage.Driver)
+				if !ok {
+					return errors.New("Dictionaries are only supported by the files backend")
+				}
+				id, err := files.TrainDictionary()
+				if err != nil {
+					return err
+				}
+				fmt.Printf("Trained dictionary %d\n", id)
+				return nil
+			},
+		},
+		{
+			Name:  "pack",
+			Usage: "Moves older entries out of their own files and into packs",
+			Flags: []cli.Flag{
+				cli.IntFlag{
+					Name:  "days",
+					Value: 30,
+					Usage: "Entries dated more than this many days ago are packed",
+				},
 			},
 			Action: func(c *cli.Context) error {
 				driver, err := standardLoad(configPath)
 				if err != nil {
 					return err
 				}
+				defer driver.Close()
 
-				return workflows.ListEntries(driver, c.Int("count"))
+				files, ok := driver.(*storage.Driver)
+				if !ok {
+					return errors.New("Packs are only supported by the files backend")
+				}
+				result, err := files.Pack(time.Now().AddDate(0, 0, -c.Int("days")))
+				if err != nil {
+					return err
+				}
+				fmt.Printf("Packed %d entries and repacked %d packs\n", result.Packed, result.Repacked)
+				return nil
 			},
 		},
 		{
@@ -170,11 +409,21 @@ func main() {
 			Usage: "Creates a new entry",
 			Flags: []cli.Flag{tempFlag},
 			Action: func(c *cli.Context) error {
-				driver, err := standardLoad(configPath)
+				config, err := readConfig(configPath)
 				if err != nil {
 					return err
 				}
-				return workflows.NewEntry(driver, c.String("temp-dir"))
+				driver, err := loadWithConfig(config)
+				if err != nil {
+					return err
+				}
+				defer driver.Close()
+				warnSecureDelete(config)
+				drafts, err := draftsFor(c, config, driver)
+				if err != nil {
+					return err
+				}
+				return workflows.NewEntry(driver, drafts)
 			},
 		},
 		{
@@ -185,17 +434,176 @@ func main() {
 				if len(c.Args()) != 1 {
 					return errors.New("edit takes 1 argument which is an entry's id")
 				}
+				config, err := readConfig(configPath)
+				if err != nil {
+					return err
+				}
+				driver, err := loadWithConfig(config)
+				if err != nil {
+					return err
+				}
+				defer driver.Close()
+				warnSecureDelete(config)
+				drafts, err := draftsFor(c, config, driver)
+				if err != nil {
+					return err
+				}
+				return workflows.EditEntry(driver, c.Args()[0], drafts)
+			},
+		},
+		{
+			Name:  "drafts",
+			Usage: "Manages the encrypted drafts that are kept when an entry can't be saved",
+			Subcommands: []cli.Command{
+				{
+					Name:  "list",
+					Usage: "Lists the drafts",
+					Action: func(c *cli.Context) error {
+						config, err := readConfig(configPath)
+						if err != nil {
+							return err
+						}
+						drafts, err := draftsFor(c, config, nil)
+						if err != nil {
+							return err
+						}
+						names, err := drafts.List()
+						for _, name := range names {
+							fmt.Println(name)
+						}
+						return err
+					},
+				},
+				{
+					Name:  "resume",
+					Usage: "Opens a draft in your editor and then adds it to the journal. Takes the draft's name as an argument",
+					Flags: []cli.Flag{tempFlag},
+					Action: func(c *cli.Context) error {
+						if len(c.Args()) != 1 {
+							return errors.New("resume takes 1 argument which is a draft's name")
+						}
+						config, err := readConfig(configPath)
+						if err != nil {
+							return err
+						}
+						driver, err := loadWithConfig(config)
+						if err != nil {
+							return err
+						}
+						defer driver.Close()
+						drafts, err := draftsFor(c, config, driver)
+						if err != nil {
+							return err
+						}
+						return drafts.Resume(driver, c.Args()[0])
+					},
+				},
+				{
+					Name:  "discard",
+					Usage: "Removes a draft. Takes the draft's name as an argument",
+					Action: func(c *cli.Context) error {
+						if len(c.Args()) != 1 {
+							return errors.New("discard takes 1 argument which is a draft's name")
+						}
+						config, err := readConfig(configPath)
+						if err != nil {
+							return err
+						}
+						drafts, err := draftsFor(c, config, nil)
+						if err != nil {
+							return err
+						}
+						warnSecureDelete(config)
+						return drafts.Discard(c.Args()[0])
+					},
+				},
+			},
+		},
+		{
+			Name:  "delete",
+			Usage: "Moves an entry to the trash. Takes an id as an argument.",
+			Action: func(c *cli.Context) error {
+				if len(c.Args()) != 1 {
+					return errors.New("delete takes 1 argument which is an entry's id")
+				}
 				driver, err := standardLoad(configPath)
 				if err != nil {
 					return err
 				}
-				return workflows.EditEntry(driver, c.Args()[0], c.String("temp-dir"))
+				defer driver.Close()
+				ctx, stop := interruptContext()
+				defer stop()
+				return workflows.Delete(ctx, driver, c.Args()[0])
+			},
+		},
+		{
+			Name:  "trash",
+			Usage: "Manages deleted entries, which are purged once they've been in the trash for the configured number of days",
+			Subcommands: []cli.Command{
+				{
+					Name:  "list",
+					Usage: "Lists the entries in the trash",
+					Action: func(c *cli.Context) error {
+						zone, err := displayZone(c, configPath)
+						if err != nil {
+							return err
+						}
+						driver, err := standardLoad(configPath)
+						if err != nil {
+							return err
+						}
+						defer driver.Close()
+						ctx, stop := interruptContext()
+						defer stop()
+						return workflows.PrintTrash(ctx, driver, zone)
+					},
+				},
+				{
+					Name:  "restore",
+					Usage: "Moves an entry out of the trash. Takes an id as an argument.",
+					Action: func(c *cli.Context) error {
+						if len(c.Args()) != 1 {
+							return errors.New("restore takes 1 argument which is an entry's id")
+						}
+						driver, err := standardLoad(configPath)
+						if err != nil {
+							return err
+						}
+						defer driver.Close()
+						ctx, stop := interruptContext()
+						defer stop()
+						return workflows.Restore(ctx, driver, c.Args()[0])
+					},
+				},
+				{
+					Name:  "empty",
+					Usage: "Permanently removes every entry in the trash",
+					Action: func(c *cli.Context) error {
+						config, err := readConfig(configPath)
+						if err != nil {
+							return err
+						}
+						driver, err := loadWithConfig(config)
+						if err != nil {
+							return err
+						}
+						defer driver.Close()
+						warnSecureDelete(config)
+						ctx, stop := interruptContext()
+						defer stop()
+						purged, err := workflows.EmptyTrash(ctx, driver)
+						if err != nil {
+							return err
+						}
+						fmt.Printf("Removed %d entries\n", purged)
+						return nil
+					},
+				},
 			},
 		},
 		{
 			Name:  "rekey",
 			Usage: "reencrypts journal with a new password",
-			Flags: []cli.Flag{tempFlag},
 			Action: func(c *cli.Context) error {
 				config, err := readConfig(configPath)
 				if err != nil {
@@ -205,32 +613,250 @@ func main() {
 				if err != nil {
 					return err
 				}
-				oldDriver, err := storage.NewDriver(config, password)
+				// The duress password destroys the keys here too
+				oldDriver, err := openJournal(config, password)
+				password.Close()
+				if err != nil {
+					return err
+				}
+				defer oldDriver.Close()
+
+				return rekey(config, oldDriver)
+			},
+		},
+		{
+			Name:  "migrate",
+			Usage: "Rewrites every entry with the configured compression and padding",
+			Action: func(c *cli.Context) error {
+				driver, err := standardLoad(configPath)
+				if err != nil {
+					return err
+				}
+				defer driver.Close()
+				ctx, stop := interruptContext()
+				defer stop()
+				return workflows.Migrate(ctx, driver)
+			},
+		},
+		{
+			Name:  "migrate-backend",
+			Usage: "Moves the journal to another storage backend",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "to",
+					Usage: "The backend to move the journal to, either files or sqlite",
+				},
+			},
+			Action: func(c *cli.Context) error {
+				config, err := readConfig(configPath)
+				if err != nil {
+					return err
+				}
+				backend := c.String("to")
+				if backend == storage.S3Backend {
+					return errors.New("Journals can't be migrated to s3, only to files or sqlite")
+				} else if backend != storage.FilesBackend && backend != storage.SQLiteBackend {
+					return fmt.Errorf("Unknown storage backend %s", backend)
+				}
+				driver, err := loadWithConfig(config)
+				if err != nil {
+					return err
+				}
+				defer driver.Close()
+
+				if err = migrateBackend(config, driver, backend); err != nil {
+					return err
+				}
+				config.Backend = backend
+				return writeConfig(configPath, config)
+			},
+		},
+		{
+			Name:  "log",
+			Usage: "Lists the journal's history when it's stored in git",
+			Action: func(c *cli.Context) error {
+				driver, err := loadGit(configPath)
+				if err != nil {
+					return err
+				}
+				defer driver.Close()
+				changes, err := driver.Log()
+				if err != nil {
+					return err
+				}
+				for _, change := range changes {
+					fmt.Printf("%s %s %s\n", change.Commit[:12], change.Date.Local().Format(time.RFC3339), strings.Join(change.Ids, " "))
+				}
+				return nil
+			},
+		},
+		{
+			Name:  "push",
+			Usage: "Pushes the journal's history to its git remote",
+			Action: func(c *cli.Context) error {
+				driver, err := loadGit(configPath)
+				if err != nil {
+					return err
+				}
+				defer driver.Close()
+				if err = driver.Push(); err != nil {
+					return err
+				}
+				if driver.HasKeySlots() {
+					fmt.Fprintln(os.Stderr, "The remote doesn't have the journal's keyslots file so, it can't be decrypted with the")
+					fmt.Fprintln(os.Stderr, "password alone. Keep a recovery sheet from ejrnl backup-key to restore the journal from it.")
+				}
+				return nil
+			},
+		},
+		{
+			Name:  "pull",
+			Usage: "Merges the history from the journal's git remote",
+			Action: func(c *cli.Context) error {
+				driver, err := loadGit(configPath)
+				if err != nil {
+					return err
+				}
+				defer driver.Close()
+				return driver.Pull()
+			},
+		},
+		{
+			Name:  "backup-key",
+			Usage: "Prints recovery sheets for the journal's key",
+			Flags: []cli.Flag{
+				cli.IntFlag{
+					Name:  "shares",
+					Usage: "The number of sheets to split the key into",
+					Value: 1,
+				},
+				cli.IntFlag{
+					Name:  "threshold",
+					Usage: "The number of sheets that are required to recover the key",
+					Value: 1,
+				},
+			},
+			Action: func(c *cli.Context) error {
+				config, err := readConfig(configPath)
+				if err != nil {
+					return err
+				}
+				driver, err := loadWithConfig(config)
 				if err != nil {
 					return err
 				}
+				defer driver.Close()
+				return workflows.BackupKey(driver.Key(), config, c.Int("shares"), c.Int("threshold"), os.Stdout)
+			},
+		},
+		{
+			Name:  "recover-key",
+			Usage: "Restores access to a journal from recovery sheets",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "destination",
+					Usage: "Where the journal is stored. Only used if the config file doesn't exist",
+					Value: "~/journal",
+				},
+				cli.StringFlag{
+					Name:  "salt",
+					Usage: "The salt from the sheet, only needed when entering word lists",
+				},
+				cli.UintFlag{
+					Name:  "pow",
+					Usage: "The work factor from the sheet, only needed when entering word lists",
+					Value: 19,
+				},
+				cli.BoolFlag{
+					Name:  "rekey",
+					Usage: "Sets a new password after recovering the key",
+				},
+			},
+			Action: func(c *cli.Context) error {
+				config, err := readConfig(configPath)
+				missingConfig := os.IsNotExist(err)
+				if err != nil && !missingConfig {
+					return err
+				}
+				if missingConfig {
+					config.StorageDirectory = c.String("destination")
+				}
 
-				tempConfig := config
-				tempConfig.StorageDirectory = fmt.Sprintf("%s/new-ejrnl", c.String("temp-dir"))
-				password, err = getPassword("New Password: ")
+				salt, pow := c.String("salt"), c.Uint("pow")
+				if salt == "" && !missingConfig {
+					salt, pow = config.Salt, config.Pow
+				}
+				sheets, err := readSheets(salt, pow)
+				if err != nil {
+					return err
+				}
+				salt, pow, key, err := backup.Recover(sheets)
 				if err != nil {
 					return err
 				}
-				confirm, err := getPassword("Confirm:      ")
+				if !missingConfig && (salt != config.Salt || pow != config.Pow) {
+					return errors.New("The recovery sheets don't belong to the configured journal")
+				}
+				config.Salt = salt
+				config.Pow = pow
+
+				if err = finishSwap(config); err != nil {
+					return err
+				}
+				driver, err := storage.OpenWithKey(config, crypto.SecretFromBytes(key))
+				if err != nil {
+					driver.Close()
+					return fmt.Errorf("Failed to open the journal with the recovered key because %s", err)
+				}
+				defer driver.Close()
+				fmt.Println("Recovered the journal's key")
+
+				if missingConfig {
+					if err = writeConfig(configPath, config); err != nil {
+						return err
+					}
+					fmt.Printf("Restored the configuration file at %s\n", configPath)
+				}
+				if c.Bool("rekey") {
+					return rekey(config, driver)
+				}
+				return nil
+			},
+		},
+		{
+			Name:  "destroy",
+			Usage: "Permanently destroys the journal's keys so that it can never be decrypted",
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  "set-duress",
+					Usage: "Configures a duress password which destroys the journal when it is entered",
+				},
+			},
+			Action: func(c *cli.Context) error {
+				config, err := readConfig(configPath)
 				if err != nil {
 					return err
 				}
-				if password != confirm {
-					return errors.New("Passwords didn't match")
+				if c.Bool("set-duress") {
+					return setDuress(configPath, config)
 				}
-				newDriver, err := storage.NewDriver(tempConfig, password)
-				if _, ok := err.(*storage.NeedsInit); !ok {
+
+				fmt.Printf("This will destroy the keys for the journal at %s. It will be impossible to\n", config.StorageDirectory)
+				fmt.Println("decrypt it afterwards, even with the password or a recovery sheet.")
+				fmt.Println(crypto.ShredLimits)
+				fmt.Print("Type the journal's directory to confirm: ")
+				confirmation, err := bufio.NewReader(os.Stdin).ReadString('\n')
+				if err != nil {
 					return err
 				}
-				if err = newDriver.Init(); err != nil {
+				if strings.TrimSpace(confirmation) != config.StorageDirectory {
+					return errors.New("Confirmation didn't match, the journal wasn't destroyed")
+				}
+				if err = storage.Destroy(config); err != nil {
 					return err
 				}
-				return workflows.Rekey(oldDriver, newDriver, config.StorageDirectory, tempConfig.StorageDirectory)
+				fmt.Println("The journal's keys have been destroyed. The remaining files can be deleted normally.")
+				return nil
 			},
 		},
 		{
@@ -244,21 +870,51 @@ func main() {
 				},
 			},
 			Action: func(c *cli.Context) error {
+				zone, err := displayZone(c, configPath)
+				if err != nil {
+					return err
+				}
+				file, err := readConfigFile(configPath)
+				if err != nil {
+					return err
+				}
 				driver, err := standardLoad(configPath)
 				if err != nil {
 					return err
 				}
 				conf := server.Config{
-					Port:     c.Int("port"),
-					Username: "ejrnl",
-					Password: workflows.MakeSalt(15),
+					Port:      c.Int("port"),
+					Username:  "ejrnl",
+					Password:  workflows.MakeSalt(15),
+					Zone:      zone,
+					Notebook:  notebook,
+					Notebooks: file.NotebookNames(),
+					Open: func(name string, password *crypto.Secret) (ejrnl.Driver, error) {
+						config, err := file.Notebook(name)
+						if err != nil {
+							return nil, err
+						}
+						driver, err := openJournal(config, password)
+						if err != nil {
+							if driver != nil {
+								driver.Close()
+							}
+							return nil, err
+						}
+						return driver, nil
+					},
+				}
+				if conf.Notebook == "" {
+					conf.Notebook = ejrnl.DefaultNotebook
 				}
 				s, err := server.New(driver, conf)
 				if err != nil {
+					driver.Close()
 					return err
 				}
 				err = s.Start()
 				if err != nil {
+					driver.Close()
 					return err
 				}
 				fmt.Printf("Started server on port %d\n", conf.Port)
@@ -267,7 +923,7 @@ func main() {
 				signalChan := make(chan os.Signal, 1)
 				signal.Notify(signalChan, os.Interrupt, os.Kill, syscall.SIGTERM)
 				<-signalChan
-				return nil
+				return s.Stop()
 			},
 		},
 	}
@@ -278,13 +934,117 @@ func main() {
 	}
 }
 
-func getPassword(prompt string) (string, error) {
-	fmt.Printf(prompt)
+// readFilter reads the filterFlags. Days are in the zone that dates are shown in.
+func readFilter(c *cli.Context, configPath string) (workflows.Filter, error) {
+	filter := workflows.Filter{
+		Tags:     c.StringSlice("tag"),
+		Title:    c.String("title"),
+		Location: c.String("location"),
+		Mood:     c.String("mood"),
+	}
+	var err error
+	if filter.Zone, err = displayZone(c, configPath); err != nil {
+		return filter, err
+	}
+	if err = filter.SetDays(c.String("since"), c.String("until")); err != nil {
+		return filter, err
+	}
+	for _, field := range c.StringSlice("field") {
+		if err := filter.AddField(field); err != nil {
+			return filter, err
+		}
+	}
+	return filter, nil
+}
+
+// displayZone returns the zone that dates are shown in, from the timezone flag or the config file.
+func displayZone(c *cli.Context, configPath string) (ejrnl.DisplayZone, error) {
+	if zone := c.GlobalString("timezone"); zone != "" {
+		return ejrnl.ParseDisplayZone(zone)
+	}
+	config, err := readConfig(configPath)
+	if err != nil {
+		return ejrnl.LocalZone, err
+	}
+	return ejrnl.ParseDisplayZone(config.Timezone)
+}
+
+// interruptContext returns a context that is canceled when the user presses Ctrl-C so that long
+// running commands stop with the journal in a consistent state instead of being killed part way
+// through. The returned function releases the signal handler.
+func interruptContext() (context.Context, func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case <-signals:
+			fmt.Fprintln(os.Stderr, "Stopping")
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, func() {
+		signal.Stop(signals)
+		cancel()
+	}
+}
+
+// getPassword prompts for a password. The caller must close the returned secret.
+func getPassword(prompt string) (*crypto.Secret, error) {
+	fmt.Print(prompt)
 	raw, err := gopass.GetPasswd()
-	return string(raw), err
+	if err != nil {
+		return nil, err
+	}
+	return crypto.SecretFromBytes(raw), nil
+}
+
+// getConfirmedPassword prompts for a password twice and ensures that they match.
+func getConfirmedPassword(prompt, confirmPrompt string) (*crypto.Secret, error) {
+	password, err := getPassword(prompt)
+	if err != nil {
+		return nil, err
+	}
+	confirm, err := getPassword(confirmPrompt)
+	if err != nil {
+		password.Close()
+		return nil, err
+	}
+	defer confirm.Close()
+	if !password.Equal(confirm) {
+		password.Close()
+		return nil, errors.New("Passwords didn't match")
+	}
+	return password, nil
 }
 
+// readConfig reads the config of the notebook selected with --notebook.
 func readConfig(path string) (ejrnl.Config, error) {
+	file, err := readConfigFile(path)
+	if err != nil {
+		return file, err
+	}
+	return file.Notebook(notebook)
+}
+
+// writeConfig writes the config of the notebook selected with --notebook.
+func writeConfig(configPath string, config ejrnl.Config) error {
+	if notebook == "" || notebook == ejrnl.DefaultNotebook {
+		return writeConfigFile(configPath, config)
+	}
+	file, err := readConfigFile(configPath)
+	if err != nil {
+		return err
+	}
+	if err = file.SetNotebook(notebook, config); err != nil {
+		return err
+	}
+	return writeConfigFile(configPath, file)
+}
+
+// readConfigFile reads the whole config file, including every notebook.
+func readConfigFile(path string) (ejrnl.Config, error) {
 	data, err := ioutil.ReadFile(path)
 	if err != nil {
 		return ejrnl.Config{}, err
@@ -294,14 +1054,301 @@ func readConfig(path string) (ejrnl.Config, error) {
 	return *entry, err
 }
 
-func standardLoad(configPath string) (*storage.Driver, error) {
+// writeConfigFile writes the whole config file, creating its directory if needed.
+func writeConfigFile(configPath string, config ejrnl.Config) error {
+	if err := os.MkdirAll(path.Dir(configPath), 0750); err != nil {
+		return err
+	}
+	data, err := yaml.Marshal(config)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(configPath, data, 0600)
+}
+
+// newConfig creates the config for a new journal stored at destination from the journalFlags.
+func newConfig(c *cli.Context, destination string) (ejrnl.Config, error) {
+	config := workflows.DefaultConfig()
+	config.StorageDirectory = destination
+	config.Pow = c.Uint("pow")
+	config.Compression = c.String("compression")
+	if _, err := compression.Lookup(config.Compression); err != nil {
+		return config, err
+	}
+	config.Padding = c.String("padding")
+	if _, err := compression.ParsePadding(config.Padding); err != nil {
+		return config, err
+	}
+	config.Backend = c.String("backend")
+	switch config.Backend {
+	case storage.FilesBackend:
+		config.Backend = ""
+	case storage.SQLiteBackend:
+	case storage.S3Backend:
+		config.S3 = &ejrnl.S3Config{
+			Endpoint: c.String("s3-endpoint"),
+			Region:   c.String("s3-region"),
+			Bucket:   c.String("s3-bucket"),
+			Prefix:   c.String("s3-prefix"),
+		}
+	default:
+		return config, fmt.Errorf("Unknown storage backend %s", config.Backend)
+	}
+	config.Git = c.Bool("git")
+	config.GitRemote = c.String("git-remote")
+	config.SecureDelete = c.Bool("secure-delete")
+	return config, nil
+}
+
+// warnSecureDelete explains what secure delete can't remove when it's enabled.
+func warnSecureDelete(config ejrnl.Config) {
+	if config.SecureDelete {
+		fmt.Fprintf(os.Stderr, "Secure delete is enabled. %s\n", crypto.ShredLimits)
+	}
+}
+
+// createJournal asks for the new journal's password and then creates it.
+func createJournal(config ejrnl.Config) error {
+	password, err := getConfirmedPassword("Password: ", "Confirm:  ")
+	if err != nil {
+		return err
+	}
+
+	driver, err := storage.Open(config, password)
+	password.Close()
+	if _, ok := err.(*storage.NeedsInit); !ok {
+		return err
+	}
+	defer driver.Close()
+	ctx, stop := interruptContext()
+	defer stop()
+	return workflows.Init(ctx, driver)
+}
+
+// readSheets reads recovery sheets from stdin, one per line, until an empty line. Lines can either
+// be the text from a sheet's QR code or its word list.
+func readSheets(salt string, pow uint) ([]backup.Sheet, error) {
+	fmt.Println("Enter each recovery sheet's words or QR code text on its own line, followed by an empty line:")
+	sheets := []backup.Sheet{}
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			break
+		}
+		var sheet backup.Sheet
+		var err error
+		if backup.IsSheet(line) {
+			sheet, err = backup.ParseSheet(line)
+		} else if salt == "" {
+			err = errors.New("--salt and --pow are required when entering word lists without a config file")
+		} else {
+			sheet, err = backup.ParseWords(line, salt, pow)
+		}
+		if err != nil {
+			return sheets, err
+		}
+		sheets = append(sheets, sheet)
+		if len(sheets) >= sheet.Threshold() {
+			break
+		}
+	}
+	return sheets, scanner.Err()
+}
+
+// rekey reencrypts the journal with a new password. The new journal is written beside the journal
+// so that it can be renamed into place. If a previous rekey was interrupted, it's resumed.
+func rekey(config ejrnl.Config, oldDriver ejrnl.Driver) error {
+	journalDir, tempConfig, err := stagingConfig(config, config.Backend)
+	if err != nil {
+		return err
+	}
+	password, err := getConfirmedPassword("New Password: ", "Confirm:      ")
+	if err != nil {
+		return err
+	}
+	newDriver, err := storage.Open(tempConfig, password)
+	password.Close()
+	ctx, stop := interruptContext()
+	defer stop()
+	if _, ok := err.(*storage.NeedsInit); ok {
+		defer newDriver.Close()
+		if err = workflows.Init(ctx, newDriver); err != nil {
+			return err
+		}
+	} else if err != nil {
+		return fmt.Errorf("Failed to open the new journal that an interrupted rekey left at %s because %s. "+
+			"Enter the same new password to resume it or remove it to start over", tempConfig.StorageDirectory, err)
+	} else {
+		defer newDriver.Close()
+		fmt.Printf("Resuming the rekey in %s\n", tempConfig.StorageDirectory)
+	}
+	warnSecureDelete(config)
+	return workflows.Rekey(ctx, oldDriver, newDriver, journalDir, tempConfig.StorageDirectory, config.SecureDelete)
+}
+
+// migrateBackend copies the journal into a new journal that uses a different backend, with the same
+// key, and replaces the journal with it. If a previous migration was interrupted, it's resumed.
+func migrateBackend(config ejrnl.Config, oldDriver storage.Journal, backend string) error {
+	journalDir, tempConfig, err := stagingConfig(config, backend)
+	if err != nil {
+		return err
+	}
+	key := crypto.SecretFromBytes(append([]byte{}, oldDriver.Key().Bytes()...))
+	newDriver, err := storage.OpenWithKey(tempConfig, key)
+	ctx, stop := interruptContext()
+	defer stop()
+	if _, ok := err.(*storage.NeedsInit); ok {
+		defer newDriver.Close()
+		if err = workflows.Init(ctx, newDriver); err != nil {
+			return err
+		}
+	} else if err != nil {
+		return err
+	} else {
+		defer newDriver.Close()
+		fmt.Printf("Resuming the migration in %s\n", tempConfig.StorageDirectory)
+	}
+	if err = storage.CopyKeySlots(config, tempConfig); err != nil {
+		return err
+	}
+	warnSecureDelete(config)
+	return workflows.MigrateBackend(ctx, oldDriver, newDriver, journalDir, tempConfig.StorageDirectory, config.SecureDelete)
+}
+
+// draftsFor returns where the journal's entries are edited and its drafts are kept. Drafts can only
+// be listed and discarded without the journal's driver.
+func draftsFor(c *cli.Context, config ejrnl.Config, driver storage.Journal) (workflows.Drafts, error) {
+	directory, err := storage.ExpandPath(config.StorageDirectory)
+	drafts := workflows.Drafts{
+		TempDir:   c.String("temp-dir"),
+		Directory: fmt.Sprintf("%s/%s", directory, storage.DraftDirectory),
+		Secure:    config.SecureDelete,
+	}
+	if driver != nil {
+		drafts.Key = driver.Key()
+	}
+	return drafts, err
+}
+
+// stagingConfig returns the journal's expanded directory and the config of the new journal, which
+// uses backend, that a rekey or migration writes. Drafts aren't copied to the new journal so, they
+// have to be resumed or discarded first.
+func stagingConfig(config ejrnl.Config, backend string) (string, ejrnl.Config, error) {
+	directory, err := storage.ExpandPath(config.StorageDirectory)
+	if err != nil {
+		return directory, config, err
+	}
+	// Only the directory is swapped so, the new journal would be written over the bucket's journal
+	if config.Backend == storage.S3Backend || backend == storage.S3Backend {
+		return directory, config, errors.New("Journals stored in S3 can't be rekeyed or migrated because the new journal can't be staged beside them")
+	}
+	drafts := workflows.Drafts{Directory: fmt.Sprintf("%s/%s", directory, storage.DraftDirectory)}
+	if names, err := drafts.List(); err != nil {
+		return directory, config, err
+	} else if len(names) > 0 {
+		return directory, config, errors.New("The journal has drafts, resume or discard them with ejrnl drafts first")
+	}
+	config.StorageDirectory = workflows.StagingDirectory(directory)
+	config.Backend = backend
+	return directory, config, nil
+}
+
+// finishSwap completes replacing the journal if a rekey or migration was interrupted while doing
+// it.
+func finishSwap(config ejrnl.Config) error {
+	directory, err := storage.ExpandPath(config.StorageDirectory)
+	if err != nil {
+		return err
+	}
+	finished, err := workflows.FinishSwap(directory, workflows.StagingDirectory(directory), config.SecureDelete)
+	if finished && err == nil {
+		fmt.Fprintln(os.Stderr, "Finished replacing the journal, which was interrupted")
+	}
+	return err
+}
+
+// loadGit loads a journal that is stored in git.
+func loadGit(configPath string) (*storage.Driver, error) {
+	driver, err := standardLoad(configPath)
+	if err != nil {
+		return &storage.Driver{}, err
+	}
+	files, ok := driver.(*storage.Driver)
+	if !ok {
+		driver.Close()
+		return &storage.Driver{}, errors.New("Only the files backend can be stored in git")
+	}
+	return files, nil
+}
+
+func standardLoad(configPath string) (storage.Journal, error) {
 	config, err := readConfig(configPath)
 	if err != nil {
 		return &storage.Driver{}, err
 	}
+	return loadWithConfig(config)
+}
+
+func loadWithConfig(config ejrnl.Config) (storage.Journal, error) {
 	password, err := getPassword("Password: ")
 	if err != nil {
 		return &storage.Driver{}, err
 	}
-	return storage.NewDriver(config, password)
+	defer password.Close()
+	return openJournal(config, password)
+}
+
+// openJournal opens the journal with the password and then purges the entries that have been in the
+// trash for too long. The caller retains ownership of the password.
+func openJournal(config ejrnl.Config, password *crypto.Secret) (storage.Journal, error) {
+	if storage.IsDuress(config, password) {
+		// The journal can't be opened afterwards so, opening it fails the same way an incorrect
+		// password would.
+		storage.DestroyKeys(config)
+	}
+	if err := finishSwap(config); err != nil {
+		return &storage.Driver{}, err
+	}
+	driver, err := storage.Open(config, password)
+	if err != nil {
+		return driver, err
+	}
+	ctx, stop := interruptContext()
+	defer stop()
+	if _, err := workflows.PurgeTrash(ctx, driver, config.TrashDays); ctx.Err() != nil {
+		driver.Close()
+		return driver, ctx.Err()
+	} else if err != nil {
+		log.Printf("Failed to purge the trash because %s", err)
+	}
+	return driver, nil
+}
+
+// setDuress configures a password that destroys the journal's keys when it is entered.
+func setDuress(configPath string, config ejrnl.Config) error {
+	driver, err := loadWithConfig(config)
+	if err != nil {
+		return err
+	}
+	wrapped := driver.HasKeySlots()
+	driver.Close()
+	if !wrapped {
+		return storage.ErrDerivedKey
+	}
+
+	duress, err := getConfirmedPassword("Duress password: ", "Confirm:         ")
+	if err != nil {
+		return err
+	}
+	defer duress.Close()
+	if check, err := storage.Open(config, duress); err == nil {
+		check.Close()
+		return errors.New("The duress password can't be the same as the journal's password")
+	}
+	config.Duress, err = storage.DuressHash(config, duress)
+	if err != nil {
+		return err
+	}
+	return writeConfig(configPath, config)
 }
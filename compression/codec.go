@@ -0,0 +1,157 @@
+package compression
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Codec compresses and decompresses data. Codecs must be safe for concurrent use.
+type Codec interface {
+	Compress(data []byte) ([]byte, error)
+	Decompress(data []byte) ([]byte, error)
+}
+
+// The ids of the built in codecs. The id is stored with the data so, they must never change.
+const (
+	None   byte = 0
+	Gzip   byte = 1
+	Zstd   byte = 2
+	Brotli byte = 3
+)
+
+// DefaultCodec is the codec that is used when one isn't configured.
+const DefaultCodec = "gzip"
+
+var registryLock = &sync.RWMutex{}
+var codecs = make(map[byte]Codec)
+var codecNames = make(map[string]byte)
+
+func init() {
+	Register(None, "none", noneCodec{})
+	Register(Gzip, "gzip", gzipCodec{})
+	Register(Zstd, "zstd", zstdCodec{})
+	Register(Brotli, "brotli", brotliCodec{})
+}
+
+// Register adds a codec to the registry. It panics if the id or name is already in use. Ids must
+// be less than 128.
+func Register(id byte, name string, codec Codec) {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+	if _, exists := codecs[id]; exists || id == ZstdDictionary || id&padded != 0 {
+		panic(fmt.Sprintf("compression: codec id %d is already registered", id))
+	}
+	if _, exists := codecNames[name]; exists {
+		panic(fmt.Sprintf("compression: codec %s is already registered", name))
+	}
+	codecs[id] = codec
+	codecNames[name] = id
+}
+
+// Lookup returns the id of the named codec. An empty name is the default codec.
+func Lookup(name string) (byte, error) {
+	if name == "" {
+		name = DefaultCodec
+	}
+	registryLock.RLock()
+	defer registryLock.RUnlock()
+	id, ok := codecNames[name]
+	if !ok {
+		return 0, fmt.Errorf("Unknown compression codec %s", name)
+	}
+	return id, nil
+}
+
+// Codecs returns the names of all of the registered codecs.
+func Codecs() []string {
+	registryLock.RLock()
+	defer registryLock.RUnlock()
+	names := []string{}
+	for name := range codecNames {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func codecFor(id byte) (Codec, error) {
+	registryLock.RLock()
+	defer registryLock.RUnlock()
+	codec, ok := codecs[id]
+	if !ok {
+		return nil, fmt.Errorf("Unknown compression codec id %d", id)
+	}
+	return codec, nil
+}
+
+type noneCodec struct{}
+
+func (noneCodec) Compress(data []byte) ([]byte, error) {
+	return append([]byte{}, data...), nil
+}
+
+func (noneCodec) Decompress(data []byte) ([]byte, error) {
+	return data, nil
+}
+
+type gzipCodec struct{}
+
+func (gzipCodec) Compress(data []byte) ([]byte, error) {
+	buffer := new(bytes.Buffer)
+	gWriter := gzip.NewWriter(buffer)
+	if _, err := gWriter.Write(data); err != nil {
+		return []byte{}, err
+	}
+	if err := gWriter.Close(); err != nil {
+		return []byte{}, err
+	}
+	return buffer.Bytes(), nil
+}
+
+func (gzipCodec) Decompress(data []byte) ([]byte, error) {
+	gReader, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return []byte{}, err
+	}
+	return ioutil.ReadAll(gReader)
+}
+
+// zstd's encoders and decoders are expensive to create but, they are safe for concurrent use with
+// EncodeAll and DecodeAll so, they are shared.
+var zstdEncoder, _ = zstd.NewWriter(nil)
+var zstdDecoder, _ = zstd.NewReader(nil)
+
+type zstdCodec struct{}
+
+func (zstdCodec) Compress(data []byte) ([]byte, error) {
+	return zstdEncoder.EncodeAll(data, nil), nil
+}
+
+func (zstdCodec) Decompress(data []byte) ([]byte, error) {
+	return zstdDecoder.DecodeAll(data, nil)
+}
+
+type brotliCodec struct{}
+
+func (brotliCodec) Compress(data []byte) ([]byte, error) {
+	buffer := new(bytes.Buffer)
+	writer := brotli.NewWriter(buffer)
+	if _, err := writer.Write(data); err != nil {
+		return []byte{}, err
+	}
+	if err := writer.Close(); err != nil {
+		return []byte{}, err
+	}
+	return buffer.Bytes(), nil
+}
+
+func (brotliCodec) Decompress(data []byte) ([]byte, error) {
+	return ioutil.ReadAll(brotli.NewReader(bytes.NewReader(data)))
+}
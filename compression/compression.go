@@ -3,7 +3,9 @@ package compression
 import (
 	"bytes"
 	"compress/gzip"
+	"encoding/binary"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -12,23 +14,109 @@ import (
 	"github.com/btobolaski/ejrnl/crypto"
 )
 
+// Data written by this version starts with the magic bytes followed by the id of the codec that
+// compressed it. Older versions always used gzip or, before that, didn't compress at all. Neither
+// can start with the magic bytes.
+var magic = []byte("ejz")
+
+// Options configures how data is compressed before it is encrypted.
+type Options struct {
+	// Codec is the name of the codec to compress with. If it is empty, DefaultCodec is used.
+	Codec string
+	// Dictionaries are used by the zstd codec, when there are any, and to decompress data that was
+	// compressed with a dictionary.
+	Dictionaries *Dictionaries
+	// Padding is applied to the compressed data before it is encrypted
+	Padding Padding
+}
+
+// CompressAndEncrypt compresses the data with the default codec and then encrypts it.
 func CompressAndEncrypt(data, key []byte) ([]byte, error) {
-	buffer := new(bytes.Buffer)
-	gWriter := gzip.NewWriter(buffer)
-	if _, err := gWriter.Write(data); err != nil {
+	return Options{}.CompressAndEncrypt(data, key)
+}
+
+// DecryptAndDecompress decrypts and then decompresses data written by any version of ejrnl.
+func DecryptAndDecompress(cyphertext, key []byte) ([]byte, error) {
+	return Options{}.DecryptAndDecompress(cyphertext, key)
+}
+
+// CompressAndEncrypt compresses the data with the configured codec and then encrypts it.
+func (o Options) CompressAndEncrypt(data, key []byte) ([]byte, error) {
+	id, err := Lookup(o.Codec)
+	if err != nil {
 		return []byte{}, err
 	}
-	if err := gWriter.Close(); err != nil {
-		return []byte{}, err
+	var compressed []byte
+	if id == Zstd && o.Dictionaries.Latest() != 0 {
+		id = ZstdDictionary
+		compressed = o.Dictionaries.compress(data)
+	} else {
+		codec, err := codecFor(id)
+		if err != nil {
+			return []byte{}, err
+		}
+		compressed, err = codec.Compress(data)
+		if err != nil {
+			return []byte{}, err
+		}
+	}
+
+	if !o.Padding.Enabled() {
+		plaintext := make([]byte, 0, len(magic)+1+len(compressed))
+		plaintext = append(plaintext, magic...)
+		plaintext = append(plaintext, id)
+		plaintext = append(plaintext, compressed...)
+		return crypto.Encrypt(plaintext, key)
 	}
-	return crypto.Encrypt(buffer.Bytes(), key)
+
+	headerLength := len(magic) + 5
+	plaintext := make([]byte, headerLength, o.Padding.size(headerLength+len(compressed)))
+	copy(plaintext, magic)
+	plaintext[len(magic)] = id | padded
+	binary.BigEndian.PutUint32(plaintext[len(magic)+1:], uint32(len(compressed)))
+	plaintext = append(plaintext, compressed...)
+	plaintext = plaintext[:cap(plaintext)]
+	return crypto.Encrypt(plaintext, key)
 }
 
-func DecryptAndDecompress(cyphertext, key []byte) ([]byte, error) {
+// DecryptAndDecompress decrypts the data and then decompresses it with whichever codec it was
+// compressed with.
+func (o Options) DecryptAndDecompress(cyphertext, key []byte) ([]byte, error) {
 	raw, err := crypto.Decrypt(cyphertext, key)
 	if err != nil {
 		return []byte{}, err
 	}
+	if !bytes.HasPrefix(raw, magic) || len(raw) == len(magic) {
+		return decodeLegacy(raw)
+	}
+
+	id := raw[len(magic)]
+	compressed := raw[len(magic)+1:]
+	if id&padded != 0 {
+		id &^= padded
+		if len(compressed) < 4 {
+			return []byte{}, errors.New("The padded data is truncated")
+		}
+		length := binary.BigEndian.Uint32(compressed)
+		compressed = compressed[4:]
+		if uint64(length) > uint64(len(compressed)) {
+			return []byte{}, errors.New("The padded data is truncated")
+		}
+		compressed = compressed[:length]
+	}
+
+	if id == ZstdDictionary {
+		return o.Dictionaries.decompress(compressed)
+	}
+	codec, err := codecFor(id)
+	if err != nil {
+		return []byte{}, err
+	}
+	return codec.Decompress(compressed)
+}
+
+// decodeLegacy decodes data that was written before the codec was stored with the data.
+func decodeLegacy(raw []byte) ([]byte, error) {
 	buffer := bytes.NewBuffer(raw)
 	gReader, err := gzip.NewReader(buffer)
 	if err == nil {
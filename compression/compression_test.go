@@ -1,6 +1,8 @@
 package compression
 
 import (
+	"bytes"
+	"fmt"
 	"io/ioutil"
 	"testing"
 
@@ -79,19 +81,32 @@ func TestV2Decode(t *testing.T) {
 		return
 	}
 
-	cyphertext, err := CompressAndEncrypt(testData, key)
+	data, err := ioutil.ReadFile("./format-2.cpt")
 	if err != nil {
-		t.Errorf("Failed to compress text because %s", err)
+		t.Errorf("Failed to read file from disk because %s", err)
 		return
 	}
 
-	err = ioutil.WriteFile("format-2.cpt", cyphertext, 0644)
+	decrypted, err := DecryptAndDecompress(data, key)
 	if err != nil {
-		t.Errorf("Failed to write database %s", err)
+		t.Errorf("Failed to decrypt data because %s", err)
 		return
 	}
 
-	data, err := ioutil.ReadFile("./format-2.cpt")
+	if !dataEqual(decrypted, testData) {
+		t.Errorf("Decrypted data didn't match expected.\nExpected: '%s'\nGot:      '%s'", testData, decrypted)
+	}
+}
+
+func TestV3Decode(t *testing.T) {
+	t.Parallel()
+	key, err := crypto.GenerateKey([]byte("password"), []byte("salt"), 17)
+	if err != nil {
+		t.Errorf("Failed to create key because %s", err)
+		return
+	}
+
+	data, err := ioutil.ReadFile("./format-3.cpt")
 	if err != nil {
 		t.Errorf("Failed to read file from disk because %s", err)
 		return
@@ -107,3 +122,178 @@ func TestV2Decode(t *testing.T) {
 		t.Errorf("Decrypted data didn't match expected.\nExpected: '%s'\nGot:      '%s'", testData, decrypted)
 	}
 }
+
+func TestCodecRoundtrip(t *testing.T) {
+	t.Parallel()
+	key, err := crypto.GenerateKey([]byte("password"), []byte("salt"), 12)
+	if err != nil {
+		t.Errorf("Failed to generate a key because %s", err)
+		return
+	}
+	// The data isn't json so, it can only be decoded using the codec in the header
+	expected := []byte("\x00\x01not an entry, not an entry, not an entry")
+
+	for _, name := range Codecs() {
+		options := Options{Codec: name}
+		compressed, err := options.CompressAndEncrypt(append([]byte{}, expected...), key)
+		if err != nil {
+			t.Errorf("Failed to compress with %s because %s", name, err)
+			continue
+		}
+		roundtripped, err := DecryptAndDecompress(compressed, key)
+		if err != nil {
+			t.Errorf("Failed to decompress %s because %s", name, err)
+			continue
+		}
+		if !dataEqual(roundtripped, expected) {
+			t.Errorf("%s data didn't match expected.\ngot:      %#v\nexpected: %#v", name, roundtripped, expected)
+		}
+	}
+}
+
+func TestUnknownCodec(t *testing.T) {
+	t.Parallel()
+	if _, err := Lookup("lzma"); err == nil {
+		t.Error("Looking up an unknown codec didn't fail")
+	}
+	if id, err := Lookup(""); err != nil || id != Gzip {
+		t.Errorf("The default codec wasn't gzip, got %d %v", id, err)
+	}
+}
+
+func entrySamples(count int, offset int) [][]byte {
+	samples := [][]byte{}
+	for i := 0; i < count; i++ {
+		samples = append(samples, []byte(fmt.Sprintf(`{"Date":"2016-12-%02dT0%d:32:58Z","Body":"Today I went for a walk, then I wrote %d pages and had dinner with friends.","Id":"%d","Tags":["daily","walk"]}`, i%28+1, i%10, i+offset, i+offset)))
+	}
+	return samples
+}
+
+func TestDictionaryRoundtrip(t *testing.T) {
+	t.Parallel()
+	key, err := crypto.GenerateKey([]byte("password"), []byte("salt"), 12)
+	if err != nil {
+		t.Errorf("Failed to generate a key because %s", err)
+		return
+	}
+
+	dictionaries := NewDictionaries()
+	first, err := TrainDictionary(entrySamples(100, 0), FirstDictionaryId)
+	if err != nil {
+		t.Errorf("Failed to train dictionary because %s", err)
+		return
+	}
+	if _, err = dictionaries.Add(first); err != nil {
+		t.Errorf("Failed to add dictionary because %s", err)
+		return
+	}
+	options := Options{Codec: "zstd", Dictionaries: dictionaries}
+
+	expected := entrySamples(1, 1000)[0]
+	withFirst, err := options.CompressAndEncrypt(append([]byte{}, expected...), key)
+	if err != nil {
+		t.Errorf("Failed to compress because %s", err)
+		return
+	}
+	withoutDictionary, err := Options{Codec: "zstd"}.CompressAndEncrypt(append([]byte{}, expected...), key)
+	if err != nil {
+		t.Errorf("Failed to compress because %s", err)
+		return
+	}
+	if len(withFirst) >= len(withoutDictionary) {
+		t.Errorf("The dictionary didn't help, %d bytes with and %d without", len(withFirst), len(withoutDictionary))
+	}
+
+	second, err := TrainDictionary(entrySamples(100, 500), FirstDictionaryId+1)
+	if err != nil {
+		t.Errorf("Failed to train dictionary because %s", err)
+		return
+	}
+	if _, err = dictionaries.Add(second); err != nil {
+		t.Errorf("Failed to add dictionary because %s", err)
+		return
+	}
+	if dictionaries.Latest() != FirstDictionaryId+1 {
+		t.Errorf("The latest dictionary was %d", dictionaries.Latest())
+	}
+	withSecond, err := options.CompressAndEncrypt(append([]byte{}, expected...), key)
+	if err != nil {
+		t.Errorf("Failed to compress because %s", err)
+		return
+	}
+
+	for _, cyphertext := range [][]byte{withFirst, withSecond, withoutDictionary} {
+		roundtripped, err := options.DecryptAndDecompress(cyphertext, key)
+		if err != nil {
+			t.Errorf("Failed to decompress because %s", err)
+			continue
+		}
+		if !dataEqual(roundtripped, expected) {
+			t.Errorf("Data didn't match expected.\ngot:      %s\nexpected: %s", roundtripped, expected)
+		}
+	}
+
+	if _, err = DecryptAndDecompress(withFirst, key); err == nil {
+		t.Error("Decompressing without the dictionaries didn't fail")
+	}
+}
+
+func TestParsePadding(t *testing.T) {
+	t.Parallel()
+	expected := map[string]Padding{
+		"":     Padding{},
+		"none": Padding{},
+		"pow2": Padding{PowerOfTwo: true},
+		"4096": Padding{Bucket: 4096},
+	}
+	for policy, padding := range expected {
+		parsed, err := ParsePadding(policy)
+		if err != nil {
+			t.Errorf("Failed to parse %q because %s", policy, err)
+			continue
+		}
+		if parsed != padding {
+			t.Errorf("%q parsed as %#v, expected %#v", policy, parsed, padding)
+		}
+	}
+	for _, policy := range []string{"-1", "0", "bucket"} {
+		if _, err := ParsePadding(policy); err == nil {
+			t.Errorf("%q was parsed as a valid padding", policy)
+		}
+	}
+}
+
+func TestPadding(t *testing.T) {
+	t.Parallel()
+	key, err := crypto.GenerateKey([]byte("password"), []byte("salt"), 12)
+	if err != nil {
+		t.Errorf("Failed to generate a key because %s", err)
+		return
+	}
+
+	for _, padding := range []Padding{{PowerOfTwo: true}, {Bucket: 1000}} {
+		options := Options{Codec: "none", Padding: padding}
+		sizes := map[int]bool{}
+		for _, length := range []int{1, 10, 100} {
+			expected := bytes.Repeat([]byte("a"), length)
+			cyphertext, err := options.CompressAndEncrypt(append([]byte{}, expected...), key)
+			if err != nil {
+				t.Errorf("Failed to compress because %s", err)
+				continue
+			}
+			sizes[len(cyphertext)] = true
+
+			roundtripped, err := DecryptAndDecompress(cyphertext, key)
+			if err != nil {
+				t.Errorf("Failed to decompress because %s", err)
+				continue
+			}
+			if !dataEqual(roundtripped, expected) {
+				t.Errorf("Data didn't match expected.\ngot:      %#v\nexpected: %#v", roundtripped, expected)
+			}
+		}
+		if len(sizes) != 1 {
+			t.Errorf("Padding %#v produced different sizes %v", padding, sizes)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package compression
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/klauspost/compress/dict"
+	"github.com/klauspost/compress/zstd"
+)
+
+// ZstdDictionary is the codec id of data compressed by zstd with a trained dictionary. The zstd
+// frame records which dictionary was used. It isn't in the registry because it needs the journal's
+// dictionaries, which are provided through Options.
+const ZstdDictionary byte = 4
+
+// FirstDictionaryId is the id of the first dictionary a journal trains. zstd reserves lower ids.
+const FirstDictionaryId uint32 = 32768
+
+// ErrUnknownDictionary is returned when data was compressed with a dictionary that isn't loaded,
+// such as one that another process trained after the dictionaries were loaded.
+var ErrUnknownDictionary = errors.New("The data was compressed with a dictionary that isn't loaded")
+
+// minimumSamples is the fewest entries that a dictionary will be trained from.
+const minimumSamples = 8
+
+// Dictionaries holds a journal's trained zstd dictionaries. The newest dictionary compresses new
+// data and all of them are available for decompressing. It is safe for concurrent use.
+type Dictionaries struct {
+	lock    *sync.RWMutex
+	raw     map[uint32][]byte
+	latest  uint32
+	encoder *zstd.Encoder
+	decoder *zstd.Decoder
+}
+
+// NewDictionaries creates an empty set of dictionaries.
+func NewDictionaries() *Dictionaries {
+	return &Dictionaries{lock: &sync.RWMutex{}, raw: make(map[uint32][]byte)}
+}
+
+// TrainDictionary trains a zstd dictionary with the specified id from the samples.
+func TrainDictionary(samples [][]byte, id uint32) ([]byte, error) {
+	if len(samples) < minimumSamples {
+		return []byte{}, fmt.Errorf("At least %d entries are needed to train a dictionary", minimumSamples)
+	}
+	return dict.BuildZstdDict(samples, dict.Options{
+		MaxDictSize: 64 << 10,
+		HashBytes:   6,
+		ZstdDictID:  id,
+	})
+}
+
+// Add adds a dictionary. If it is the newest, it is used to compress new data.
+func (d *Dictionaries) Add(raw []byte) (uint32, error) {
+	info, err := zstd.InspectDictionary(raw)
+	if err != nil {
+		return 0, fmt.Errorf("Failed to load dictionary because %s", err)
+	}
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	id := info.ID()
+	d.raw[id] = raw
+	if id > d.latest {
+		d.latest = id
+	}
+
+	all := [][]byte{}
+	for _, dictionary := range d.raw {
+		all = append(all, dictionary)
+	}
+	decoder, err := zstd.NewReader(nil, zstd.WithDecoderDicts(all...))
+	if err != nil {
+		return 0, err
+	}
+	encoder, err := zstd.NewWriter(nil, zstd.WithEncoderDict(d.raw[d.latest]))
+	if err != nil {
+		decoder.Close()
+		return 0, err
+	}
+	if d.decoder != nil {
+		d.decoder.Close()
+	}
+	d.encoder = encoder
+	d.decoder = decoder
+	return id, nil
+}
+
+// All returns every dictionary, oldest first.
+func (d *Dictionaries) All() [][]byte {
+	if d == nil {
+		return [][]byte{}
+	}
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+	ids := []uint32{}
+	for id := range d.raw {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	all := [][]byte{}
+	for _, id := range ids {
+		all = append(all, d.raw[id])
+	}
+	return all
+}
+
+// Latest returns the id of the newest dictionary or 0 if there aren't any.
+func (d *Dictionaries) Latest() uint32 {
+	if d == nil {
+		return 0
+	}
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+	return d.latest
+}
+
+func (d *Dictionaries) compress(data []byte) []byte {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+	return d.encoder.EncodeAll(data, nil)
+}
+
+func (d *Dictionaries) decompress(data []byte) ([]byte, error) {
+	if d == nil {
+		return []byte{}, ErrUnknownDictionary
+	}
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+	if d.decoder == nil {
+		return []byte{}, ErrUnknownDictionary
+	}
+	plaintext, err := d.decoder.DecodeAll(data, nil)
+	if errors.Is(err, zstd.ErrUnknownDictionary) {
+		return []byte{}, ErrUnknownDictionary
+	}
+	return plaintext, err
+}
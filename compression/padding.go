@@ -0,0 +1,57 @@
+package compression
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// padded is set on the codec byte when the data is padded. The codec byte is then followed by the
+// length of the compressed data as a big endian uint32 and the padding follows the compressed data.
+const padded byte = 0x80
+
+// minimumPadding is the smallest size that power of two padding pads to.
+const minimumPadding = 256
+
+// Padding hides the length of the data by padding it before it is encrypted.
+type Padding struct {
+	// PowerOfTwo pads to the next power of two
+	PowerOfTwo bool
+	// Bucket pads to the next multiple of the bucket size
+	Bucket int
+}
+
+// ParsePadding parses a padding policy. It can be empty or "none" for no padding, "pow2" to pad to
+// the next power of two or a number of bytes to pad to a multiple of.
+func ParsePadding(policy string) (Padding, error) {
+	switch policy {
+	case "", "none":
+		return Padding{}, nil
+	case "pow2":
+		return Padding{PowerOfTwo: true}, nil
+	}
+	bucket, err := strconv.Atoi(policy)
+	if err != nil || bucket <= 0 {
+		return Padding{}, fmt.Errorf("Invalid padding %s, it must be none, pow2 or a number of bytes", policy)
+	}
+	return Padding{Bucket: bucket}, nil
+}
+
+// Enabled returns whether the data is padded at all.
+func (p Padding) Enabled() bool {
+	return p.PowerOfTwo || p.Bucket > 0
+}
+
+// size returns the size that data of the specified length is padded to.
+func (p Padding) size(length int) int {
+	if p.PowerOfTwo {
+		size := minimumPadding
+		for size < length {
+			size <<= 1
+		}
+		return size
+	}
+	if p.Bucket > 0 {
+		return (length + p.Bucket - 1) / p.Bucket * p.Bucket
+	}
+	return length
+}
@@ -0,0 +1,69 @@
+package ejrnl
+
+import (
+	"context"
+	"time"
+)
+
+// The context functions use the driver's context aware methods if it has them. Otherwise the
+// context is only checked before the operation starts.
+
+func WriteContext(ctx context.Context, driver Driver, entry Entry) error {
+	if d, ok := driver.(ContextDriver); ok {
+		return d.WriteContext(ctx, entry)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return driver.Write(entry)
+}
+
+func ReadContext(ctx context.Context, driver Driver, id string) (Entry, error) {
+	if d, ok := driver.(ContextDriver); ok {
+		return d.ReadContext(ctx, id)
+	}
+	if err := ctx.Err(); err != nil {
+		return Entry{}, err
+	}
+	return driver.Read(id)
+}
+
+func ListContext(ctx context.Context, driver Driver) (map[time.Time]string, error) {
+	if d, ok := driver.(ContextDriver); ok {
+		return d.ListContext(ctx)
+	}
+	if err := ctx.Err(); err != nil {
+		return map[time.Time]string{}, err
+	}
+	return driver.List()
+}
+
+func InitContext(ctx context.Context, driver Driver) error {
+	if d, ok := driver.(ContextDriver); ok {
+		return d.InitContext(ctx)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return driver.Init()
+}
+
+// WriteBatchContext writes the entries as a batch if the driver supports it and otherwise one at a
+// time. If it's canceled part way through, some of the entries may have been written.
+func WriteBatchContext(ctx context.Context, driver Driver, entries []Entry) error {
+	if d, ok := driver.(ContextBatchWriter); ok {
+		return d.WriteBatchContext(ctx, entries)
+	}
+	if d, ok := driver.(BatchWriter); ok {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		return d.WriteBatch(entries)
+	}
+	for _, entry := range entries {
+		if err := WriteContext(ctx, driver, entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
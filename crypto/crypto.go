@@ -17,6 +17,16 @@ func GenerateKey(password, salt []byte, pow uint) ([]byte, error) {
 	return scrypt.Key(password, salt, workFactor, 8, 1, 16)
 }
 
+// DeriveKey is GenerateKey for passwords held in a Secret. The derived key is also returned as a
+// Secret.
+func DeriveKey(password *Secret, salt []byte, pow uint) (*Secret, error) {
+	key, err := GenerateKey(password.Bytes(), salt, pow)
+	if err != nil {
+		return nil, err
+	}
+	return SecretFromBytes(key), nil
+}
+
 // encrypt encrypts the data using aes. Note that the key must be 16 or 32 bytes.
 // the output is in this format {{nonce}}{{null}}{{null}}{{ciphertext}}
 func Encrypt(data, key []byte) ([]byte, error) {
@@ -71,3 +71,81 @@ func TestCryptoRead(t *testing.T) {
 		t.Errorf("Decrypted data didn't match expected.\nExpected: '%s'\nGot:      '%s'", plaintext, decrypted)
 	}
 }
+
+func TestSecretSharedPage(t *testing.T) {
+	page := func(s *Secret) uintptr {
+		var first uintptr
+		eachPage(s.Bytes(), func(page uintptr, part []byte) { first = page })
+		return first
+	}
+	first := NewSecret(32)
+	defer first.Close()
+	var second *Secret
+	for i := 0; i < 100 && second == nil; i++ {
+		candidate := NewSecret(32)
+		if page(candidate) == page(first) {
+			second = candidate
+		} else {
+			defer candidate.Close()
+		}
+	}
+	if second == nil || !first.locked || !second.locked {
+		t.Skip("Couldn't lock two secrets on the same page")
+	}
+
+	shared := page(first)
+	second.Close()
+	lockedPages.Lock()
+	count := lockedPages.counts[shared]
+	lockedPages.Unlock()
+	if count == 0 {
+		t.Error("Closing a secret unlocked a page that another open secret is on")
+	}
+}
+
+func TestSecretClose(t *testing.T) {
+	t.Parallel()
+	raw := []byte("password")
+	secret := SecretFromBytes(raw)
+	if !dataEqual(raw, make([]byte, len(raw))) {
+		t.Errorf("Source bytes weren't wiped %#v", raw)
+	}
+	if string(secret.Bytes()) != "password" {
+		t.Errorf("Secret didn't match expected, got %#v", secret.Bytes())
+	}
+
+	data := secret.Bytes()
+	if err := secret.Close(); err != nil {
+		t.Errorf("Failed to close secret because %s", err)
+	}
+	if !dataEqual(data, make([]byte, len(data))) {
+		t.Errorf("Secret wasn't zeroed on close %#v", data)
+	}
+	if secret.Bytes() != nil {
+		t.Error("Closed secret still returned data")
+	}
+	if err := secret.Close(); err != nil {
+		t.Errorf("Closing a secret twice failed because %s", err)
+	}
+}
+
+func TestDeriveKey(t *testing.T) {
+	t.Parallel()
+	expected, err := GenerateKey([]byte("password"), []byte("salt"), 12)
+	if err != nil {
+		t.Errorf("Failed to generate key because %s", err)
+		return
+	}
+	password := SecretFromBytes([]byte("password"))
+	defer password.Close()
+	key, err := DeriveKey(password, []byte("salt"), 12)
+	if err != nil {
+		t.Errorf("Failed to derive key because %s", err)
+		return
+	}
+	defer key.Close()
+
+	if !dataEqual(expected, key.Bytes()) {
+		t.Errorf("Derived key didn't match expected\nexpected: %#v\ngot:      %#v", expected, key.Bytes())
+	}
+}
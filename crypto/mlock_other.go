@@ -0,0 +1,13 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd
+// +build !linux,!darwin,!freebsd,!netbsd,!openbsd
+
+package crypto
+
+// mlock isn't supported on this platform so, secrets are only zeroed when they are closed.
+func mlock(b []byte) error {
+	return nil
+}
+
+func munlock(b []byte) error {
+	return nil
+}
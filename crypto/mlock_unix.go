@@ -0,0 +1,22 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd
+// +build linux darwin freebsd netbsd openbsd
+
+package crypto
+
+import (
+	"syscall"
+)
+
+func mlock(b []byte) error {
+	if len(b) == 0 {
+		return nil
+	}
+	return syscall.Mlock(b)
+}
+
+func munlock(b []byte) error {
+	if len(b) == 0 {
+		return nil
+	}
+	return syscall.Munlock(b)
+}
@@ -0,0 +1,129 @@
+package crypto
+
+import (
+	"crypto/subtle"
+	"os"
+	"sync"
+	"unsafe"
+)
+
+// lockedPages counts the open secrets on each locked page. Small secrets share pages and munlock
+// unlocks whole pages so, a page is only unlocked once the last secret on it is closed.
+var lockedPages = struct {
+	sync.Mutex
+	counts map[uintptr]int
+}{counts: make(map[uintptr]int)}
+
+// Secret holds passwords and key material. Where the platform supports it, the memory is locked so
+// that it can't be swapped to disk. Closing the secret zeroes the memory.
+type Secret struct {
+	lock   sync.Mutex
+	data   []byte
+	locked bool
+}
+
+// NewSecret allocates a zeroed secret of the specified size.
+func NewSecret(size int) *Secret {
+	s := &Secret{data: make([]byte, size)}
+	s.locked = lockPages(s.data)
+	return s
+}
+
+// eachPage calls f with the address of each page that b is on and the part of b on that page.
+func eachPage(b []byte, f func(page uintptr, part []byte)) {
+	if len(b) == 0 {
+		return
+	}
+	size := uintptr(os.Getpagesize())
+	start := uintptr(unsafe.Pointer(&b[0]))
+	for offset := 0; offset < len(b); {
+		page := (start + uintptr(offset)) &^ (size - 1)
+		end := int(page + size - start)
+		if end > len(b) {
+			end = len(b)
+		}
+		f(page, b[offset:end])
+		offset = end
+	}
+}
+
+// lockPages locks b's memory and returns whether it succeeded.
+func lockPages(b []byte) bool {
+	lockedPages.Lock()
+	defer lockedPages.Unlock()
+	if mlock(b) != nil {
+		return false
+	}
+	eachPage(b, func(page uintptr, part []byte) {
+		lockedPages.counts[page]++
+	})
+	return true
+}
+
+// unlockPages unlocks the pages that b is on that no other open secret is on.
+func unlockPages(b []byte) error {
+	lockedPages.Lock()
+	defer lockedPages.Unlock()
+	var err error
+	eachPage(b, func(page uintptr, part []byte) {
+		lockedPages.counts[page]--
+		if lockedPages.counts[page] > 0 {
+			return
+		}
+		delete(lockedPages.counts, page)
+		if unlockErr := munlock(part); unlockErr != nil && err == nil {
+			err = unlockErr
+		}
+	})
+	return err
+}
+
+// SecretFromBytes copies b into a new secret and then zeroes b.
+func SecretFromBytes(b []byte) *Secret {
+	s := NewSecret(len(b))
+	copy(s.data, b)
+	Wipe(b)
+	return s
+}
+
+// Bytes returns the secret's underlying memory. The returned slice must not be retained after the
+// secret is closed. A closed secret returns nil.
+func (s *Secret) Bytes() []byte {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.data
+}
+
+// Len returns the length of the secret.
+func (s *Secret) Len() int {
+	return len(s.Bytes())
+}
+
+// Equal compares the two secrets in constant time.
+func (s *Secret) Equal(other *Secret) bool {
+	return subtle.ConstantTimeCompare(s.Bytes(), other.Bytes()) == 1
+}
+
+// Close zeroes and unlocks the secret. It is safe to call Close multiple times.
+func (s *Secret) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.data == nil {
+		return nil
+	}
+	Wipe(s.data)
+	var err error
+	if s.locked {
+		err = unlockPages(s.data)
+	}
+	s.data = nil
+	s.locked = false
+	return err
+}
+
+// Wipe zeroes the passed in slice.
+func Wipe(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
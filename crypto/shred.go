@@ -0,0 +1,74 @@
+package crypto
+
+import (
+	"crypto/rand"
+	"os"
+	"path/filepath"
+)
+
+// ShredLimits describes what Shred can't do. Commands that shred files show it.
+const ShredLimits = "Overwriting files only reaches the blocks that they currently use. Copies kept by copy-on-write " +
+	"filesystems, such as btrfs, ZFS and APFS, snapshots, SSD wear leveling, backups and git history aren't removed."
+
+// shredChunk is how much noise is written at a time.
+const shredChunk = 64 * 1024
+
+// Shred overwrites the file with random data, and syncs it, before removing it. See ShredLimits for
+// what it can't do.
+func Shred(path string) error {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return os.Remove(path)
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	noise := make([]byte, shredChunk)
+	for remaining := info.Size(); remaining > 0 && err == nil; remaining -= int64(len(noise)) {
+		if remaining < int64(len(noise)) {
+			noise = noise[:remaining]
+		}
+		if _, err = rand.Read(noise); err == nil {
+			_, err = file.Write(noise)
+		}
+	}
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+// ShredTree shreds every file in the directory and then removes it.
+func ShredTree(directory string) error {
+	files := []string{}
+	err := filepath.Walk(directory, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			files = append(files, path)
+		}
+		return nil
+	})
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if err = Shred(file); err != nil {
+			return err
+		}
+	}
+	return os.RemoveAll(directory)
+}
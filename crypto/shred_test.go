@@ -0,0 +1,59 @@
+package crypto
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestShred(t *testing.T) {
+	directory, err := ioutil.TempDir("", "shred-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(directory)
+
+	// Hard links show what was written to the file's blocks after it's been removed
+	plaintext := bytes.Repeat([]byte("secret"), 20000)
+	path := fmt.Sprintf("%s/entry", directory)
+	if err = ioutil.WriteFile(path, plaintext, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Link(path, path+".link"); err != nil {
+		t.Skipf("Hard links aren't supported, %s", err)
+	}
+	if err = Shred(path); err != nil {
+		t.Fatalf("Failed to shred the file because %s", err)
+	}
+	if _, err = os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("Expected the file to be removed, got %v", err)
+	}
+	overwritten, err := ioutil.ReadFile(path + ".link")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(overwritten) != len(plaintext) || bytes.Contains(overwritten, []byte("secret")) {
+		t.Error("Expected the file's contents to be overwritten")
+	}
+
+	tree := fmt.Sprintf("%s/tree", directory)
+	if err = os.MkdirAll(tree+"/packs", 0700); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"index.cpt", "packs/a.pack"} {
+		if err = ioutil.WriteFile(fmt.Sprintf("%s/%s", tree, name), plaintext, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = ShredTree(tree); err != nil {
+		t.Fatalf("Failed to shred the directory because %s", err)
+	}
+	if _, err = os.Stat(tree); !os.IsNotExist(err) {
+		t.Errorf("Expected the directory to be removed, got %v", err)
+	}
+	if err = ShredTree(tree); err != nil {
+		t.Errorf("Expected a missing directory to be ignored, got %v", err)
+	}
+}
@@ -1,12 +1,56 @@
 package ejrnl
 
 import (
+	"context"
 	"time"
 )
 
 type Config struct {
 	StorageDirectory, Salt string
 	Pow                    uint
+	// Backend is how the journal is stored, either files, the default, sqlite or s3
+	Backend string `yaml:",omitempty"`
+	// S3 configures the bucket that the s3 backend stores the journal in
+	S3 *S3Config `yaml:",omitempty"`
+	// Git commits every change to the journal's directory, which must use the files backend, to git
+	Git bool `yaml:",omitempty"`
+	// GitRemote is the repository, which can be a local path, that push and pull use
+	GitRemote string `yaml:",omitempty"`
+	// Compression is the name of the codec that new data is compressed with
+	Compression string `yaml:",omitempty"`
+	// Padding hides the length of entries, it is either none, pow2 or a bucket size in bytes
+	Padding string `yaml:",omitempty"`
+	// HideActivity normalizes file modification times and rewrites random entries on every write so
+	// that the files on disk don't reveal when entries are written
+	HideActivity bool `yaml:",omitempty"`
+	// CoverWrites is the number of random entries that are rewritten when activity is hidden
+	CoverWrites int `yaml:",omitempty"`
+	// SecureDelete overwrites the contents of files before they're removed or replaced. It can't
+	// reach copies kept by copy-on-write filesystems, snapshots or SSDs
+	SecureDelete bool `yaml:",omitempty"`
+	// Duress is the hash of a password that destroys the journal's keys when it is entered
+	Duress string `yaml:",omitempty"`
+	// Timezone is the zone that dates are shown in, either local, the default, or original
+	Timezone string `yaml:",omitempty"`
+	// TrashDays is how many days deleted entries are kept in the trash before they're purged. The
+	// default is 30 and a negative number keeps them until the trash is emptied
+	TrashDays int `yaml:",omitempty"`
+	// Notebooks are other journals, which are selected by name. Only the default notebook, which is
+	// configured by the rest of this config, has them
+	Notebooks []Notebook `yaml:",omitempty"`
+}
+
+// S3Config describes an S3 compatible bucket. The credentials fall back to the AWS_ACCESS_KEY_ID and
+// AWS_SECRET_ACCESS_KEY environment variables.
+type S3Config struct {
+	// Endpoint is the service's url, such as https://s3.us-east-1.amazonaws.com
+	Endpoint string
+	Region   string
+	Bucket   string
+	// Prefix is prepended to the name of every object that the journal stores
+	Prefix    string `yaml:",omitempty"`
+	AccessKey string `yaml:",omitempty"`
+	SecretKey string `yaml:",omitempty"`
 }
 
 type Entry struct {
@@ -14,6 +58,61 @@ type Entry struct {
 	Body string     `yaml:",omitempty"`
 	Id   string     `yaml:",omitempty"`
 	Tags []string   `yaml:",omitempty"`
+	// Zone is the name of the zone, such as Asia/Tokyo, that the entry was written in. Date keeps the
+	// offset it was written with even without it.
+	Zone string `yaml:",omitempty" json:",omitempty"`
+	// The rest of the metadata is optional. It's omitted when it's empty so that entries without it
+	// are stored the same way as they were before it existed.
+	Title    string            `yaml:",omitempty" json:",omitempty"`
+	Location *Location         `yaml:",omitempty" json:",omitempty"`
+	Mood     string            `yaml:",omitempty" json:",omitempty"`
+	Fields   map[string]string `yaml:",omitempty" json:",omitempty"`
+}
+
+// Location is where an entry was written. Any of it can be left out.
+type Location struct {
+	Latitude  float64 `yaml:",omitempty" json:",omitempty"`
+	Longitude float64 `yaml:",omitempty" json:",omitempty"`
+	Name      string  `yaml:",omitempty" json:",omitempty"`
+}
+
+// Summary is what's shown about an entry when the journal is listed.
+type Summary struct {
+	Id string
+	// Date is in the zone that the entry was written in
+	Date  time.Time
+	Zone  string
+	Title string
+	// Links are the targets of the links in the entry's body
+	Links []string
+}
+
+// Summary returns the entry's summary.
+func (e Entry) Summary() Summary {
+	summary := Summary{Id: e.Id, Zone: e.Zone, Title: e.Title, Links: Links(e.Body)}
+	if e.Date != nil {
+		summary.Date = *e.Date
+	}
+	return summary
+}
+
+// NormalizeDate returns a copy of the entry that has the current time if it doesn't have a date and
+// whose date doesn't have a monotonic clock reading, so that it's stored the same way it's read
+// back. Drivers call it before writing. The caller's date isn't changed.
+func NormalizeDate(entry Entry) Entry {
+	date := time.Now()
+	if entry.Date != nil {
+		date = *entry.Date
+	}
+	date = date.Round(0)
+	entry.Date = &date
+	return entry
+}
+
+// BatchWriter is implemented by drivers that can write several entries at once more efficiently
+// than writing them one at a time.
+type BatchWriter interface {
+	WriteBatch([]Entry) error
 }
 
 type Driver interface {
@@ -21,4 +120,57 @@ type Driver interface {
 	Read(string) (Entry, error)
 	List() (map[time.Time]string, error)
 	Init() error
+	Close() error
+}
+
+// ContextDriver is implemented by drivers whose operations can be canceled. A canceled operation
+// leaves the journal consistent, either as it was or with part of a batch written.
+type ContextDriver interface {
+	WriteContext(context.Context, Entry) error
+	ReadContext(context.Context, string) (Entry, error)
+	ListContext(context.Context) (map[time.Time]string, error)
+	InitContext(context.Context) error
+}
+
+// Summarizer is implemented by drivers that keep entries' titles and links in their index so that
+// the journal can be listed, and backlinks found, without reading every entry.
+type Summarizer interface {
+	Summaries(context.Context) ([]Summary, error)
+}
+
+// Trashed is an entry in the trash.
+type Trashed struct {
+	Summary
+	Deleted time.Time
+}
+
+// Trash is implemented by drivers that can delete entries. Deleted entries are moved to an
+// encrypted trash, where they're hidden from List, until they're restored or purged.
+type Trash interface {
+	Delete(ctx context.Context, id string) error
+	Restore(ctx context.Context, id string) error
+	Trashed(context.Context) ([]Trashed, error)
+	// Purge permanently removes the entries that were deleted before the time and returns how many
+	// it removed
+	Purge(ctx context.Context, deletedBefore time.Time) (int, error)
+}
+
+// Migrator is implemented by drivers that store more than the entries, such as packs and an index,
+// and can rewrite all of it with their current settings.
+type Migrator interface {
+	Migrate(context.Context) error
+}
+
+// DictionaryStore is implemented by drivers that compress entries with trained dictionaries.
+type DictionaryStore interface {
+	// Dictionaries returns every dictionary that has been trained, oldest first
+	Dictionaries() [][]byte
+	// AddDictionary keeps a dictionary that was trained by another journal. New writes are compressed
+	// with it if it's the newest
+	AddDictionary(raw []byte) error
+}
+
+// ContextBatchWriter is a BatchWriter whose batches can be canceled.
+type ContextBatchWriter interface {
+	WriteBatchContext(context.Context, []Entry) error
 }
@@ -0,0 +1,30 @@
+package ejrnl
+
+import (
+	"regexp"
+	"strings"
+)
+
+// linkPattern matches wiki style links, [[id]] or [[date]].
+var linkPattern = regexp.MustCompile(`\[\[([^\[\]\n]+)\]\]`)
+
+// Links returns the targets of the links in the body, without duplicates, in the order that they
+// first appear. They're resolved against the journal when they're followed because a link to a date
+// can be written before the entry it refers to.
+func Links(body string) []string {
+	targets := []string{}
+	seen := make(map[string]bool)
+	for _, match := range linkPattern.FindAllStringSubmatch(body, -1) {
+		target := strings.TrimSpace(match[1])
+		if target != "" && !seen[target] {
+			seen[target] = true
+			targets = append(targets, target)
+		}
+	}
+	return targets
+}
+
+// LinkPattern returns the pattern that links match. Its first group is the link's target.
+func LinkPattern() *regexp.Regexp {
+	return linkPattern
+}
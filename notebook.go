@@ -0,0 +1,85 @@
+package ejrnl
+
+import (
+	"errors"
+	"fmt"
+)
+
+// DefaultNotebook is the name of the journal that's configured at the top level of the config.
+const DefaultNotebook = "default"
+
+// Notebook is a separate journal, with its own directory, salt and settings, that's configured in
+// the same file as the default journal.
+type Notebook struct {
+	Name   string
+	Config `yaml:",inline"`
+}
+
+// NotebookNames returns the name of every notebook, starting with the default.
+func (c Config) NotebookNames() []string {
+	names := []string{DefaultNotebook}
+	for _, notebook := range c.Notebooks {
+		names = append(names, notebook.Name)
+	}
+	return names
+}
+
+// Notebook returns the config of the named notebook. An empty name is the default notebook, whose
+// config is c itself.
+func (c Config) Notebook(name string) (Config, error) {
+	if name == "" || name == DefaultNotebook {
+		return c, nil
+	}
+	for _, notebook := range c.Notebooks {
+		if notebook.Name == name {
+			return notebook.Config, nil
+		}
+	}
+	return Config{}, fmt.Errorf("The notebook %s doesn't exist", name)
+}
+
+// SetNotebook replaces the config of the named notebook. The default notebook keeps the other
+// notebooks.
+func (c *Config) SetNotebook(name string, config Config) error {
+	if name == "" || name == DefaultNotebook {
+		notebooks := c.Notebooks
+		*c = config
+		c.Notebooks = notebooks
+		return nil
+	}
+	for i, notebook := range c.Notebooks {
+		if notebook.Name == name {
+			config.Notebooks = nil
+			c.Notebooks[i].Config = config
+			return nil
+		}
+	}
+	return fmt.Errorf("The notebook %s doesn't exist", name)
+}
+
+// AddNotebook adds a new notebook.
+func (c *Config) AddNotebook(name string, config Config) error {
+	if name == "" {
+		return errors.New("Notebooks must have a name")
+	}
+	if _, err := c.Notebook(name); err == nil {
+		return fmt.Errorf("The notebook %s already exists", name)
+	}
+	config.Notebooks = nil
+	c.Notebooks = append(c.Notebooks, Notebook{Name: name, Config: config})
+	return nil
+}
+
+// RemoveNotebook removes a notebook from the config. Its journal is left where it is.
+func (c *Config) RemoveNotebook(name string) error {
+	for i, notebook := range c.Notebooks {
+		if notebook.Name == name {
+			c.Notebooks = append(c.Notebooks[:i], c.Notebooks[i+1:]...)
+			return nil
+		}
+	}
+	if name == "" || name == DefaultNotebook {
+		return errors.New("The default notebook can't be removed")
+	}
+	return fmt.Errorf("The notebook %s doesn't exist", name)
+}
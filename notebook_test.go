@@ -0,0 +1,61 @@
+package ejrnl
+
+import (
+	"reflect"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+func TestNotebooks(t *testing.T) {
+	config := Config{StorageDirectory: "~/journal", Salt: "salt", Pow: 19}
+	work := Config{StorageDirectory: "~/work", Salt: "other salt", Pow: 20, Backend: "sqlite"}
+	if err := config.AddNotebook("work", work); err != nil {
+		t.Fatalf("Failed to add the notebook because %s", err)
+	}
+	if err := config.AddNotebook("work", work); err == nil {
+		t.Error("Expected an error when a notebook is added twice")
+	}
+	if err := config.AddNotebook(DefaultNotebook, work); err == nil {
+		t.Error("Expected an error when a notebook is named after the default")
+	}
+
+	data, err := yaml.Marshal(config)
+	if err != nil {
+		t.Fatalf("Failed to marshal the config because %s", err)
+	}
+	read := Config{}
+	if err = yaml.Unmarshal(data, &read); err != nil {
+		t.Fatalf("Failed to unmarshal the config because %s", err)
+	}
+	if !reflect.DeepEqual(read.NotebookNames(), []string{DefaultNotebook, "work"}) {
+		t.Errorf("Incorrect notebooks %v", read.NotebookNames())
+	}
+	if notebook, err := read.Notebook("work"); err != nil || !reflect.DeepEqual(notebook, work) {
+		t.Errorf("Expected the notebook's own config, got %#v %v", notebook, err)
+	}
+	if notebook, err := read.Notebook(""); err != nil || notebook.StorageDirectory != "~/journal" {
+		t.Errorf("Expected the default notebook, got %#v %v", notebook, err)
+	}
+	if _, err = read.Notebook("missing"); err == nil {
+		t.Error("Expected an error for a notebook that doesn't exist")
+	}
+
+	work.Salt = "new salt"
+	if err = read.SetNotebook("work", work); err != nil {
+		t.Fatalf("Failed to set the notebook because %s", err)
+	}
+	if err = read.SetNotebook("", Config{StorageDirectory: "~/moved"}); err != nil {
+		t.Fatalf("Failed to set the default notebook because %s", err)
+	}
+	if notebook, _ := read.Notebook("work"); read.StorageDirectory != "~/moved" || notebook.Salt != "new salt" {
+		t.Errorf("Expected both notebooks to be updated, got %#v", read)
+	}
+
+	if err = read.RemoveNotebook(DefaultNotebook); err == nil {
+		t.Error("Expected an error when the default notebook is removed")
+	}
+	if err = read.RemoveNotebook("work"); err != nil || len(read.Notebooks) != 0 {
+		t.Errorf("Failed to remove the notebook, got %v %v", read.Notebooks, err)
+	}
+}
@@ -23,26 +23,53 @@ const footer = `{{define "footer"}}
 {{end}}`
 
 const indexTemplate = `{{template "header" .}}
-<p><a href="/entries/new">new</a></p>
+<p><a href="/entries/new">new</a>{{ if .Switch }} - {{.Notebook}} <a href="/notebooks">switch notebook</a>{{end}}</p>
 <ul>
 	{{ if .Entries }}{{range .Entries}}
-	<li><a href="/entries/{{.Id}}/">{{.Date}}</a></li>
+	<li><a href="/entries/{{.Id}}/">{{.Date}}</a>{{ if .Title }} {{.Title}}{{end}}</li>
 	{{end}}{{end}}
 </ul>
 {{template "footer"}}`
 
 const formTemplate = `{{template "header" .}}
+{{ if .Body }}<div style="white-space: pre-wrap;">{{.Body}}</div>{{end}}
 <form action="{{.Target}}" method="post">
 	<textarea name="text" style="width: 100%;">{{.Text}}</textarea>
 	<button type="submit">Save</button>
 </form>
+{{ if .Deletable }}
+<form action="delete" method="post">
+	<button type="submit">Move to trash</button>
+</form>
+{{end}}
+{{ if .Backlinks }}
+<h2>Referenced by</h2>
+<ul>
+	{{range .Backlinks}}
+	<li><a href="/entries/{{.Id}}/">{{.Date}}</a>{{ if .Title }} {{.Title}}{{end}}</li>
+	{{end}}
+</ul>
+{{end}}
 <script type="text/javascript">
 autosize(document.querySelector('textarea'));
 </script>
 {{template "footer"}}`
 
+const notebooksTemplate = `{{template "header" .}}
+{{ if .Message }}<p>{{.Message}}</p>{{end}}
+{{range .Notebooks}}
+<form action="/notebooks" method="post">
+	<input type="hidden" name="notebook" value="{{.}}">
+	{{.}}
+	<input type="password" name="password" placeholder="Password">
+	<button type="submit">Open</button>
+</form>
+{{end}}
+{{template "footer"}}`
+
 var indexPage *template.Template
 var formPage *template.Template
+var notebooksPage *template.Template
 
 func init() {
 	header, err := template.New("header").Parse(header)
@@ -63,4 +90,9 @@ func init() {
 	if err != nil {
 		panic(err)
 	}
+
+	notebooksPage, err = base.New("notebooks").Parse(notebooksTemplate)
+	if err != nil {
+		panic(err)
+	}
 }
@@ -1,9 +1,14 @@
 package server
 
 import (
+	"bytes"
 	"fmt"
+	"html/template"
 	"log"
 	"net/http"
+	"net/url"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/99designs/basicauth-go"
@@ -11,24 +16,73 @@ import (
 	"github.com/pressly/chi"
 
 	"github.com/btobolaski/ejrnl"
+	"github.com/btobolaski/ejrnl/crypto"
 	"github.com/btobolaski/ejrnl/workflows"
 )
 
 type Config struct {
 	Port               int
 	Username, Password string
+	// Zone is the zone that dates are shown in
+	Zone ejrnl.DisplayZone
+	// Notebook is the name of the notebook that the server starts with and Notebooks are the names of
+	// every notebook that it can switch to
+	Notebook  string
+	Notebooks []string
+	// Open unlocks a notebook when the server switches to it. Switching is disabled without it
+	Open func(notebook string, password *crypto.Secret) (ejrnl.Driver, error)
 }
 
 type Server struct {
 	port        int
-	driver      ejrnl.Driver
+	zone        ejrnl.DisplayZone
 	server      httpdown.Server
 	credentials map[string][]string
+	notebooks   []string
+	open        func(string, *crypto.Secret) (ejrnl.Driver, error)
+	// lock protects the notebook, which changes when the server switches notebooks
+	lock     sync.RWMutex
+	notebook *openNotebook
+}
+
+// openNotebook is the notebook that's being used. Its driver is only closed once the requests that
+// are using it have finished.
+type openNotebook struct {
+	name   string
+	driver ejrnl.Driver
+	users  sync.WaitGroup
+}
+
+// release tells the notebook that a request has finished using its driver.
+func (n *openNotebook) release() {
+	n.users.Done()
+}
+
+// close waits for the requests that are using the driver to finish and then closes it.
+func (n *openNotebook) close() error {
+	n.users.Wait()
+	return n.driver.Close()
 }
 
 func New(driver ejrnl.Driver, config Config) (*Server, error) {
 	creds := map[string][]string{config.Username: []string{config.Password}}
-	return &Server{port: config.Port, driver: driver, credentials: creds}, nil
+	return &Server{
+		port:        config.Port,
+		zone:        config.Zone,
+		credentials: creds,
+		notebook:    &openNotebook{name: config.Notebook, driver: driver},
+		notebooks:   config.Notebooks,
+		open:        config.Open,
+	}, nil
+}
+
+// current returns the notebook that's being used. The caller must release it once it's done with
+// the driver.
+func (s *Server) current() *openNotebook {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	s.notebook.users.Add(1)
+	return s.notebook
 }
 
 func (s *Server) Start() error {
@@ -40,10 +94,14 @@ func (s *Server) Start() error {
 		w.Write([]byte(autosize))
 	})
 
+	router.Get("/notebooks", s.notebooksForm)
+	router.Post("/notebooks", s.switchNotebook)
+
 	router.Route("/entries", func(r chi.Router) {
 		r.Get("/new", s.newForm)
 		r.Post("/new", s.create)
 		r.Get("/:entryId/", s.read)
+		r.Post("/:entryId/delete", s.delete)
 	})
 
 	options := httpdown.HTTP{
@@ -65,29 +123,68 @@ func (s *Server) Start() error {
 	return nil
 }
 
-type entryListing struct {
-	Id   string
-	Date time.Time
+// Stop shuts down the server and then drops the journal's key.
+func (s *Server) Stop() error {
+	var err error
+	if s.server != nil {
+		err = s.server.Stop()
+	}
+	s.lock.RLock()
+	notebook := s.notebook
+	s.lock.RUnlock()
+	if closeErr := notebook.close(); err == nil {
+		err = closeErr
+	}
+	return err
 }
 
+// index lists the entries. The tag, title, location, mood, field, since and until query parameters
+// filter them the same way as the list command's flags.
 func (s *Server) index(w http.ResponseWriter, r *http.Request) {
-	listing, index, err := workflows.Listing(s.driver)
+	query := r.URL.Query()
+	filter := workflows.Filter{
+		Tags:     query["tag"],
+		Title:    query.Get("title"),
+		Location: query.Get("location"),
+		Mood:     query.Get("mood"),
+		Zone:     s.zone,
+	}
+	if err := filter.SetDays(query.Get("since"), query.Get("until")); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	for _, field := range query["field"] {
+		if err := filter.AddField(field); err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+	}
+
+	var entries []ejrnl.Summary
+	var err error
+	notebook := s.current()
+	defer notebook.release()
+	driver := notebook.driver
+	if filter.Empty() {
+		entries, err = workflows.Summaries(r.Context(), driver)
+	} else {
+		err = workflows.Matching(r.Context(), driver, filter, 0, func(entry ejrnl.Entry) error {
+			entries = append(entries, entry.Summary())
+			return nil
+		})
+	}
 	if err != nil {
 		log.Printf("Failed to generate listing because %s", err)
 		http.Error(w, "A Server Error occured", 500)
 		return
 	}
-	entries := make([]entryListing, len(index))
-	for i, val := range index {
-		entries[i] = entryListing{
-			Id:   listing[val],
-			Date: val,
-		}
-	}
+	s.localize(entries)
 	templateData := struct {
-		Title   string
-		Entries []entryListing
-	}{"Entries", entries}
+		Title    string
+		Entries  []ejrnl.Summary
+		Notebook string
+		Switch   bool
+	}{"Entries", entries, notebook.name, s.open != nil && len(s.notebooks) > 1}
 
 	err = indexPage.Execute(w, templateData)
 	if err != nil {
@@ -98,7 +195,7 @@ func (s *Server) index(w http.ResponseWriter, r *http.Request) {
 
 func (s *Server) newForm(w http.ResponseWriter, r *http.Request) {
 	date := time.Now()
-	entry := ejrnl.Entry{Date: &date}
+	entry := ejrnl.Entry{Date: &date, Zone: ejrnl.CurrentZone()}
 	renderForm(workflows.Format(entry), "New Entry", w)
 }
 
@@ -111,7 +208,9 @@ func (s *Server) create(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = s.driver.Write(entry)
+	notebook := s.current()
+	defer notebook.release()
+	err = ejrnl.WriteContext(r.Context(), notebook.driver, entry)
 	if err != nil {
 		log.Printf("Failed to save because %s", err)
 		renderForm(r.Form["text"][0], "Re-edit", w)
@@ -123,22 +222,146 @@ func (s *Server) create(w http.ResponseWriter, r *http.Request) {
 
 func (s *Server) read(w http.ResponseWriter, r *http.Request) {
 	entryId := chi.URLParam(r, "entryId")
-	entry, err := s.driver.Read(entryId)
+	notebook := s.current()
+	defer notebook.release()
+	driver := notebook.driver
+	entry, err := ejrnl.ReadContext(r.Context(), driver, entryId)
 	if err != nil {
 		log.Printf("Error while trying to look up entry %s, %s", entryId, err)
 		http.Error(w, "Couldn't find entry with that id", 404)
 		return
 	}
-	renderForm(workflows.Format(entry), fmt.Sprintf("Edit %s", entryId), w)
+
+	summaries, err := workflows.Summaries(r.Context(), driver)
+	if err != nil {
+		log.Printf("Failed to resolve the links in %s because %s", entryId, err)
+		http.Error(w, "A Server Error occured", 500)
+		return
+	}
+	resolver := workflows.NewResolver(summaries, s.zone)
+	backlinks := resolver.Backlinks(entryId)
+	s.localize(backlinks)
+	_, deletable := driver.(ejrnl.Trash)
+	renderPage(w, formData{
+		Title:     "New Entry",
+		Target:    "/entries/new",
+		Text:      workflows.Format(entry),
+		Body:      renderBody(entry.Body, resolver),
+		Backlinks: backlinks,
+		Deletable: deletable,
+	})
+}
+
+// delete moves the entry to the trash.
+func (s *Server) delete(w http.ResponseWriter, r *http.Request) {
+	entryId := chi.URLParam(r, "entryId")
+	notebook := s.current()
+	defer notebook.release()
+	if err := workflows.Delete(r.Context(), notebook.driver, entryId); err != nil {
+		log.Printf("Failed to delete %s because %s", entryId, err)
+		http.Error(w, "Couldn't delete the entry", 500)
+		return
+	}
+	http.Redirect(w, r, "/", 303)
+}
+
+// localize converts the summaries' dates to the zone that they're shown in.
+func (s *Server) localize(summaries []ejrnl.Summary) {
+	for i, summary := range summaries {
+		summaries[i].Date = s.zone.In(summary.Date, summary.Zone)
+	}
+}
+
+// formData is what the form page shows. Body and Backlinks are only set for existing entries.
+type formData struct {
+	Title, Target, Text string
+	// Body is the entry's body with its links rendered as anchors
+	Body      template.HTML
+	Backlinks []ejrnl.Summary
+	// Deletable is whether the entry can be moved to the trash
+	Deletable bool
 }
 
 func renderForm(entry, title string, w http.ResponseWriter) {
-	data := struct {
-		Title, Target, Text string
-	}{"New Entry", "/entries/new", entry}
+	renderPage(w, formData{Title: "New Entry", Target: "/entries/new", Text: entry})
+}
+
+func renderPage(w http.ResponseWriter, data formData) {
 	err := formPage.Execute(w, data)
 	if err != nil {
 		log.Printf("Failed to generate form because %s", err)
 		http.Error(w, "A Server Error occured", 500)
 	}
 }
+
+// renderBody escapes the body and turns the links that resolve into anchors. Links that don't
+// resolve are left as they were written.
+func renderBody(body string, resolver *workflows.Resolver) template.HTML {
+	var rendered bytes.Buffer
+	last := 0
+	for _, match := range ejrnl.LinkPattern().FindAllStringSubmatchIndex(body, -1) {
+		rendered.WriteString(template.HTMLEscapeString(body[last:match[0]]))
+		target := strings.TrimSpace(body[match[2]:match[3]])
+		if id, ok := resolver.Resolve(target); ok {
+			fmt.Fprintf(&rendered, `<a href="/entries/%s/">%s</a>`, url.PathEscape(id), template.HTMLEscapeString(target))
+		} else {
+			rendered.WriteString(template.HTMLEscapeString(body[match[0]:match[1]]))
+		}
+		last = match[1]
+	}
+	rendered.WriteString(template.HTMLEscapeString(body[last:]))
+	return template.HTML(rendered.String())
+}
+
+func (s *Server) notebooksForm(w http.ResponseWriter, r *http.Request) {
+	renderNotebooks(w, s.notebooks, "")
+}
+
+// switchNotebook unlocks the notebook with the password from the form and then uses it instead of
+// the current notebook, whose key is dropped once the requests that are using it have finished.
+func (s *Server) switchNotebook(w http.ResponseWriter, r *http.Request) {
+	if s.open == nil {
+		http.Error(w, "Notebooks can't be switched", 404)
+		return
+	}
+	r.ParseForm()
+	name := r.Form.Get("notebook")
+	found := false
+	for _, notebook := range s.notebooks {
+		found = found || notebook == name
+	}
+	if !found {
+		http.Error(w, "Couldn't find a notebook with that name", 404)
+		return
+	}
+	password := crypto.SecretFromBytes([]byte(r.Form.Get("password")))
+	driver, err := s.open(name, password)
+	password.Close()
+	if err != nil {
+		log.Printf("Failed to open the notebook %s because %s", name, err)
+		renderNotebooks(w, s.notebooks, fmt.Sprintf("Couldn't open %s", name))
+		return
+	}
+
+	s.lock.Lock()
+	previous := s.notebook
+	s.notebook = &openNotebook{name: name, driver: driver}
+	s.lock.Unlock()
+	if err = previous.close(); err != nil {
+		log.Printf("Failed to close the previous notebook because %s", err)
+	}
+	http.Redirect(w, r, "/", 303)
+}
+
+func renderNotebooks(w http.ResponseWriter, notebooks []string, message string) {
+	data := struct {
+		Title     string
+		Notebooks []string
+		Message   string
+	}{"Notebooks", notebooks, message}
+	err := notebooksPage.Execute(w, data)
+	if err != nil {
+		log.Printf("Failed to generate notebooks because %s", err)
+		http.Error(w, "A Server Error occured", 500)
+	}
+}
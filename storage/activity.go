@@ -0,0 +1,130 @@
+package storage
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	mathrand "math/rand"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/btobolaski/ejrnl/crypto"
+)
+
+// normalizedTime is the modification time given to every file when activity is hidden.
+var normalizedTime = time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// defaultCoverWrites is the number of entries rewritten on each write when it isn't configured.
+const defaultCoverWrites = 3
+
+// writeFile writes a file in the journal's directory. With secure delete, the file's previous
+// contents are overwritten once the new contents are in place.
+func (d *Driver) writeFile(name string, data []byte) error {
+	path := fmt.Sprintf("%s/%s", d.directory, name)
+	if _, err := os.Lstat(path); d.secureDelete && err == nil {
+		if err = d.replaceFile(path, data); err != nil {
+			return err
+		}
+	} else if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		return err
+	}
+	if d.hideActivity {
+		return os.Chtimes(path, normalizedTime, normalizedTime)
+	}
+	return nil
+}
+
+// replaceFile writes the new contents beside the file, keeps a link to the previous contents while
+// the new ones are moved into place and then shreds them.
+func (d *Driver) replaceFile(path string, data []byte) error {
+	replacement, previous := path+".new", path+".old"
+	if err := ioutil.WriteFile(replacement, data, 0600); err != nil {
+		return err
+	}
+	if err := os.Link(path, previous); err != nil {
+		// Without links, the previous contents are shredded first
+		if err = crypto.Shred(path); err != nil {
+			return err
+		}
+		return os.Rename(replacement, path)
+	}
+	if err := os.Rename(replacement, path); err != nil {
+		os.Remove(previous)
+		return err
+	}
+	return crypto.Shred(previous)
+}
+
+// remove removes a file in the journal's directory, shredding it with secure delete.
+func (d *Driver) remove(name string) error {
+	path := fmt.Sprintf("%s/%s", d.directory, name)
+	if d.secureDelete {
+		return crypto.Shred(path)
+	}
+	return os.Remove(path)
+}
+
+// normalizeTime resets the modification time of the file, or of the journal's directory if name is
+// empty, which changes whenever a file is added or removed.
+func (d *Driver) normalizeTime(name string) {
+	path := d.directory
+	if name != "" {
+		path = fmt.Sprintf("%s/%s", d.directory, name)
+	}
+	if err := os.Chtimes(path, normalizedTime, normalizedTime); err != nil {
+		log.Printf("Failed to normalize the modification time of %s because %s", path, err)
+	}
+}
+
+// rewriteRandom reencrypts random entries, other than the ones that were just written, so that the
+// files that change on disk don't reveal which entries were written. Failures are logged because
+// the real write has already succeeded.
+func (d *Driver) rewriteRandom(written index) {
+	count := d.coverWrites
+	if count <= 0 {
+		count = defaultCoverWrites
+	}
+
+	skip := map[string]bool{indexFile: true}
+	for _, entry := range written {
+		skip[entry.File] = true
+	}
+	files, err := ioutil.ReadDir(d.directory)
+	if err != nil {
+		log.Printf("Failed to list the journal for cover writes because %s", err)
+		return
+	}
+	candidates := []string{}
+	for _, file := range files {
+		if strings.HasSuffix(file.Name(), ".cpt") && !skip[file.Name()] {
+			candidates = append(candidates, file.Name())
+		}
+	}
+	for _, i := range mathrand.Perm(len(candidates)) {
+		if count == 0 {
+			break
+		}
+		count--
+		if err := d.rewriteFile(candidates[i]); err != nil {
+			log.Printf("Failed to rewrite %s because %s", candidates[i], err)
+		}
+	}
+}
+
+// rewriteFile reencrypts a file without changing its contents.
+func (d *Driver) rewriteFile(name string) error {
+	cyphertext, err := ioutil.ReadFile(fmt.Sprintf("%s/%s", d.directory, name))
+	if err != nil {
+		return err
+	}
+	plaintext, err := d.decrypt(cyphertext)
+	if err != nil {
+		return err
+	}
+	cyphertext, err = d.compression.CompressAndEncrypt(plaintext, d.key.Bytes())
+	if err != nil {
+		return err
+	}
+	return d.writeFile(name, cyphertext)
+}
@@ -0,0 +1,74 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/btobolaski/ejrnl"
+	"github.com/btobolaski/ejrnl/crypto"
+)
+
+const (
+	// FilesBackend stores each entry in its own file alongside an encrypted index
+	FilesBackend = "files"
+	// SQLiteBackend stores the journal in a single SQLite database
+	SQLiteBackend = "sqlite"
+	// S3Backend stores the journal in an S3 compatible bucket
+	S3Backend = "s3"
+)
+
+// Journal is implemented by all of the backends.
+type Journal interface {
+	ejrnl.Driver
+	// Key returns the key that the journal is encrypted with
+	Key() *crypto.Secret
+	// HasKeySlots returns whether the journal's key is wrapped
+	HasKeySlots() bool
+}
+
+// checkBackend checks that the config's options are supported by its backend.
+func checkBackend(conf ejrnl.Config) error {
+	if conf.Backend == "" || conf.Backend == FilesBackend {
+		return nil
+	}
+	if conf.Git {
+		return errors.New("Only the files backend can be stored in git")
+	}
+	return nil
+}
+
+// Open creates a driver for the backend in the config. Like the backends' constructors, it returns
+// NeedsInit along with a usable driver when the journal doesn't exist yet.
+func Open(conf ejrnl.Config, password *crypto.Secret) (Journal, error) {
+	if err := checkBackend(conf); err != nil {
+		return &Driver{}, err
+	}
+	switch conf.Backend {
+	case "", FilesBackend:
+		return NewDriverWithPassword(conf, password)
+	case SQLiteBackend:
+		return NewSQLiteDriverWithPassword(conf, password)
+	case S3Backend:
+		return NewS3DriverWithPassword(conf, password)
+	}
+	return &Driver{}, fmt.Errorf("Unknown storage backend %s", conf.Backend)
+}
+
+// OpenWithKey creates a driver for the backend in the config from the journal's key. The driver
+// takes ownership of the key.
+func OpenWithKey(conf ejrnl.Config, key *crypto.Secret) (Journal, error) {
+	if err := checkBackend(conf); err != nil {
+		key.Close()
+		return &Driver{}, err
+	}
+	switch conf.Backend {
+	case "", FilesBackend:
+		return NewDriverWithKey(conf, key)
+	case SQLiteBackend:
+		return NewSQLiteDriverWithKey(conf, key)
+	case S3Backend:
+		return NewS3DriverWithKey(conf, key)
+	}
+	key.Close()
+	return &Driver{}, fmt.Errorf("Unknown storage backend %s", conf.Backend)
+}
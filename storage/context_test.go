@@ -0,0 +1,112 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/btobolaski/ejrnl"
+)
+
+// countdownContext is canceled after Err has been called a number of times so that operations can
+// be canceled part way through.
+type countdownContext struct {
+	context.Context
+	remaining int
+}
+
+func (c *countdownContext) Err() error {
+	if c.remaining <= 0 {
+		return context.Canceled
+	}
+	c.remaining--
+	return nil
+}
+
+func entries(count int) []ejrnl.Entry {
+	batch := []ejrnl.Entry{}
+	for i := 0; i < count; i++ {
+		batch = append(batch, ejrnl.Entry{Id: fmt.Sprintf("%d", i), Body: "body"})
+	}
+	return batch
+}
+
+func TestWriteBatchCanceled(t *testing.T) {
+	conf := ejrnl.Config{StorageDirectory: "./write-canceled-test", Salt: makeSalt(32), Pow: 12}
+	d, err := driverInit(conf)
+	defer os.RemoveAll(conf.StorageDirectory)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The first check is before anything is written, then one check for each entry
+	ctx := &countdownContext{Context: context.Background(), remaining: 3}
+	if err = d.WriteBatchContext(ctx, entries(5)); err != context.Canceled {
+		t.Fatalf("Expected the write to be canceled, got %v", err)
+	}
+	listing, err := d.List()
+	if err != nil || len(listing) != 2 {
+		t.Fatalf("Expected the entries written before it was canceled to be indexed, got %v %v", listing, err)
+	}
+	for _, id := range listing {
+		if _, err = d.Read(id); err != nil {
+			t.Errorf("Failed to read %s because %s", id, err)
+		}
+	}
+}
+
+func TestSQLiteWriteCanceled(t *testing.T) {
+	d := openInit(t, sqliteConfig(t)).(*SQLiteDriver)
+	defer d.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := d.WriteBatchContext(ctx, entries(5)); err == nil {
+		t.Fatal("Expected the canceled write to fail")
+	}
+	listing, err := d.List()
+	if err != nil || len(listing) != 0 {
+		t.Errorf("Expected the canceled transaction to be rolled back, got %v %v", listing, err)
+	}
+}
+
+func TestInitCanceled(t *testing.T) {
+	conf := ejrnl.Config{StorageDirectory: "./init-canceled-test", Salt: makeSalt(32), Pow: 12}
+	d, err := driverInit(conf)
+	defer os.RemoveAll(conf.StorageDirectory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = d.WriteBatch(entries(5)); err != nil {
+		t.Fatalf("Failed to write entries because %s", err)
+	}
+	d.Close()
+	os.Remove(fmt.Sprintf("%s/%s", conf.StorageDirectory, indexFile))
+	os.Remove(fmt.Sprintf("%s/%s", conf.StorageDirectory, indexLogFile))
+
+	d, err = NewDriver(conf, "password")
+	if _, ok := err.(*NeedsInit); !ok {
+		t.Fatalf("Expected the journal without an index to need init, got %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err = d.InitContext(ctx); err != context.Canceled {
+		t.Fatalf("Expected recovery to be canceled, got %v", err)
+	}
+	d.Close()
+
+	// The journal still needs to be recovered
+	d, err = NewDriver(conf, "password")
+	if _, ok := err.(*NeedsInit); !ok {
+		t.Fatalf("Expected the journal to still need init, got %v", err)
+	}
+	defer d.Close()
+	if err = d.Init(); err != nil {
+		t.Fatalf("Failed to recover the index because %s", err)
+	}
+	listing, err := d.List()
+	if err != nil || len(listing) != 5 {
+		t.Errorf("Expected every entry to be recovered, got %v %v", listing, err)
+	}
+}
@@ -0,0 +1,134 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/btobolaski/ejrnl/compression"
+)
+
+// dictionaryDirectory holds the journal's encrypted zstd dictionaries. Every dictionary that has
+// been trained is kept so that the entries compressed with it remain readable.
+const dictionaryDirectory = "dictionaries"
+
+// loadDictionaries decrypts all of the journal's dictionaries.
+func (d *Driver) loadDictionaries() error {
+	files, err := ioutil.ReadDir(fmt.Sprintf("%s/%s", d.directory, dictionaryDirectory))
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		if !strings.HasSuffix(file.Name(), ".cpt") {
+			continue
+		}
+		cyphertext, err := ioutil.ReadFile(fmt.Sprintf("%s/%s/%s", d.directory, dictionaryDirectory, file.Name()))
+		if err != nil {
+			return err
+		}
+		raw, err := compression.DecryptAndDecompress(cyphertext, d.key.Bytes())
+		if err != nil {
+			return fmt.Errorf("Failed to decrypt dictionary %s because %s", file.Name(), err)
+		}
+		if _, err = d.compression.Dictionaries.Add(raw); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// decrypt decrypts and decompresses data from the journal. If the data was compressed with a
+// dictionary that another process trained after this driver opened, the dictionaries are reloaded
+// and the data is decompressed again.
+func (d *Driver) decrypt(cyphertext []byte) ([]byte, error) {
+	plaintext, err := d.compression.DecryptAndDecompress(cyphertext, d.key.Bytes())
+	if err != compression.ErrUnknownDictionary {
+		return plaintext, err
+	}
+	if loadErr := d.loadDictionaries(); loadErr != nil {
+		return []byte{}, fmt.Errorf("Failed to reload dictionaries because %s", loadErr)
+	}
+	return d.compression.DecryptAndDecompress(cyphertext, d.key.Bytes())
+}
+
+// TrainDictionary trains a new zstd dictionary from the journal's entries. New writes are
+// compressed with it. It returns the new dictionary's id.
+func (d *Driver) TrainDictionary() (uint32, error) {
+	if id, _ := compression.Lookup(d.compression.Codec); id != compression.Zstd {
+		return 0, errors.New("Dictionaries are only used with zstd compression. Set compression to zstd in the config first")
+	}
+
+	listing, err := d.List()
+	if err != nil {
+		return 0, err
+	}
+	samples := [][]byte{}
+	for _, id := range listing {
+		entry, err := d.Read(id)
+		if err != nil {
+			return 0, err
+		}
+		plaintext, err := json.Marshal(entry)
+		if err != nil {
+			return 0, err
+		}
+		samples = append(samples, plaintext)
+	}
+
+	id := d.compression.Dictionaries.Latest() + 1
+	if id < compression.FirstDictionaryId {
+		id = compression.FirstDictionaryId
+	}
+	raw, err := compression.TrainDictionary(samples, id)
+	if err != nil {
+		return 0, err
+	}
+	if err = d.saveDictionary(id, raw); err != nil {
+		return 0, err
+	}
+	return d.compression.Dictionaries.Add(raw)
+}
+
+// Dictionaries returns every dictionary that the journal has trained, oldest first.
+func (d *Driver) Dictionaries() [][]byte {
+	return d.compression.Dictionaries.All()
+}
+
+// AddDictionary keeps a dictionary that was trained by another journal, such as the one that this
+// journal was rekeyed from.
+func (d *Driver) AddDictionary(raw []byte) error {
+	info, err := zstd.InspectDictionary(raw)
+	if err != nil {
+		return fmt.Errorf("Failed to load dictionary because %s", err)
+	}
+	if err = d.saveDictionary(info.ID(), raw); err != nil {
+		return err
+	}
+	_, err = d.compression.Dictionaries.Add(raw)
+	return err
+}
+
+// saveDictionary encrypts the dictionary and writes it to the journal.
+func (d *Driver) saveDictionary(id uint32, raw []byte) error {
+	// The dictionary is already compressed so, it is only encrypted.
+	cyphertext, err := compression.Options{Codec: "none"}.CompressAndEncrypt(append([]byte{}, raw...), d.key.Bytes())
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(fmt.Sprintf("%s/%s", d.directory, dictionaryDirectory), 0700); err != nil {
+		return err
+	}
+	err = d.writeFile(fmt.Sprintf("%s/%d.cpt", dictionaryDirectory, id), cyphertext)
+	if err != nil {
+		return err
+	}
+	return d.commit()
+}
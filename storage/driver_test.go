@@ -1,15 +1,23 @@
 package storage
 
 import (
+	"context"
 	"crypto/rand"
 	"encoding/base64"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"os"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/termie/go-shutil"
+
 	"github.com/btobolaski/ejrnl"
+	"github.com/btobolaski/ejrnl/compression"
+	"github.com/btobolaski/ejrnl/crypto"
+	"github.com/btobolaski/ejrnl/storage/drivertest"
 )
 
 func makeSalt(b int) string {
@@ -21,6 +29,16 @@ func makeSalt(b int) string {
 	return base64.StdEncoding.EncodeToString(bytes)
 }
 
+// copyFixture copies a committed journal to a temporary directory so that opening it doesn't write
+// a lock or anything else into the tree.
+func copyFixture(t *testing.T, fixture string) string {
+	directory := fmt.Sprintf("%s/journal", t.TempDir())
+	if err := shutil.CopyTree(fixture, directory, nil); err != nil {
+		t.Fatalf("Failed to copy %s because %s", fixture, err)
+	}
+	return directory
+}
+
 func TestDriverNeedsInit(t *testing.T) {
 	t.Parallel()
 	conf := ejrnl.Config{
@@ -230,7 +248,8 @@ func TestIndexRecovery(t *testing.T) {
 		t.Errorf("index did not contain previously written entry")
 		return
 	}
-	read, err := d.Read(index[*entry.Date])
+	// Dates from time.Now have a monotonic clock reading, which the listed dates never have
+	read, err := d.Read(index[entry.Date.Round(0)])
 	if err != nil {
 		t.Errorf("Failed to read recovered entry because %s", err)
 		return
@@ -334,8 +353,9 @@ func TestTildeExpand(t *testing.T) {
 
 func TestV1Decode(t *testing.T) {
 	t.Parallel()
+	directory := copyFixture(t, "./v1-decode-test")
 	conf := ejrnl.Config{
-		StorageDirectory: "./v1-decode-test",
+		StorageDirectory: directory,
 		Salt:             "W0qqYZBcZXo8yYudevU69F3bPblsg7zZ51hihbT+72w=",
 		Pow:              12,
 	}
@@ -367,8 +387,9 @@ func TestV1Decode(t *testing.T) {
 
 func TestV2Decode(t *testing.T) {
 	t.Parallel()
+	directory := copyFixture(t, "./v2-decode-test")
 	conf := ejrnl.Config{
-		StorageDirectory: "./v2-decode-test",
+		StorageDirectory: directory,
 		Salt:             "W0qqYZBcZXo8yYudevU69F3bPblsg7zZ51hihbT+72w=",
 		Pow:              12,
 	}
@@ -397,3 +418,636 @@ func TestV2Decode(t *testing.T) {
 		t.Errorf("Entries aren't equal \n%v\n%v", entry, read)
 	}
 }
+
+func TestDriverClose(t *testing.T) {
+	t.Parallel()
+	conf := ejrnl.Config{
+		StorageDirectory: "./close-test",
+		Salt:             makeSalt(32),
+		Pow:              12,
+	}
+
+	d, err := driverInit(conf)
+	defer os.RemoveAll(conf.StorageDirectory)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	key := d.key.Bytes()
+	if err = d.Close(); err != nil {
+		t.Errorf("Failed to close driver because %s", err)
+		return
+	}
+	for _, b := range key {
+		if b != 0 {
+			t.Error("Key wasn't wiped when the driver was closed")
+			break
+		}
+	}
+	if _, err = d.List(); err == nil {
+		t.Error("Closed driver was still able to read the index")
+	}
+}
+
+func TestDriverWithKey(t *testing.T) {
+	t.Parallel()
+	conf := ejrnl.Config{
+		StorageDirectory: "./with-key-test",
+		Salt:             makeSalt(32),
+		Pow:              12,
+	}
+
+	d, err := driverInit(conf)
+	defer os.RemoveAll(conf.StorageDirectory)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer d.Close()
+
+	key := crypto.SecretFromBytes(append([]byte{}, d.Key().Bytes()...))
+	withKey, err := NewDriverWithKey(conf, key)
+	if err != nil {
+		t.Errorf("Failed to open the journal with its key because %s", err)
+		return
+	}
+	withKey.Close()
+
+	wrongKey := crypto.NewSecret(16)
+	if _, err = NewDriverWithKey(conf, wrongKey); err == nil {
+		t.Error("An incorrect key didn't cause the driver to error")
+	}
+}
+
+func TestDestroy(t *testing.T) {
+	t.Parallel()
+	conf := ejrnl.Config{
+		StorageDirectory: "./destroy-test",
+		Salt:             makeSalt(32),
+		Pow:              12,
+	}
+
+	d, err := driverInit(conf)
+	defer os.RemoveAll(conf.StorageDirectory)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	entry := ejrnl.Entry{Body: "Hello", Id: "1111111111111111111"}
+	if err = d.Write(entry); err != nil {
+		t.Errorf("Failed to write entry because %s", err)
+		return
+	}
+	d.Close()
+
+	if err = Destroy(conf); err != nil {
+		t.Errorf("Failed to destroy journal because %s", err)
+		return
+	}
+	for _, name := range []string{keySlotsFile, "index.cpt"} {
+		if _, err := os.Stat(fmt.Sprintf("%s/%s", conf.StorageDirectory, name)); !os.IsNotExist(err) {
+			t.Errorf("%s still exists after destroying the journal", name)
+		}
+	}
+
+	d, err = NewDriver(conf, "password")
+	if _, ok := err.(*NeedsInit); !ok {
+		t.Errorf("Expected the destroyed journal to need init but got %v", err)
+	}
+	defer d.Close()
+	if _, err = d.Read(entry.Id); err == nil {
+		t.Error("The entry was still readable after the journal was destroyed")
+	}
+}
+
+func TestDestroyDerivedKey(t *testing.T) {
+	t.Parallel()
+	directory := copyFixture(t, "./v1-decode-test")
+	conf := ejrnl.Config{
+		StorageDirectory: directory,
+		Salt:             "W0qqYZBcZXo8yYudevU69F3bPblsg7zZ51hihbT+72w=",
+		Pow:              12,
+	}
+
+	if err := DestroyKeys(conf); err != ErrDerivedKey {
+		t.Errorf("Expected ErrDerivedKey but got %v", err)
+	}
+}
+
+func TestRecoverDerivedKeyWithoutLooseEntries(t *testing.T) {
+	t.Parallel()
+	// Each case moves the entry out of the journal's root and then back so that it can be read
+	moves := map[string][2]func(d *Driver, id string) error{
+		"packed": {
+			func(d *Driver, id string) error {
+				_, err := d.Pack(time.Now())
+				return err
+			},
+			func(d *Driver, id string) error { return nil },
+		},
+		"trashed": {
+			func(d *Driver, id string) error { return d.Delete(context.Background(), id) },
+			func(d *Driver, id string) error { return d.Restore(context.Background(), id) },
+		},
+	}
+	for name, move := range moves {
+		conf := ejrnl.Config{
+			StorageDirectory: copyFixture(t, "./v1-decode-test"),
+			Salt:             "W0qqYZBcZXo8yYudevU69F3bPblsg7zZ51hihbT+72w=",
+			Pow:              12,
+		}
+		d, err := NewDriver(conf, "password")
+		if err != nil {
+			t.Errorf("Failed to create driver because %s", err)
+			return
+		}
+		if err = move[0](d, "1111111111111111111"); err != nil {
+			t.Errorf("Failed to move the %s entry because %s", name, err)
+			return
+		}
+		d.Close()
+		for _, file := range []string{indexFile, indexLogFile} {
+			os.Remove(fmt.Sprintf("%s/%s", conf.StorageDirectory, file))
+		}
+
+		d, err = NewDriver(conf, "password")
+		if _, ok := err.(*NeedsInit); !ok {
+			t.Errorf("Expected the %s journal to need init but got %v", name, err)
+			return
+		}
+		if err = d.Init(); err != nil {
+			t.Errorf("Failed to recover the %s journal because %s", name, err)
+			return
+		}
+		if hasKeySlots(conf.StorageDirectory) {
+			t.Errorf("The %s journal was given a new key", name)
+		}
+		if err = move[1](d, "1111111111111111111"); err != nil {
+			t.Errorf("Failed to move the %s entry back because %s", name, err)
+			return
+		}
+		if _, err = d.Read("1111111111111111111"); err != nil {
+			t.Errorf("Failed to read the %s entry because %s", name, err)
+		}
+	}
+}
+
+func TestInitRefusesNewKeyForEntries(t *testing.T) {
+	t.Parallel()
+	conf := ejrnl.Config{
+		StorageDirectory: "./init-refuses-test",
+		Salt:             makeSalt(32),
+		Pow:              12,
+	}
+	defer os.RemoveAll(conf.StorageDirectory)
+
+	d, err := NewDriver(conf, "password")
+	if _, ok := err.(*NeedsInit); !ok {
+		t.Errorf("Expected NeedsInit but got %v", err)
+		return
+	}
+	// Entries that appear after the journal was opened can't be read with its new key
+	trash := fmt.Sprintf("%s/%s", conf.StorageDirectory, trashDirectory)
+	if err = os.MkdirAll(trash, 0700); err != nil {
+		t.Errorf("Failed to create the trash because %s", err)
+		return
+	}
+	entry := make([]byte, 256)
+	rand.Read(entry)
+	if err = ioutil.WriteFile(trash+"/entry.cpt", entry, 0600); err != nil {
+		t.Errorf("Failed to write the entry because %s", err)
+		return
+	}
+	if err = d.Init(); err != errHasEntries {
+		t.Errorf("Expected errHasEntries but got %v", err)
+	}
+	if hasKeySlots(conf.StorageDirectory) {
+		t.Error("The key slots were written")
+	}
+}
+
+func TestDuress(t *testing.T) {
+	t.Parallel()
+	conf := ejrnl.Config{
+		StorageDirectory: "./duress-test",
+		Salt:             makeSalt(32),
+		Pow:              12,
+	}
+
+	duress := crypto.SecretFromBytes([]byte("duress"))
+	defer duress.Close()
+	if IsDuress(conf, duress) {
+		t.Error("The password was a duress password without one being configured")
+	}
+
+	hash, err := DuressHash(conf, duress)
+	if err != nil {
+		t.Errorf("Failed to hash duress password because %s", err)
+		return
+	}
+	conf.Duress = hash
+
+	password := crypto.SecretFromBytes([]byte("password"))
+	defer password.Close()
+	if !IsDuress(conf, duress) {
+		t.Error("The duress password wasn't recognized")
+	}
+	if IsDuress(conf, password) {
+		t.Error("The normal password was recognized as the duress password")
+	}
+}
+
+func TestCompressionCodecs(t *testing.T) {
+	t.Parallel()
+	for _, codec := range compression.Codecs() {
+		conf := ejrnl.Config{
+			StorageDirectory: fmt.Sprintf("./codec-%s-test", codec),
+			Salt:             makeSalt(32),
+			Pow:              12,
+			Compression:      codec,
+		}
+
+		d, err := driverInit(conf)
+		defer os.RemoveAll(conf.StorageDirectory)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		now := time.Now()
+		entry := ejrnl.Entry{
+			Date: &now,
+			Body: "Hello",
+			Id:   "1111111111111111111",
+			Tags: []string{"test"},
+		}
+		if err = d.Write(entry); err != nil {
+			t.Errorf("Failed to write entry with %s because %s", codec, err)
+			continue
+		}
+		read, err := d.Read(entry.Id)
+		if err != nil {
+			t.Errorf("Failed to read entry with %s because %s", codec, err)
+			continue
+		}
+		if !compareEntries(entry, read) {
+			t.Errorf("Entries aren't equal with %s\n%v\n%v", codec, entry, read)
+		}
+	}
+}
+
+func TestUnknownCompression(t *testing.T) {
+	t.Parallel()
+	conf := ejrnl.Config{
+		StorageDirectory: "./unknown-compression",
+		Salt:             makeSalt(32),
+		Pow:              12,
+		Compression:      "lzma",
+	}
+
+	_, err := NewDriver(conf, "password")
+	defer os.RemoveAll(conf.StorageDirectory)
+	if err == nil {
+		t.Error("An unknown codec didn't cause the driver to error")
+	}
+}
+
+func TestTrainDictionary(t *testing.T) {
+	t.Parallel()
+	conf := ejrnl.Config{
+		StorageDirectory: "./dictionary-test",
+		Salt:             makeSalt(32),
+		Pow:              12,
+		Compression:      "zstd",
+	}
+
+	d, err := driverInit(conf)
+	defer os.RemoveAll(conf.StorageDirectory)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	entries := []ejrnl.Entry{}
+	for i := 0; i < 20; i++ {
+		date := time.Date(2016, 12, i+1, 8, 0, 0, 0, time.UTC)
+		entry := ejrnl.Entry{
+			Date: &date,
+			Body: fmt.Sprintf("Went for a walk in the morning and wrote %d pages in the afternoon.", i),
+			Id:   fmt.Sprintf("%d", i),
+			Tags: []string{"daily"},
+		}
+		if err = d.Write(entry); err != nil {
+			t.Errorf("Failed to write entry because %s", err)
+			return
+		}
+		entries = append(entries, entry)
+	}
+
+	if _, err = d.TrainDictionary(); err != nil {
+		t.Errorf("Failed to train dictionary because %s", err)
+		return
+	}
+	date := time.Date(2017, 1, 1, 8, 0, 0, 0, time.UTC)
+	entry := ejrnl.Entry{Date: &date, Body: "Went for a walk.", Id: "after", Tags: []string{"daily"}}
+	if err = d.Write(entry); err != nil {
+		t.Errorf("Failed to write entry because %s", err)
+		return
+	}
+	entries = append(entries, entry)
+	d.Close()
+
+	d, err = NewDriver(conf, "password")
+	if err != nil {
+		t.Errorf("Failed to reopen journal because %s", err)
+		return
+	}
+	defer d.Close()
+	for _, entry := range entries {
+		read, err := d.Read(entry.Id)
+		if err != nil {
+			t.Errorf("Failed to read entry %s because %s", entry.Id, err)
+			continue
+		}
+		if !compareEntries(entry, read) {
+			t.Errorf("Entries aren't equal \n%v\n%v", entry, read)
+		}
+	}
+}
+
+func TestDictionaryReload(t *testing.T) {
+	t.Parallel()
+	conf := ejrnl.Config{
+		StorageDirectory: "./dictionary-reload-test",
+		Salt:             makeSalt(32),
+		Pow:              12,
+		Compression:      "zstd",
+	}
+
+	d, err := driverInit(conf)
+	defer os.RemoveAll(conf.StorageDirectory)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer d.Close()
+	for i := 0; i < 20; i++ {
+		date := time.Date(2016, 12, i+1, 8, 0, 0, 0, time.UTC)
+		entry := ejrnl.Entry{
+			Date: &date,
+			Body: fmt.Sprintf("Went for a walk in the morning and wrote %d pages in the afternoon.", i),
+			Id:   fmt.Sprintf("%d", i),
+			Tags: []string{"daily"},
+		}
+		if err = d.Write(entry); err != nil {
+			t.Errorf("Failed to write entry because %s", err)
+			return
+		}
+	}
+
+	// Another process, such as the cli while the server is running, trains a dictionary.
+	other, err := NewDriver(conf, "password")
+	if err != nil {
+		t.Errorf("Failed to open journal because %s", err)
+		return
+	}
+	defer other.Close()
+	if _, err = other.TrainDictionary(); err != nil {
+		t.Errorf("Failed to train dictionary because %s", err)
+		return
+	}
+	date := time.Date(2017, 1, 1, 8, 0, 0, 0, time.UTC)
+	entry := ejrnl.Entry{Date: &date, Body: "Went for a walk.", Id: "after", Tags: []string{"daily"}}
+	if err = other.Write(entry); err != nil {
+		t.Errorf("Failed to write entry because %s", err)
+		return
+	}
+
+	read, err := d.Read(entry.Id)
+	if err != nil {
+		t.Errorf("Failed to read an entry compressed with a new dictionary because %s", err)
+		return
+	}
+	if !compareEntries(entry, read) {
+		t.Errorf("Entries aren't equal \n%v\n%v", entry, read)
+	}
+}
+
+func TestOpaqueFilenames(t *testing.T) {
+	t.Parallel()
+	conf := ejrnl.Config{
+		StorageDirectory: "./opaque-filenames-test",
+		Salt:             makeSalt(32),
+		Pow:              12,
+	}
+
+	d, err := driverInit(conf)
+	defer os.RemoveAll(conf.StorageDirectory)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	entry := ejrnl.Entry{Body: "Hello", Id: "2019-divorce-notes"}
+	if err = d.Write(entry); err != nil {
+		t.Errorf("Failed to write entry because %s", err)
+		return
+	}
+
+	files, err := ioutil.ReadDir(conf.StorageDirectory)
+	if err != nil {
+		t.Errorf("Failed to list journal because %s", err)
+		return
+	}
+	for _, file := range files {
+		if strings.Contains(file.Name(), "divorce") {
+			t.Errorf("The file name %s reveals the entry's id", file.Name())
+		}
+	}
+
+	index, err := d.readIndex()
+	if err != nil {
+		t.Errorf("Failed to read index because %s", err)
+		return
+	}
+	if index[entry.Id].File != d.filename(entry.Id) {
+		t.Errorf("The index didn't map the id to its file, %v", index)
+	}
+}
+
+func TestLegacyFilenames(t *testing.T) {
+	t.Parallel()
+	conf := ejrnl.Config{
+		StorageDirectory: "./legacy-filenames-test",
+		Salt:             "W0qqYZBcZXo8yYudevU69F3bPblsg7zZ51hihbT+72w=",
+		Pow:              12,
+	}
+	defer os.RemoveAll(conf.StorageDirectory)
+	if err := shutil.CopyTree("./v2-decode-test", conf.StorageDirectory, nil); err != nil {
+		t.Errorf("Failed to copy the legacy journal because %s", err)
+		return
+	}
+
+	d, err := NewDriver(conf, "password")
+	if err != nil {
+		t.Errorf("Failed to create driver because %s", err)
+		return
+	}
+	entry, err := d.Read("1111111111111111111")
+	if err != nil {
+		t.Errorf("Failed to read entry because %s", err)
+		return
+	}
+	entry.Body = "Rewritten"
+	if err = d.Write(entry); err != nil {
+		t.Errorf("Failed to rewrite entry because %s", err)
+		return
+	}
+
+	if _, err = os.Stat(conf.StorageDirectory + "/1111111111111111111.cpt"); !os.IsNotExist(err) {
+		t.Error("The legacy file wasn't removed when the entry was rewritten")
+	}
+	read, err := d.Read(entry.Id)
+	if err != nil {
+		t.Errorf("Failed to read rewritten entry because %s", err)
+		return
+	}
+	if !compareEntries(entry, read) {
+		t.Errorf("Entries aren't equal \n%v\n%v", entry, read)
+	}
+	listing, err := d.List()
+	if err != nil || len(listing) != 1 {
+		t.Errorf("Expected a single entry in the listing, got %v %v", listing, err)
+	}
+}
+
+func TestHideActivity(t *testing.T) {
+	t.Parallel()
+	conf := ejrnl.Config{
+		StorageDirectory: "./hide-activity-test",
+		Salt:             makeSalt(32),
+		Pow:              12,
+		HideActivity:     true,
+		CoverWrites:      2,
+	}
+
+	d, err := driverInit(conf)
+	defer os.RemoveAll(conf.StorageDirectory)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	entries := []ejrnl.Entry{}
+	for i := 0; i < 5; i++ {
+		entries = append(entries, ejrnl.Entry{Body: fmt.Sprintf("Entry %d", i), Id: fmt.Sprintf("%d", i)})
+	}
+	if err = d.WriteBatch(entries); err != nil {
+		t.Errorf("Failed to write batch because %s", err)
+		return
+	}
+	for _, entry := range entries {
+		read, err := d.Read(entry.Id)
+		if err != nil || read.Body != entry.Body {
+			t.Errorf("Failed to read %s back, got %#v, %s", entry.Id, read, err)
+		}
+	}
+
+	before := map[string][]byte{}
+	files, _ := ioutil.ReadDir(conf.StorageDirectory)
+	for _, file := range files {
+		if !file.ModTime().Equal(normalizedTime) {
+			t.Errorf("%s was modified at %s", file.Name(), file.ModTime())
+		}
+		before[file.Name()], _ = ioutil.ReadFile(fmt.Sprintf("%s/%s", conf.StorageDirectory, file.Name()))
+	}
+	info, _ := os.Stat(conf.StorageDirectory)
+	if !info.ModTime().Equal(normalizedTime) {
+		t.Errorf("The directory was modified at %s", info.ModTime())
+	}
+
+	if err = d.Write(ejrnl.Entry{Body: "Another", Id: "5"}); err != nil {
+		t.Errorf("Failed to write entry because %s", err)
+		return
+	}
+	changed := 0
+	for name, contents := range before {
+		if name == indexFile || name == indexLogFile || name == lockFile {
+			continue
+		}
+		after, _ := ioutil.ReadFile(fmt.Sprintf("%s/%s", conf.StorageDirectory, name))
+		if string(after) != string(contents) {
+			changed++
+		}
+	}
+	if changed != conf.CoverWrites {
+		t.Errorf("Expected %d cover writes but %d files changed", conf.CoverWrites, changed)
+	}
+}
+
+func TestConformance(t *testing.T) {
+	drivertest.Run(t, func(t *testing.T) ejrnl.Driver {
+		directory, err := ioutil.TempDir("", "ejrnl-conformance")
+		if err != nil {
+			t.Fatalf("Failed to create a directory because %s", err)
+		}
+		t.Cleanup(func() { os.RemoveAll(directory) })
+
+		d, err := driverInit(ejrnl.Config{StorageDirectory: directory, Salt: makeSalt(32), Pow: 12})
+		if err != nil {
+			t.Fatalf("Failed to init the journal because %s", err)
+		}
+		return d
+	})
+}
+
+func TestSecureDelete(t *testing.T) {
+	conf := ejrnl.Config{StorageDirectory: "./secure-delete-test", Salt: makeSalt(32), Pow: 12, SecureDelete: true}
+	d, err := driverInit(conf)
+	defer os.RemoveAll(conf.StorageDirectory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	if err = d.Write(ejrnl.Entry{Id: "1", Body: "first"}); err != nil {
+		t.Fatalf("Failed to write entry because %s", err)
+	}
+	index, err := d.readIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := fmt.Sprintf("%s/%s", conf.StorageDirectory, index["1"].File)
+	original, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The link keeps the previous contents' blocks around after they're replaced
+	if err = os.Link(path, path+".test"); err != nil {
+		t.Skipf("Hard links aren't supported, %s", err)
+	}
+	if err = d.Write(ejrnl.Entry{Id: "1", Body: "second"}); err != nil {
+		t.Fatalf("Failed to rewrite entry because %s", err)
+	}
+	previous, err := ioutil.ReadFile(path + ".test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Remove(path + ".test")
+	if string(previous) == string(original) {
+		t.Error("Expected the previous ciphertext to be overwritten")
+	}
+	if entry, err := d.Read("1"); err != nil || entry.Body != "second" {
+		t.Errorf("Expected the rewritten entry, got %#v %v", entry, err)
+	}
+	files, err := ioutil.ReadDir(conf.StorageDirectory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range files {
+		if strings.HasSuffix(file.Name(), ".new") || strings.HasSuffix(file.Name(), ".old") {
+			t.Errorf("Expected %s to be cleaned up", file.Name())
+		}
+	}
+}
@@ -0,0 +1,391 @@
+// Package drivertest is a conformance suite for ejrnl.Driver implementations. A new backend can
+// prove that it behaves like the file driver by calling Run from its tests.
+package drivertest
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/btobolaski/ejrnl"
+)
+
+// Factory creates an empty, inited driver. Any cleanup should be registered with t.Cleanup.
+type Factory func(t *testing.T) ejrnl.Driver
+
+// Run runs every conformance test against drivers created by factory.
+func Run(t *testing.T, factory Factory) {
+	tests := []struct {
+		name string
+		test func(*testing.T, ejrnl.Driver)
+	}{
+		{"RoundTrip", testRoundTrip},
+		{"Overwrite", testOverwrite},
+		{"DefaultId", testDefaultId},
+		{"DefaultDate", testDefaultDate},
+		{"MissingEntry", testMissingEntry},
+		{"Listing", testListing},
+		{"Concurrency", testConcurrency},
+		{"Metadata", testMetadata},
+		{"Summaries", testSummaries},
+		{"Trash", testTrash},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			driver := factory(t)
+			defer driver.Close()
+			test.test(t, driver)
+		})
+	}
+}
+
+// equal compares entries the way they'd be compared after being serialized.
+func equal(first, second ejrnl.Entry) bool {
+	if first.Id != second.Id || first.Body != second.Body || len(first.Tags) != len(second.Tags) {
+		return false
+	}
+	if first.Zone != second.Zone || first.Title != second.Title || first.Mood != second.Mood || !reflect.DeepEqual(first.Location, second.Location) {
+		return false
+	}
+	if len(first.Fields) != len(second.Fields) || (len(first.Fields) > 0 && !reflect.DeepEqual(first.Fields, second.Fields)) {
+		return false
+	}
+	for i := range first.Tags {
+		if first.Tags[i] != second.Tags[i] {
+			return false
+		}
+	}
+	if first.Date == nil || second.Date == nil {
+		return first.Date == second.Date
+	}
+	return first.Date.Equal(*second.Date)
+}
+
+func testRoundTrip(t *testing.T, driver ejrnl.Driver) {
+	date := time.Date(2016, 12, 24, 0, 32, 58, 0, time.UTC)
+	entry := ejrnl.Entry{Id: "round-trip", Date: &date, Body: "This is the body", Tags: []string{"a", "b"}}
+	if err := driver.Write(entry); err != nil {
+		t.Fatalf("Failed to write entry because %s", err)
+	}
+	read, err := driver.Read(entry.Id)
+	if err != nil {
+		t.Fatalf("Failed to read entry because %s", err)
+	}
+	if !equal(entry, read) {
+		t.Errorf("Entries didn't match\ngot:      %#v\nexpected: %#v", read, entry)
+	}
+}
+
+func testOverwrite(t *testing.T, driver ejrnl.Driver) {
+	first := time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC)
+	second := time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC)
+	if err := driver.Write(ejrnl.Entry{Id: "overwrite", Date: &first, Body: "first"}); err != nil {
+		t.Fatalf("Failed to write entry because %s", err)
+	}
+	entry := ejrnl.Entry{Id: "overwrite", Date: &second, Body: "second"}
+	if err := driver.Write(entry); err != nil {
+		t.Fatalf("Failed to overwrite entry because %s", err)
+	}
+
+	read, err := driver.Read(entry.Id)
+	if err != nil {
+		t.Fatalf("Failed to read entry because %s", err)
+	}
+	if !equal(entry, read) {
+		t.Errorf("Entry wasn't overwritten\ngot:      %#v\nexpected: %#v", read, entry)
+	}
+
+	listing, err := driver.List()
+	if err != nil {
+		t.Fatalf("Failed to list entries because %s", err)
+	}
+	if len(listing) != 1 {
+		t.Errorf("Expected the overwritten entry to be listed once, got %v", listing)
+	}
+	for date, id := range listing {
+		if id != entry.Id || !date.Equal(second) {
+			t.Errorf("Listing has %s at %s, expected %s at %s", id, date, entry.Id, second)
+		}
+	}
+}
+
+func testDefaultId(t *testing.T, driver ejrnl.Driver) {
+	date := time.Date(2016, 12, 24, 0, 32, 58, 0, time.UTC)
+	if err := driver.Write(ejrnl.Entry{Date: &date, Body: "No id"}); err != nil {
+		t.Fatalf("Failed to write entry because %s", err)
+	}
+	listing, err := driver.List()
+	if err != nil {
+		t.Fatalf("Failed to list entries because %s", err)
+	}
+	if len(listing) != 1 {
+		t.Fatalf("Expected one entry, got %v", listing)
+	}
+	for _, id := range listing {
+		if id == "" {
+			t.Error("The entry wasn't given an id")
+		}
+		read, err := driver.Read(id)
+		if err != nil {
+			t.Fatalf("Failed to read entry because %s", err)
+		}
+		if read.Id != id || read.Body != "No id" {
+			t.Errorf("Read the wrong entry %#v", read)
+		}
+	}
+}
+
+func testDefaultDate(t *testing.T, driver ejrnl.Driver) {
+	before := time.Now().Add(-time.Second)
+	if err := driver.Write(ejrnl.Entry{Id: "no-date", Body: "No date"}); err != nil {
+		t.Fatalf("Failed to write entry because %s", err)
+	}
+	after := time.Now().Add(time.Second)
+
+	read, err := driver.Read("no-date")
+	if err != nil {
+		t.Fatalf("Failed to read entry because %s", err)
+	}
+	if read.Date == nil || read.Date.Before(before) || read.Date.After(after) {
+		t.Errorf("The entry wasn't dated when it was written, got %v", read.Date)
+	}
+}
+
+func testMissingEntry(t *testing.T, driver ejrnl.Driver) {
+	if _, err := driver.Read("missing"); err == nil {
+		t.Error("Reading an entry that doesn't exist didn't fail")
+	}
+}
+
+func testListing(t *testing.T, driver ejrnl.Driver) {
+	expected := map[string]time.Time{}
+	for i, year := range []int{2016, 2014, 2015} {
+		date := time.Date(year, 6, 1, 12, 0, 0, 0, time.UTC)
+		id := fmt.Sprintf("%d", i)
+		expected[id] = date
+		if err := driver.Write(ejrnl.Entry{Id: id, Date: &date}); err != nil {
+			t.Fatalf("Failed to write entry because %s", err)
+		}
+	}
+
+	listing, err := driver.List()
+	if err != nil {
+		t.Fatalf("Failed to list entries because %s", err)
+	}
+	if len(listing) != len(expected) {
+		t.Fatalf("Expected %d entries, got %v", len(expected), listing)
+	}
+	dates := []time.Time{}
+	for date, id := range listing {
+		if !date.Equal(expected[id]) {
+			t.Errorf("%s was listed at %s, expected %s", id, date, expected[id])
+		}
+		dates = append(dates, date)
+	}
+	sort.Slice(dates, func(i, j int) bool { return dates[i].Before(dates[j]) })
+	order := []string{}
+	for _, date := range dates {
+		order = append(order, listing[date])
+	}
+	if fmt.Sprint(order) != "[1 2 0]" {
+		t.Errorf("Entries sorted by date were in the wrong order %v", order)
+	}
+}
+
+func testConcurrency(t *testing.T, driver ejrnl.Driver) {
+	const writers = 16
+	var wait sync.WaitGroup
+	errs := make(chan error, writers*2)
+	for i := 0; i < writers; i++ {
+		wait.Add(1)
+		go func(i int) {
+			defer wait.Done()
+			date := time.Date(2016, 1, 1+i, 0, 0, 0, 0, time.UTC)
+			id := fmt.Sprintf("%d", i)
+			if err := driver.Write(ejrnl.Entry{Id: id, Date: &date, Body: id}); err != nil {
+				errs <- err
+				return
+			}
+			if _, err := driver.List(); err != nil {
+				errs <- err
+			}
+		}(i)
+	}
+	wait.Wait()
+	close(errs)
+	for err := range errs {
+		t.Errorf("Concurrent operation failed because %s", err)
+	}
+
+	listing, err := driver.List()
+	if err != nil {
+		t.Fatalf("Failed to list entries because %s", err)
+	}
+	if len(listing) != writers {
+		t.Errorf("Expected %d entries after concurrent writes, got %d", writers, len(listing))
+	}
+	for _, id := range listing {
+		read, err := driver.Read(id)
+		if err != nil || read.Body != id {
+			t.Errorf("Failed to read %s back, got %#v, %v", id, read, err)
+		}
+	}
+}
+
+func testMetadata(t *testing.T, driver ejrnl.Driver) {
+	date := time.Date(2016, 12, 25, 9, 32, 58, 0, time.FixedZone("JST", 9*60*60))
+	entry := ejrnl.Entry{
+		Id:       "metadata",
+		Date:     &date,
+		Zone:     "Asia/Tokyo",
+		Body:     "This is the body",
+		Title:    "A title",
+		Location: &ejrnl.Location{Latitude: 51.5, Longitude: -0.12, Name: "London"},
+		Mood:     "happy",
+		Fields:   map[string]string{"weather": "rain"},
+	}
+	if err := driver.Write(entry); err != nil {
+		t.Fatalf("Failed to write entry because %s", err)
+	}
+	read, err := driver.Read(entry.Id)
+	if err != nil {
+		t.Fatalf("Failed to read entry because %s", err)
+	}
+	if !equal(entry, read) {
+		t.Errorf("Entries didn't match\ngot:      %#v\nexpected: %#v", read, entry)
+	}
+	if _, offset := read.Date.Zone(); offset != 9*60*60 {
+		t.Errorf("Expected the entry to keep the offset it was written with, got %s", read.Date)
+	}
+}
+
+func testSummaries(t *testing.T, driver ejrnl.Driver) {
+	summarizer, ok := driver.(ejrnl.Summarizer)
+	if !ok {
+		t.Skip("The driver doesn't implement Summarizer")
+	}
+	date := time.Date(2016, 12, 24, 0, 32, 58, 0, time.UTC)
+	if err := driver.Write(ejrnl.Entry{Id: "titled", Date: &date, Title: "A title"}); err != nil {
+		t.Fatalf("Failed to write entry because %s", err)
+	}
+	later := date.Add(time.Hour)
+	if err := driver.Write(ejrnl.Entry{Id: "untitled", Date: &later, Body: "See [[titled]] and [[2016-12-24]]"}); err != nil {
+		t.Fatalf("Failed to write entry because %s", err)
+	}
+
+	summaries, err := summarizer.Summaries(context.Background())
+	if err != nil {
+		t.Fatalf("Failed to summarize the journal because %s", err)
+	}
+	titles := make(map[string]string)
+	links := make(map[string][]string)
+	for _, summary := range summaries {
+		titles[summary.Id] = summary.Title
+		links[summary.Id] = summary.Links
+		if _, offset := summary.Date.Zone(); offset != 0 || !summary.Date.Equal(date) && !summary.Date.Equal(later) {
+			t.Errorf("Expected the summary's date in the zone it was written in, got %s", summary.Date)
+		}
+	}
+	if len(titles) != 2 || titles["titled"] != "A title" || titles["untitled"] != "" {
+		t.Errorf("Incorrect summaries %v", summaries)
+	}
+	if len(links["titled"]) != 0 || !reflect.DeepEqual(links["untitled"], []string{"titled", "2016-12-24"}) {
+		t.Errorf("Incorrect links %v", links)
+	}
+}
+
+func trashedIds(t *testing.T, trash ejrnl.Trash) []string {
+	trashed, err := trash.Trashed(context.Background())
+	if err != nil {
+		t.Fatalf("Failed to list the trash because %s", err)
+	}
+	ids := []string{}
+	for _, entry := range trashed {
+		ids = append(ids, entry.Id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func testTrash(t *testing.T, driver ejrnl.Driver) {
+	trash, ok := driver.(ejrnl.Trash)
+	if !ok {
+		t.Skip("The driver doesn't implement Trash")
+	}
+	ctx := context.Background()
+	date := time.Date(2016, 12, 24, 0, 32, 58, 0, time.UTC)
+	for _, id := range []string{"a", "b"} {
+		if err := driver.Write(ejrnl.Entry{Id: id, Date: &date, Body: id}); err != nil {
+			t.Fatalf("Failed to write entry because %s", err)
+		}
+		date = date.Add(time.Hour)
+	}
+
+	start := time.Now()
+	if err := trash.Delete(ctx, "a"); err != nil {
+		t.Fatalf("Failed to delete the entry because %s", err)
+	}
+	if err := trash.Delete(ctx, "a"); err == nil {
+		t.Error("Expected an error when an entry is deleted twice")
+	}
+	listing, err := driver.List()
+	if err != nil || len(listing) != 1 {
+		t.Errorf("Expected the deleted entry to be hidden, got %v %v", listing, err)
+	}
+	if _, err = driver.Read("a"); err == nil {
+		t.Error("Expected the deleted entry not to be readable")
+	}
+	trashed, err := trash.Trashed(ctx)
+	if err != nil || len(trashed) != 1 || trashed[0].Id != "a" || trashed[0].Deleted.Before(start.Add(-time.Second)) {
+		t.Fatalf("Incorrect trash %v %v", trashed, err)
+	}
+
+	if err = trash.Restore(ctx, "a"); err != nil {
+		t.Fatalf("Failed to restore the entry because %s", err)
+	}
+	if entry, err := driver.Read("a"); err != nil || entry.Body != "a" {
+		t.Errorf("Expected the restored entry, got %v %v", entry, err)
+	}
+	if ids := trashedIds(t, trash); len(ids) != 0 {
+		t.Errorf("Expected the trash to be empty, got %v", ids)
+	}
+	if err = trash.Restore(ctx, "a"); err == nil {
+		t.Error("Expected an error when an entry that isn't in the trash is restored")
+	}
+
+	// Writing a deleted entry takes it out of the trash
+	if err = trash.Delete(ctx, "a"); err != nil {
+		t.Fatalf("Failed to delete the entry because %s", err)
+	}
+	if err = driver.Write(ejrnl.Entry{Id: "a", Date: &date, Body: "again"}); err != nil {
+		t.Fatalf("Failed to write entry because %s", err)
+	}
+	if ids := trashedIds(t, trash); len(ids) != 0 {
+		t.Errorf("Expected the written entry to leave the trash, got %v", ids)
+	}
+
+	if err = trash.Delete(ctx, "b"); err != nil {
+		t.Fatalf("Failed to delete the entry because %s", err)
+	}
+	if purged, err := trash.Purge(ctx, start.Add(-time.Hour)); err != nil || purged != 0 {
+		t.Errorf("Expected nothing to be purged, got %d %v", purged, err)
+	}
+	if purged, err := trash.Purge(ctx, time.Now().Add(time.Second)); err != nil || purged != 1 {
+		t.Errorf("Expected the deleted entry to be purged, got %d %v", purged, err)
+	}
+	if ids := trashedIds(t, trash); len(ids) != 0 {
+		t.Errorf("Expected the trash to be empty, got %v", ids)
+	}
+	if err = trash.Restore(ctx, "b"); err == nil {
+		t.Error("Expected a purged entry not to be restorable")
+	}
+	listing, err = driver.List()
+	if err != nil || len(listing) != 1 {
+		t.Errorf("Expected only the remaining entry to be listed, got %v %v", listing, err)
+	}
+}
@@ -0,0 +1,13 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd
+// +build !linux,!darwin,!freebsd,!netbsd,!openbsd
+
+package storage
+
+import (
+	"os"
+)
+
+// flock isn't supported so, only the process's own lock protects the journal.
+func flock(file *os.File, exclusive bool) error {
+	return nil
+}
@@ -0,0 +1,234 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// In git mode, the journal's directory is a git repository and every change is committed. The
+// commits only contain ciphertext and they all have the same author and message so, the history
+// only reveals when the journal changed and, with HideActivity, not even that. The key slots are
+// never committed because every wrapped key in the history could be unwrapped with the password it
+// was wrapped with, even after the journal is destroyed.
+const (
+	gitBranch  = "main"
+	gitMessage = "ejrnl"
+	gitAuthor  = "ejrnl"
+	gitEmail   = "ejrnl@localhost"
+)
+
+// DraftDirectory is the directory in the journal where drafts that couldn't be saved are kept. It's
+// never committed to git.
+const DraftDirectory = "drafts"
+
+// Change is a commit in the journal's history.
+type Change struct {
+	Commit string
+	Date   time.Time
+	// Ids are the entries that were changed. Entries that are no longer in the index aren't included
+	Ids []string
+}
+
+// git runs git in the journal's directory and returns its output. The user's git config can't
+// change the author or sign the commits.
+func (d *Driver) git(args ...string) ([]byte, error) {
+	command := args[0]
+	args = append([]string{"-c", "commit.gpgsign=false", "-c", "core.autocrlf=false"}, args...)
+	cmd := exec.Command("git", args...)
+	cmd.Dir = d.directory
+	cmd.Env = append(os.Environ(),
+		"GIT_AUTHOR_NAME="+gitAuthor, "GIT_AUTHOR_EMAIL="+gitEmail,
+		"GIT_COMMITTER_NAME="+gitAuthor, "GIT_COMMITTER_EMAIL="+gitEmail,
+	)
+	if d.hideActivity {
+		date := normalizedTime.Format(time.RFC3339)
+		cmd.Env = append(cmd.Env, "GIT_AUTHOR_DATE="+date, "GIT_COMMITTER_DATE="+date)
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return out, fmt.Errorf("Failed to run git %s because %s %s", command, err, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
+
+// gitInit turns the journal's directory into a repository if it isn't one already.
+func (d *Driver) gitInit() error {
+	if _, err := os.Stat(fmt.Sprintf("%s/.git", d.directory)); err == nil {
+		return nil
+	}
+	if _, err := d.git("init", "-q"); err != nil {
+		return err
+	}
+	_, err := d.git("symbolic-ref", "HEAD", "refs/heads/"+gitBranch)
+	return err
+}
+
+// commit commits every change in the journal's directory. It does nothing if git mode isn't
+// enabled or nothing changed. Journals that enable git mode later get a repository on their next
+// change. The key slots are never committed so that destroying the journal can't be undone from its
+// history.
+func (d *Driver) commit() error {
+	if !d.useGit {
+		return nil
+	}
+	if err := d.gitInit(); err != nil {
+		return err
+	}
+	if _, err := d.git("add", "-A", "--", ".", ":!"+lockFile, ":!"+DraftDirectory, ":!"+keySlotsFile); err != nil {
+		return err
+	}
+	status, err := d.git("status", "--porcelain", "--untracked-files=no")
+	if err != nil || len(status) == 0 {
+		return err
+	}
+	_, err = d.git("commit", "-q", "-m", gitMessage)
+	return err
+}
+
+// Log returns the journal's history, most recent first.
+func (d *Driver) Log() ([]Change, error) {
+	if !d.useGit {
+		return nil, errors.New("The journal isn't stored in git. Set git in the config to enable it")
+	}
+	unlock, err := d.lockJournal(false)
+	if err != nil {
+		return nil, err
+	}
+	index, err := d.readIndex()
+	unlock()
+	if err != nil {
+		return nil, err
+	}
+	files := make(map[string][]string)
+	for id, entry := range index {
+		files[entry.File] = append(files[entry.File], id)
+	}
+
+	out, err := d.git("log", "--name-only", "--format=commit %H %aI")
+	if err != nil {
+		return nil, err
+	}
+	changes := []Change{}
+	for _, line := range strings.Split(string(out), "\n") {
+		if strings.HasPrefix(line, "commit ") {
+			fields := strings.Fields(line)
+			date, err := time.Parse(time.RFC3339, fields[2])
+			if err != nil {
+				return nil, err
+			}
+			changes = append(changes, Change{Commit: fields[1], Date: date})
+		} else if ids, ok := files[line]; ok && len(changes) > 0 {
+			changes[len(changes)-1].Ids = append(changes[len(changes)-1].Ids, ids...)
+		}
+	}
+	return changes, nil
+}
+
+// Push pushes the journal's history to the configured remote.
+func (d *Driver) Push() error {
+	if d.gitRemote == "" {
+		return errors.New("The journal doesn't have a git remote. Set gitremote in the config")
+	}
+	_, err := d.git("push", "-q", d.gitRemote, "HEAD:refs/heads/"+gitBranch)
+	return err
+}
+
+// Pull merges the remote's history into the journal. When both sides changed the same entry, the
+// local version is kept. The indexes are merged so that entries added on either side are kept and
+// entries that were only changed remotely are indexed again.
+func (d *Driver) Pull() error {
+	if d.gitRemote == "" {
+		return errors.New("The journal doesn't have a git remote. Set gitremote in the config")
+	}
+	unlock, err := d.lockJournal(true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if _, err := d.git("fetch", "-q", d.gitRemote, gitBranch); err != nil {
+		return err
+	}
+	if err = d.commit(); err != nil {
+		return err
+	}
+	head, err := d.git("rev-parse", "HEAD")
+	if err != nil {
+		return err
+	}
+	if _, err := d.git("merge", "-q", "--no-edit", "--allow-unrelated-histories", "-X", "ours", "FETCH_HEAD"); err != nil {
+		return err
+	}
+	if err = d.bumpGeneration(); err != nil {
+		return err
+	}
+
+	snapshot, err := d.git("show", "FETCH_HEAD:"+indexFile)
+	if err != nil {
+		return err
+	}
+	var log []byte
+	if files, err := d.git("ls-tree", "--name-only", "FETCH_HEAD", indexLogFile); err == nil && len(files) > 0 {
+		if log, err = d.git("show", "FETCH_HEAD:"+indexLogFile); err != nil {
+			return err
+		}
+	}
+	theirs, err := d.replayIndex(snapshot, log)
+	if err != nil {
+		return fmt.Errorf("Failed to read the remote index because %s", err)
+	}
+	ours, err := d.readIndex()
+	if err != nil {
+		return err
+	}
+	merged := make(index)
+	for id, entry := range ours {
+		merged[id] = entry
+	}
+	changed := false
+	for id, entry := range theirs {
+		if _, ok := ours[id]; !ok {
+			merged[id] = entry
+			changed = true
+		}
+	}
+
+	// Entries that were only changed remotely were merged into their files so, their index entries
+	// are rebuilt from the files
+	out, err := d.git("diff", "--name-only", strings.TrimSpace(string(head)), "HEAD")
+	if err != nil {
+		return err
+	}
+	files := make(map[string]bool)
+	for _, name := range strings.Split(string(out), "\n") {
+		files[name] = name != ""
+	}
+	for id, entry := range merged {
+		file := d.filename(id)
+		if entry.trashed() || !files[file] {
+			continue
+		}
+		read, err := d.readFile(file)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return fmt.Errorf("Failed to reindex %s after merging because %s", id, err)
+		}
+		merged[id] = newIndexEntry(read, file)
+		changed = true
+	}
+	if !changed {
+		return nil
+	}
+	if err = d.writeIndex(merged); err != nil {
+		return err
+	}
+	return d.commit()
+}
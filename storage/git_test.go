@@ -0,0 +1,165 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/btobolaski/ejrnl"
+)
+
+func gitConfig(t *testing.T, remote string) ejrnl.Config {
+	directory, err := ioutil.TempDir("", "ejrnl-git")
+	if err != nil {
+		t.Fatalf("Failed to create a directory because %s", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(directory) })
+	return ejrnl.Config{StorageDirectory: directory, Salt: makeSalt(32), Pow: 12, Git: true, GitRemote: remote}
+}
+
+func TestGitCommits(t *testing.T) {
+	conf := gitConfig(t, "")
+	d := openInit(t, conf).(*Driver)
+	defer d.Close()
+
+	for _, id := range []string{"1", "2"} {
+		if err := d.Write(ejrnl.Entry{Id: id, Body: "a secret " + id}); err != nil {
+			t.Fatalf("Failed to write entry because %s", err)
+		}
+	}
+
+	changes, err := d.Log()
+	if err != nil {
+		t.Fatalf("Failed to read the history because %s", err)
+	}
+	if len(changes) != 3 {
+		t.Fatalf("Expected a commit for init and each write, got %v", changes)
+	}
+	if len(changes[0].Ids) != 1 || changes[0].Ids[0] != "2" {
+		t.Errorf("Expected the latest commit to change entry 2, got %v", changes[0].Ids)
+	}
+
+	out, err := d.git("log", "-p", "--text", "--format=%an %ae %s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(out), "secret") {
+		t.Error("The history contains plaintext")
+	}
+	if files, err := d.git("log", "--all", "--format=%H", "--", keySlotsFile); err != nil || len(files) != 0 {
+		t.Errorf("The history contains the key slots, %s %v", files, err)
+	}
+	for _, line := range strings.Split(string(out), "\n") {
+		if strings.HasPrefix(line, gitAuthor+" ") && line != fmt.Sprintf("%s %s %s", gitAuthor, gitEmail, gitMessage) {
+			t.Errorf("The commit isn't opaque, %s", line)
+		}
+	}
+}
+
+func TestGitPushPull(t *testing.T) {
+	remote, err := ioutil.TempDir("", "ejrnl-remote")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(remote)
+	if out, err := exec.Command("git", "init", "-q", "--bare", remote).CombinedOutput(); err != nil {
+		t.Fatalf("Failed to create the remote because %s %s", err, out)
+	}
+
+	conf := gitConfig(t, remote)
+	first := openInit(t, conf).(*Driver)
+	defer first.Close()
+	if err = first.Write(ejrnl.Entry{Id: "shared", Body: "shared"}); err != nil {
+		t.Fatalf("Failed to write entry because %s", err)
+	}
+	if err = first.Push(); err != nil {
+		t.Fatalf("Failed to push because %s", err)
+	}
+
+	other := gitConfig(t, remote)
+	other.Salt = conf.Salt
+	if out, err := exec.Command("git", "clone", "-q", "-b", gitBranch, remote, other.StorageDirectory).CombinedOutput(); err != nil {
+		t.Fatalf("Failed to clone the journal because %s %s", err, out)
+	}
+	if _, err = NewDriverWithPassword(other, secret("password")); err == nil || !strings.Contains(err.Error(), keySlotsFile) {
+		t.Errorf("Expected opening the clone to report the missing key slots, got %v", err)
+	}
+	// The key slots aren't in the history so, they're copied the way a user would
+	slots, err := ioutil.ReadFile(fmt.Sprintf("%s/%s", conf.StorageDirectory, keySlotsFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(fmt.Sprintf("%s/%s", other.StorageDirectory, keySlotsFile), slots, 0600); err != nil {
+		t.Fatal(err)
+	}
+	second, err := NewDriverWithPassword(other, secret("password"))
+	if err != nil {
+		t.Fatalf("Failed to open the cloned journal because %s", err)
+	}
+	defer second.Close()
+
+	// Both copies change before either of them pulls
+	if err = first.Write(ejrnl.Entry{Id: "first", Body: "first"}); err != nil {
+		t.Fatalf("Failed to write entry because %s", err)
+	}
+	if err = second.Write(ejrnl.Entry{Id: "second", Body: "second"}); err != nil {
+		t.Fatalf("Failed to write entry because %s", err)
+	}
+	if err = first.Push(); err != nil {
+		t.Fatalf("Failed to push because %s", err)
+	}
+	if err = second.Pull(); err != nil {
+		t.Fatalf("Failed to pull because %s", err)
+	}
+	if err = second.Push(); err != nil {
+		t.Fatalf("Failed to push the merged journal because %s", err)
+	}
+	if err = first.Pull(); err != nil {
+		t.Fatalf("Failed to pull because %s", err)
+	}
+
+	for _, d := range []*Driver{first, second} {
+		listing, err := d.List()
+		if err != nil || len(listing) != 3 {
+			t.Errorf("Expected both copies to have every entry, got %v %v", listing, err)
+		}
+		for _, id := range listing {
+			if entry, err := d.Read(id); err != nil || entry.Body != id {
+				t.Errorf("Failed to read %s after merging, got %#v %v", id, entry, err)
+			}
+		}
+	}
+	// An entry that was only edited remotely is indexed again
+	date := time.Date(2016, 12, 24, 0, 32, 58, 0, time.UTC)
+	if err = first.Write(ejrnl.Entry{Id: "shared", Date: &date, Title: "edited", Body: "shared"}); err != nil {
+		t.Fatalf("Failed to write entry because %s", err)
+	}
+	if err = first.Push(); err != nil {
+		t.Fatalf("Failed to push because %s", err)
+	}
+	// The local index changed too so, the merge keeps it
+	if err = second.Write(ejrnl.Entry{Id: "local", Body: "local"}); err != nil {
+		t.Fatalf("Failed to write entry because %s", err)
+	}
+	if err = second.Pull(); err != nil {
+		t.Fatalf("Failed to pull because %s", err)
+	}
+	listing, err := second.List()
+	if err != nil || listing[date.Local()] != "shared" {
+		t.Errorf("Expected the remote edit's date to be listed, got %v %v", listing, err)
+	}
+	summaries, err := second.Summaries(context.Background())
+	for _, summary := range summaries {
+		if summary.Id == "shared" && summary.Title != "edited" {
+			t.Errorf("Expected the remote edit's title to be indexed, got %#v", summary)
+		}
+	}
+	if err != nil || len(summaries) != 4 {
+		t.Errorf("Expected every entry to be summarized, got %v %v", summaries, err)
+	}
+}
@@ -0,0 +1,416 @@
+package storage
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/btobolaski/ejrnl"
+	"github.com/btobolaski/ejrnl/crypto"
+)
+
+// indexEntry is what the index stores about each entry.
+type indexEntry struct {
+	Date time.Time
+	// File is the name of the file the entry is stored in
+	File string
+	// Zone is the name of the zone the entry was written in
+	Zone string `json:",omitempty"`
+	// Title is kept in the index so that the journal can be listed with titles
+	Title string `json:",omitempty"`
+	// Links are the targets of the entry's links so that backlinks can be found without reading
+	// every entry
+	Links []string `json:",omitempty"`
+	// Offset and Length are where the entry is in File if it's a pack
+	Offset int64 `json:",omitempty"`
+	Length int64 `json:",omitempty"`
+	// Deleted is when the entry was moved to the trash
+	Deleted *time.Time `json:",omitempty"`
+}
+
+// index maps entry ids to their index entries. Older journals stored the index as a map of dates to
+// ids and the entries in files named after their ids.
+type index map[string]indexEntry
+
+// decodeIndex decodes both the current and the original index formats.
+func decodeIndex(plaintext []byte) (index, error) {
+	raw := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(plaintext, &raw); err != nil {
+		return index{}, err
+	}
+
+	decoded := make(index)
+	for key, value := range raw {
+		if len(value) > 0 && value[0] == '"' {
+			// The original format, the key is the date and the value is the id
+			var id string
+			if err := json.Unmarshal(value, &id); err != nil {
+				return index{}, err
+			}
+			date, err := time.Parse(time.RFC3339Nano, key)
+			if err != nil {
+				return index{}, err
+			}
+			decoded[id] = indexEntry{Date: date, File: legacyFilename(id)}
+			continue
+		}
+
+		entry := indexEntry{}
+		if err := json.Unmarshal(value, &entry); err != nil {
+			return index{}, err
+		}
+		decoded[key] = entry
+	}
+	return decoded, nil
+}
+
+// The index is stored as a snapshot, index.cpt, and a log of the changes since the snapshot,
+// index.log. Writes only append to the log so, their cost doesn't depend on the size of the journal.
+// Once the log is larger than the snapshot, it's compacted into a new snapshot. Each record in the
+// log is the length of the encrypted record, as a big endian uint32, followed by the record.
+const (
+	indexFile    = "index.cpt"
+	indexLogFile = "index.log"
+	// compactMinimum is the smallest log that is compacted
+	compactMinimum = 64 * 1024
+)
+
+// indexRecord is a change to the index.
+type indexRecord struct {
+	Set    index    `json:",omitempty"`
+	Remove []string `json:",omitempty"`
+}
+
+func (r indexRecord) apply(i index) {
+	for id, entry := range r.Set {
+		i[id] = entry
+	}
+	for _, id := range r.Remove {
+		delete(i, id)
+	}
+}
+
+// completeRecords returns the length of the log's complete records. Anything after them is a
+// partially written record from a write that failed.
+func completeRecords(log []byte) int {
+	end := 0
+	for len(log)-end >= 4 {
+		length := int(binary.BigEndian.Uint32(log[end:]))
+		if length > len(log)-end-4 {
+			break
+		}
+		end += 4 + length
+	}
+	return end
+}
+
+// replayIndex decrypts a snapshot and applies the records in the log to it. A partially written
+// record at the end of the log is ignored because the write that it was part of failed.
+func (d *Driver) replayIndex(snapshot, log []byte) (index, error) {
+	plaintext, err := d.decrypt(snapshot)
+	if err != nil {
+		return index{}, err
+	}
+	current, err := decodeIndex(plaintext)
+	if err != nil {
+		return index{}, err
+	}
+
+	log = log[:completeRecords(log)]
+	for len(log) >= 4 {
+		length := int(binary.BigEndian.Uint32(log))
+		plaintext, err = d.decrypt(log[4 : 4+length])
+		if err != nil {
+			return index{}, fmt.Errorf("Failed to decrypt the index log because %s", err)
+		}
+		record := indexRecord{}
+		if err = json.Unmarshal(plaintext, &record); err != nil {
+			return index{}, err
+		}
+		record.apply(current)
+		log = log[4+length:]
+	}
+	return current, nil
+}
+
+// indexCache is the decoded index along with the state of the files that it was read from.
+type indexCache struct {
+	lock  sync.Mutex
+	index index
+	state indexState
+}
+
+// fileState is what's used to detect that a file changed.
+type fileState struct {
+	size    int64
+	modTime int64
+}
+
+// indexState identifies a version of the index. The generation is bumped by every change that this
+// package makes. The files' sizes and modification times catch changes made in other ways, such as
+// by git, but modification times aren't reliable on their own because hideactivity resets them.
+type indexState struct {
+	generation uint64
+	snapshot   fileState
+	log        fileState
+}
+
+func (d *Driver) statFile(name string) (fileState, error) {
+	info, err := os.Stat(fmt.Sprintf("%s/%s", d.directory, name))
+	if os.IsNotExist(err) {
+		return fileState{size: -1}, nil
+	} else if err != nil {
+		return fileState{}, err
+	}
+	return fileState{size: info.Size(), modTime: info.ModTime().UnixNano()}, nil
+}
+
+// generation returns the generation stored in the lock file. Journals that have never been changed
+// since it was added are generation 0.
+func (d *Driver) generation() uint64 {
+	data, err := ioutil.ReadFile(fmt.Sprintf("%s/%s", d.directory, lockFile))
+	if err != nil || len(data) < 8 {
+		return 0
+	}
+	return binary.BigEndian.Uint64(data)
+}
+
+// bumpGeneration marks the index as changed so that other processes reread it. The caller must hold
+// an exclusive journal lock.
+func (d *Driver) bumpGeneration() error {
+	data := make([]byte, 8)
+	binary.BigEndian.PutUint64(data, d.generation()+1)
+	file, err := os.OpenFile(fmt.Sprintf("%s/%s", d.directory, lockFile), os.O_WRONLY|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = file.WriteAt(data, 0)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if d.hideActivity {
+		d.normalizeTime(lockFile)
+	}
+	return err
+}
+
+func (d *Driver) indexState() (indexState, error) {
+	snapshot, err := d.statFile(indexFile)
+	if err != nil {
+		return indexState{}, err
+	}
+	log, err := d.statFile(indexLogFile)
+	if err != nil {
+		return indexState{}, err
+	}
+	return indexState{generation: d.generation(), snapshot: snapshot, log: log}, nil
+}
+
+// readIndex returns the index. It's only read from the disk if it changed since it was last read.
+// The returned index is shared with later callers so, it must not be modified or used after the
+// journal lock is released. The caller must hold at least a shared journal lock.
+func (d *Driver) readIndex() (index, error) {
+	d.cache.lock.Lock()
+	defer d.cache.lock.Unlock()
+	state, err := d.indexState()
+	if err != nil {
+		return index{}, err
+	}
+	if d.cache.index != nil && state == d.cache.state {
+		return d.cache.index, nil
+	}
+
+	snapshot, err := ioutil.ReadFile(fmt.Sprintf("%s/%s", d.directory, indexFile))
+	if err != nil {
+		return index{}, err
+	}
+	log, err := ioutil.ReadFile(fmt.Sprintf("%s/%s", d.directory, indexLogFile))
+	if err != nil && !os.IsNotExist(err) {
+		return index{}, err
+	}
+	current, err := d.replayIndex(snapshot, log)
+	if err != nil {
+		return index{}, err
+	}
+	d.cache.index, d.cache.state = current, state
+	return current, nil
+}
+
+// updateCache records the index's new state after the driver changed it. If previous is set, the
+// change is only applied to the cached index if it was current beforehand. Otherwise the index is
+// reread next time.
+func (d *Driver) updateCache(previous *indexState, change func(index) index) {
+	d.cache.lock.Lock()
+	defer d.cache.lock.Unlock()
+	state, err := d.indexState()
+	if err != nil || (previous != nil && (d.cache.index == nil || *previous != d.cache.state)) {
+		d.cache.index = nil
+		return
+	}
+	d.cache.index, d.cache.state = change(d.cache.index), state
+}
+
+// writeIndex writes a new snapshot of the index and removes the log. The caller must hold an
+// exclusive journal lock.
+func (d *Driver) writeIndex(written index) error {
+	plaintext, err := json.Marshal(written)
+	if err != nil {
+		return err
+	}
+
+	cyphertext, err := d.compression.CompressAndEncrypt(plaintext, d.key.Bytes())
+	if err != nil {
+		return err
+	}
+
+	if err = d.writeFile(indexFile, cyphertext); err != nil {
+		return err
+	}
+	err = d.remove(indexLogFile)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err = d.bumpGeneration(); err != nil {
+		return err
+	}
+	d.updateCache(nil, func(index) index { return written })
+	return nil
+}
+
+// appendIndex appends a record to the index's log and compacts it if it has grown larger than the
+// snapshot. The caller must hold an exclusive journal lock.
+func (d *Driver) appendIndex(record indexRecord) error {
+	plaintext, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	cyphertext, err := d.compression.CompressAndEncrypt(plaintext, d.key.Bytes())
+	if err != nil {
+		return err
+	}
+	framed := make([]byte, 4, 4+len(cyphertext))
+	binary.BigEndian.PutUint32(framed, uint32(len(cyphertext)))
+	framed = append(framed, cyphertext...)
+
+	previous, err := d.indexState()
+	if err != nil {
+		return err
+	}
+	path := fmt.Sprintf("%s/%s", d.directory, indexLogFile)
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	existing, err := ioutil.ReadAll(file)
+	if err != nil {
+		file.Close()
+		return err
+	}
+	// A partial record left by a failed write is dropped so that replay finds this one
+	end := int64(completeRecords(existing))
+	if err = file.Truncate(end); err != nil {
+		file.Close()
+		return err
+	}
+	if _, err = file.WriteAt(framed, end); err != nil {
+		file.Close()
+		return err
+	}
+	if err = file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	info, err := file.Stat()
+	file.Close()
+	if err != nil {
+		return err
+	}
+	if d.hideActivity {
+		d.normalizeTime(indexLogFile)
+	}
+	if err = d.bumpGeneration(); err != nil {
+		return err
+	}
+	d.updateCache(&previous, func(current index) index {
+		record.apply(current)
+		return current
+	})
+
+	snapshot, err := os.Stat(fmt.Sprintf("%s/%s", d.directory, indexFile))
+	if err != nil {
+		return err
+	}
+	if info.Size() < compactMinimum || info.Size() < snapshot.Size() {
+		return nil
+	}
+	current, err := d.readIndex()
+	if err != nil {
+		return err
+	}
+	return d.writeIndex(current)
+}
+
+// newIndexEntry returns the index entry for an entry stored in file.
+func newIndexEntry(entry ejrnl.Entry, file string) indexEntry {
+	return indexEntry{Date: *entry.Date, File: file, Zone: entry.Zone, Title: entry.Title, Links: ejrnl.Links(entry.Body)}
+}
+
+func (e indexEntry) summary(id string) ejrnl.Summary {
+	return ejrnl.Summary{Id: id, Date: e.Date, Zone: e.Zone, Title: e.Title, Links: e.Links}
+}
+
+// summaries returns the summaries of the index's entries that aren't in the trash.
+func (i index) summaries() []ejrnl.Summary {
+	summaries := make([]ejrnl.Summary, 0, len(i))
+	for id, entry := range i {
+		if !entry.trashed() {
+			summaries = append(summaries, entry.summary(id))
+		}
+	}
+	return summaries
+}
+
+// subkey derives a key for a specific purpose from the journal's key.
+func subkey(key *crypto.Secret, purpose string) *crypto.Secret {
+	mac := hmac.New(sha256.New, key.Bytes())
+	mac.Write([]byte(purpose))
+	return crypto.SecretFromBytes(mac.Sum(nil))
+}
+
+// blind returns a keyed hash of the value which can be compared without revealing the value.
+func blind(key *crypto.Secret, value string) []byte {
+	mac := hmac.New(sha256.New, key.Bytes())
+	mac.Write([]byte(value))
+	return mac.Sum(nil)
+}
+
+// filenameKey derives the key used to name entries' files from the journal's key.
+func filenameKey(key *crypto.Secret) *crypto.Secret {
+	return subkey(key, "ejrnl filenames")
+}
+
+// filename returns the name of the file that the entry is stored in. It is a keyed hash of the id
+// so that the name doesn't reveal anything about the entry.
+func (d *Driver) filename(id string) string {
+	return fmt.Sprintf("%s.cpt", hex.EncodeToString(blind(d.fileKey, id)[:16]))
+}
+
+// legacyFilename is the name that entries were stored under before filenames were hashed.
+func legacyFilename(id string) string {
+	return fmt.Sprintf("%s.cpt", id)
+}
+
+// legacyFile returns the name that the entry would have been stored under before filenames were
+// hashed. It returns false for ids that couldn't have been stored that way.
+func legacyFile(id string) (string, bool) {
+	name := legacyFilename(id)
+	return name, name != indexFile && !strings.ContainsAny(id, "/\\")
+}
@@ -0,0 +1,214 @@
+package storage
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/btobolaski/ejrnl"
+)
+
+func TestIndexLog(t *testing.T) {
+	conf := ejrnl.Config{StorageDirectory: "./index-log-test", Salt: makeSalt(32), Pow: 12}
+	d, err := driverInit(conf)
+	defer os.RemoveAll(conf.StorageDirectory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	snapshot, _ := ioutil.ReadFile(fmt.Sprintf("%s/%s", conf.StorageDirectory, indexFile))
+
+	for i := 0; i < 3; i++ {
+		if err = d.Write(ejrnl.Entry{Id: fmt.Sprintf("%d", i)}); err != nil {
+			t.Fatalf("Failed to write entry because %s", err)
+		}
+	}
+	after, _ := ioutil.ReadFile(fmt.Sprintf("%s/%s", conf.StorageDirectory, indexFile))
+	if !bytes.Equal(snapshot, after) {
+		t.Error("Writing an entry rewrote the snapshot instead of appending to the log")
+	}
+
+	// A write that failed part way through leaves a partial record at the end of the log
+	log, err := os.OpenFile(fmt.Sprintf("%s/%s", conf.StorageDirectory, indexLogFile), os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatalf("Failed to open the log because %s", err)
+	}
+	log.Write([]byte{0, 0, 1, 0, 1, 2, 3})
+	log.Close()
+
+	d, err = NewDriver(conf, "password")
+	if err != nil {
+		t.Fatalf("Failed to reopen the journal because %s", err)
+	}
+	listing, err := d.List()
+	if err != nil || len(listing) != 3 {
+		t.Errorf("Expected the log to be replayed, got %v %v", listing, err)
+	}
+
+	// Records appended after the partial record are still replayed once the journal is reopened
+	for i := 3; i < 6; i++ {
+		if err = d.Write(ejrnl.Entry{Id: fmt.Sprintf("%d", i)}); err != nil {
+			t.Fatalf("Failed to write entry because %s", err)
+		}
+	}
+	d, err = NewDriver(conf, "password")
+	if err != nil {
+		t.Fatalf("Failed to reopen the journal because %s", err)
+	}
+	listing, err = d.List()
+	if err != nil || len(listing) != 6 {
+		t.Errorf("Expected the records after the partial record to be replayed, got %v %v", listing, err)
+	}
+}
+
+func TestIndexCompaction(t *testing.T) {
+	conf := ejrnl.Config{StorageDirectory: "./index-compaction-test", Salt: makeSalt(32), Pow: 12}
+	d, err := driverInit(conf)
+	defer os.RemoveAll(conf.StorageDirectory)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logPath := fmt.Sprintf("%s/%s", conf.StorageDirectory, indexLogFile)
+	compacted := false
+	written := 0
+	for !compacted && written < 5000 {
+		if err = d.Write(ejrnl.Entry{Id: fmt.Sprintf("%d", written)}); err != nil {
+			t.Fatalf("Failed to write entry because %s", err)
+		}
+		written++
+		_, err = os.Stat(logPath)
+		compacted = os.IsNotExist(err)
+	}
+	if !compacted {
+		t.Fatal("The log was never compacted")
+	}
+	listing, err := d.List()
+	if err != nil || len(listing) != written {
+		t.Errorf("Expected %d entries after compacting, got %d %v", written, len(listing), err)
+	}
+}
+
+func TestIndexLock(t *testing.T) {
+	conf := ejrnl.Config{StorageDirectory: "./index-lock-test", Salt: makeSalt(32), Pow: 12}
+	first, err := driverInit(conf)
+	defer os.RemoveAll(conf.StorageDirectory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A second driver has its own in process lock so, only the lock file keeps them apart
+	second, err := NewDriver(conf, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wait sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wait.Add(1)
+		go func(i int) {
+			defer wait.Done()
+			d := first
+			if i%2 == 1 {
+				d = second
+			}
+			if err := d.Write(ejrnl.Entry{Id: fmt.Sprintf("%d", i)}); err != nil {
+				t.Errorf("Failed to write entry because %s", err)
+			}
+		}(i)
+	}
+	wait.Wait()
+
+	listing, err := first.List()
+	if err != nil || len(listing) != 20 {
+		t.Errorf("Expected every entry to be in the index, got %d %v", len(listing), err)
+	}
+}
+
+func TestIndexCache(t *testing.T) {
+	// Modification times don't change when activity is hidden so, only the generation reveals that
+	// the other driver changed the index.
+	conf := ejrnl.Config{StorageDirectory: "./index-cache-test", Salt: makeSalt(32), Pow: 12, HideActivity: true}
+	first, err := driverInit(conf)
+	defer os.RemoveAll(conf.StorageDirectory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := NewDriver(conf, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if _, err = first.List(); err != nil {
+			t.Fatalf("Failed to list entries because %s", err)
+		}
+		if err = second.Write(ejrnl.Entry{Id: fmt.Sprintf("%d", i)}); err != nil {
+			t.Fatalf("Failed to write entry because %s", err)
+		}
+		listing, err := first.List()
+		if err != nil || len(listing) != i+1 {
+			t.Errorf("Expected the cached index to pick up the other driver's write, got %v %v", listing, err)
+		}
+	}
+
+	// Writes through the same driver update the cache without rereading the index
+	if err = first.Write(ejrnl.Entry{Id: "3"}); err != nil {
+		t.Fatalf("Failed to write entry because %s", err)
+	}
+	cached := first.cache.index
+	listing, err := first.List()
+	if err != nil || len(listing) != 4 {
+		t.Errorf("Expected every entry to be listed, got %v %v", listing, err)
+	}
+	if fmt.Sprintf("%p", cached) != fmt.Sprintf("%p", first.cache.index) {
+		t.Error("The index was reread after the driver's own write")
+	}
+}
+
+// benchmarkIndex creates a journal whose index has the specified number of entries. The entries
+// themselves aren't written because listing only reads the index.
+func benchmarkIndex(b *testing.B, entries int) *Driver {
+	conf := ejrnl.Config{StorageDirectory: "./index-benchmark", Salt: makeSalt(32), Pow: 12}
+	d, err := driverInit(conf)
+	b.Cleanup(func() { os.RemoveAll(conf.StorageDirectory) })
+	if err != nil {
+		b.Fatal(err)
+	}
+	large := make(index)
+	date := time.Now()
+	for i := 0; i < entries; i++ {
+		id := fmt.Sprintf("%d", i)
+		large[id] = indexEntry{Date: date.Add(time.Duration(i) * time.Second), File: d.filename(id)}
+	}
+	unlock, err := d.lockJournal(true)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer unlock()
+	if err = d.writeIndex(large); err != nil {
+		b.Fatal(err)
+	}
+	return d
+}
+
+func BenchmarkList(b *testing.B) {
+	d := benchmarkIndex(b, 50000)
+	b.Run("Cached", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := d.List(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("Uncached", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			d.cache.index = nil
+			if _, err := d.List(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
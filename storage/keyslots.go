@@ -0,0 +1,308 @@
+package storage
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/btobolaski/ejrnl"
+	"github.com/btobolaski/ejrnl/crypto"
+)
+
+// Journals created before key slots existed use the key derived from the password to encrypt
+// everything. Newer journals use a random data key which is stored in the key slots file, wrapped
+// with the key derived from the password. Destroying the key slots makes the rest of the journal
+// unreadable, even with the password.
+const keySlotsFile = "keyslots"
+
+// keyVersion is prepended to keys before they're wrapped. crypto.Decrypt strips leading null bytes
+// so, without it, keys that start with a null byte wouldn't survive being wrapped.
+const keyVersion = 1
+
+// ErrDerivedKey is returned when trying to destroy a journal whose key is derived from the password
+var ErrDerivedKey = errors.New("The journal's key is derived from its password so, it can't be destroyed. Use rekey to move it to a wrapped key first")
+
+// errHasEntries is returned when a new data key would be given to a journal that already has entries.
+var errHasEntries = errors.New("The journal already has entries so, it can't be given a new key. Init it with the password that it was created with to recover its index")
+
+type keySlot struct {
+	Salt string
+	Pow  uint
+	// Key is the data key encrypted with the key derived from the password
+	Key []byte
+}
+
+type keySlots struct {
+	Version int
+	Slots   []keySlot
+}
+
+// newKeySlot wraps the data key with a key derived from the password.
+func newKeySlot(dataKey, password *crypto.Secret, salt string, pow uint) (keySlot, error) {
+	decodedSalt, err := base64.StdEncoding.DecodeString(salt)
+	if err != nil {
+		return keySlot{}, fmt.Errorf("Failed to decode salt because %s", err)
+	}
+	wrappingKey, err := crypto.DeriveKey(password, decodedSalt, pow)
+	if err != nil {
+		return keySlot{}, err
+	}
+	defer wrappingKey.Close()
+
+	// crypto.Encrypt encrypts in place so, it needs its own copy of the key
+	plaintext := append([]byte{keyVersion}, dataKey.Bytes()...)
+	wrapped, err := crypto.Encrypt(plaintext, wrappingKey.Bytes())
+	crypto.Wipe(plaintext)
+	return keySlot{Salt: salt, Pow: pow, Key: wrapped}, err
+}
+
+// unwrap returns the data key if the password is correct for this slot.
+func (s keySlot) unwrap(password *crypto.Secret) (*crypto.Secret, error) {
+	salt, err := base64.StdEncoding.DecodeString(s.Salt)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decode salt because %s", err)
+	}
+	wrappingKey, err := crypto.DeriveKey(password, salt, s.Pow)
+	if err != nil {
+		return nil, err
+	}
+	defer wrappingKey.Close()
+
+	plaintext, err := crypto.Decrypt(s.Key, wrappingKey.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	defer crypto.Wipe(plaintext)
+	if len(plaintext) == 0 || plaintext[0] != keyVersion {
+		return nil, errors.New("Unsupported key slot version")
+	}
+	return crypto.SecretFromBytes(plaintext[1:]), nil
+}
+
+// unwrap tries each of the slots with the password.
+func (s keySlots) unwrap(password *crypto.Secret) (*crypto.Secret, error) {
+	err := errors.New("The journal doesn't have any key slots")
+	for _, slot := range s.Slots {
+		var key *crypto.Secret
+		key, err = slot.unwrap(password)
+		if err == nil {
+			return key, nil
+		}
+	}
+	return nil, err
+}
+
+// newDataKey creates a random key for a new journal.
+func newDataKey() (*crypto.Secret, error) {
+	key := crypto.NewSecret(16)
+	_, err := rand.Read(key.Bytes())
+	return key, err
+}
+
+// keyed is implemented by each of the backends so that they can share how journals are unlocked.
+type keyed interface {
+	ejrnl.Driver
+	// setKey gives a new journal a new data key. slot is the key slot that Init writes
+	setKey(key *crypto.Secret, slot *keySlot)
+}
+
+// keyStore is where a backend keeps its key slots.
+type keyStore interface {
+	// readKeySlots returns an error that satisfies os.IsNotExist if there aren't any key slots
+	readKeySlots() (keySlots, error)
+	writeKeySlots(keySlots) error
+	// destroyKeySlots removes the key slots as thoroughly as the backend allows
+	destroyKeySlots() error
+	// destroyIndex removes whatever the backend uses to list the journal
+	destroyIndex() error
+	// hasEntries returns whether the journal has any entries, including packed and trashed ones,
+	// even if it doesn't have an index
+	hasEntries() bool
+}
+
+// keyStoreFor returns where the configured journal keeps its key slots.
+func keyStoreFor(conf ejrnl.Config) (keyStore, error) {
+	if conf.Backend == S3Backend {
+		return newS3Store(conf)
+	}
+	directory, err := ExpandPath(conf.StorageDirectory)
+	return directoryStore(directory), err
+}
+
+// directoryStore keeps the key slots in the journal's directory. It's used by the files and sqlite
+// backends.
+type directoryStore string
+
+func (s directoryStore) readKeySlots() (keySlots, error) {
+	slots := keySlots{}
+	data, err := ioutil.ReadFile(fmt.Sprintf("%s/%s", s, keySlotsFile))
+	if err != nil {
+		return slots, err
+	}
+	err = json.Unmarshal(data, &slots)
+	return slots, err
+}
+
+func (s directoryStore) writeKeySlots(slots keySlots) error {
+	data, err := json.Marshal(slots)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(fmt.Sprintf("%s/%s", s, keySlotsFile), data, 0600)
+}
+
+func (s directoryStore) destroyKeySlots() error {
+	return crypto.Shred(fmt.Sprintf("%s/%s", s, keySlotsFile))
+}
+
+func (s directoryStore) destroyIndex() error {
+	for _, name := range []string{indexFile, indexLogFile, sqliteFile} {
+		err := crypto.Shred(fmt.Sprintf("%s/%s", s, name))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s directoryStore) hasEntries() bool {
+	return hasEntries(string(s))
+}
+
+// unlock opens a journal with the data key from its key slots or, for journals created before key
+// slots existed, with the key derived from the password. open creates the backend's driver from a
+// key. A new journal is given a random data key, wrapped with the password, instead.
+func unlock(conf ejrnl.Config, password *crypto.Secret, open func(ejrnl.Config, *crypto.Secret) (keyed, error)) (keyed, error) {
+	store, err := keyStoreFor(conf)
+	if err != nil {
+		return nil, err
+	}
+
+	slots, err := store.readKeySlots()
+	if err == nil {
+		key, err := slots.unwrap(password)
+		if err != nil {
+			return nil, err
+		}
+		return open(conf, key)
+	} else if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("Failed to read the key slots because %s", err)
+	}
+
+	salt, err := base64.StdEncoding.DecodeString(conf.Salt)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decode salt because %s", err)
+	}
+
+	key, err := crypto.DeriveKey(password, salt, conf.Pow)
+	if err != nil {
+		return nil, err
+	}
+	driver, err := open(conf, key)
+	if _, ok := err.(*NeedsInit); !ok || store.hasEntries() {
+		return driver, err
+	}
+
+	// This is a new journal so, it gets a random data key that is wrapped by the password's key.
+	dataKey, newErr := newDataKey()
+	if newErr != nil {
+		return driver, newErr
+	}
+	slot, newErr := newKeySlot(dataKey, password, conf.Salt, conf.Pow)
+	if newErr != nil {
+		dataKey.Close()
+		return driver, newErr
+	}
+	driver.setKey(dataKey, &slot)
+	return driver, err
+}
+
+// hasKeySlots returns whether the journal in the directory has a wrapped key.
+func hasKeySlots(directory string) bool {
+	_, err := os.Stat(fmt.Sprintf("%s/%s", directory, keySlotsFile))
+	return err == nil
+}
+
+// HasKeySlots returns whether the journal's key is wrapped. Only journals with wrapped keys can be
+// destroyed.
+func (d *Driver) HasKeySlots() bool {
+	return hasKeySlots(d.directory)
+}
+
+// CopyKeySlots copies the key slots of one journal to another so that the same passwords unlock
+// it. It does nothing if the journal's key is derived from its password.
+func CopyKeySlots(from, to ejrnl.Config) error {
+	fromStore, err := keyStoreFor(from)
+	if err != nil {
+		return err
+	}
+	toStore, err := keyStoreFor(to)
+	if err != nil {
+		return err
+	}
+	slots, err := fromStore.readKeySlots()
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	return toStore.writeKeySlots(slots)
+}
+
+// DestroyKeys overwrites and removes the journal's key slots. Afterwards, the journal can't be
+// decrypted, even with the correct password.
+func DestroyKeys(conf ejrnl.Config) error {
+	store, err := keyStoreFor(conf)
+	if err != nil {
+		return err
+	}
+	if _, err := store.readKeySlots(); os.IsNotExist(err) {
+		return ErrDerivedKey
+	}
+	return store.destroyKeySlots()
+}
+
+// Destroy destroys the journal's key slots and its index or database. The remaining entries are
+// unreadable and can be removed normally.
+func Destroy(conf ejrnl.Config) error {
+	if err := DestroyKeys(conf); err != nil {
+		return err
+	}
+	store, err := keyStoreFor(conf)
+	if err != nil {
+		return err
+	}
+	return store.destroyIndex()
+}
+
+// DuressHash hashes a duress password so that it can be stored in the config. The salt is
+// different from the one used for the journal's key so, the hash can't be used to decrypt anything.
+func DuressHash(conf ejrnl.Config, password *crypto.Secret) (string, error) {
+	salt, err := base64.StdEncoding.DecodeString(conf.Salt)
+	if err != nil {
+		return "", fmt.Errorf("Failed to decode salt because %s", err)
+	}
+	hash, err := crypto.DeriveKey(password, append(salt, []byte("duress")...), conf.Pow)
+	if err != nil {
+		return "", err
+	}
+	defer hash.Close()
+	return base64.StdEncoding.EncodeToString(hash.Bytes()), nil
+}
+
+// IsDuress returns whether the password is the journal's configured duress password.
+func IsDuress(conf ejrnl.Config, password *crypto.Secret) bool {
+	if conf.Duress == "" {
+		return false
+	}
+	hash, err := DuressHash(conf, password)
+	if err != nil {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(hash), []byte(conf.Duress)) == 1
+}
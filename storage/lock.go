@@ -0,0 +1,42 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+)
+
+// lockFile is locked while the index is used so that other processes using the same journal can't
+// change it at the same time. It isn't committed in git mode.
+const lockFile = "journal.lock"
+
+// lockJournal takes the driver's lock and a lock on the journal's lock file, which excludes other
+// processes too. The returned function releases both.
+func (d *Driver) lockJournal(exclusive bool) (func(), error) {
+	unlock := d.indexLock.RUnlock
+	if exclusive {
+		d.indexLock.Lock()
+		unlock = d.indexLock.Unlock
+	} else {
+		d.indexLock.RLock()
+	}
+
+	path := fmt.Sprintf("%s/%s", d.directory, lockFile)
+	_, statErr := os.Stat(path)
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		unlock()
+		return nil, fmt.Errorf("Failed to lock the journal because %s", err)
+	}
+	if os.IsNotExist(statErr) && d.hideActivity {
+		d.normalizeTime(lockFile)
+	}
+	if err = flock(file, exclusive); err != nil {
+		file.Close()
+		unlock()
+		return nil, fmt.Errorf("Failed to lock the journal because %s", err)
+	}
+	return func() {
+		file.Close()
+		unlock()
+	}, nil
+}
@@ -0,0 +1,207 @@
+// Package memory is an ejrnl.Driver that keeps entries in memory. It's meant for tests and for
+// trying things out, nothing is persisted.
+package memory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/satori/go.uuid"
+
+	"github.com/btobolaski/ejrnl"
+)
+
+// ErrClosed is returned when a driver is used after it has been closed.
+var ErrClosed = errors.New("The journal has been closed")
+
+type Driver struct {
+	lock    *sync.RWMutex
+	entries map[string]ejrnl.Entry
+	trash   map[string]trashed
+	closed  bool
+}
+
+// trashed is a deleted entry and when it was deleted.
+type trashed struct {
+	entry   ejrnl.Entry
+	deleted time.Time
+}
+
+// NewDriver creates an empty in memory journal.
+func NewDriver() *Driver {
+	return &Driver{
+		lock:    &sync.RWMutex{},
+		entries: make(map[string]ejrnl.Entry),
+		trash:   make(map[string]trashed),
+	}
+}
+
+// copyEntry copies the entry so that callers can't modify the stored entry, which matches the
+// behaviour of drivers that serialize entries.
+func copyEntry(entry ejrnl.Entry) ejrnl.Entry {
+	if entry.Date != nil {
+		date := *entry.Date
+		entry.Date = &date
+	}
+	if entry.Tags != nil {
+		entry.Tags = append([]string{}, entry.Tags...)
+	}
+	if entry.Location != nil {
+		location := *entry.Location
+		entry.Location = &location
+	}
+	if entry.Fields != nil {
+		fields := make(map[string]string)
+		for name, value := range entry.Fields {
+			fields[name] = value
+		}
+		entry.Fields = fields
+	}
+	return entry
+}
+
+func (d *Driver) Write(entry ejrnl.Entry) error {
+	return d.WriteBatch([]ejrnl.Entry{entry})
+}
+
+// WriteBatch writes several entries at once.
+func (d *Driver) WriteBatch(entries []ejrnl.Entry) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if d.closed {
+		return ErrClosed
+	}
+	for _, entry := range entries {
+		entry = copyEntry(entry)
+		entry = ejrnl.NormalizeDate(entry)
+		if entry.Id == "" {
+			entry.Id = fmt.Sprintf("%s", uuid.NewV4())
+		}
+		d.entries[entry.Id] = entry
+		delete(d.trash, entry.Id)
+	}
+	return nil
+}
+
+func (d *Driver) Read(id string) (ejrnl.Entry, error) {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+	if d.closed {
+		return ejrnl.Entry{}, ErrClosed
+	}
+	entry, ok := d.entries[id]
+	if !ok {
+		return ejrnl.Entry{}, fmt.Errorf("The entry %s doesn't exist", id)
+	}
+	return copyEntry(entry), nil
+}
+
+// List returns the index of all the entries stored in the journal
+func (d *Driver) List() (map[time.Time]string, error) {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+	if d.closed {
+		return map[time.Time]string{}, ErrClosed
+	}
+	val := make(map[time.Time]string)
+	for id, entry := range d.entries {
+		val[entry.Date.Local()] = id
+	}
+	return val, nil
+}
+
+// Summaries returns the id, date, title and links of every entry.
+func (d *Driver) Summaries(ctx context.Context) ([]ejrnl.Summary, error) {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+	if d.closed {
+		return nil, ErrClosed
+	}
+	summaries := []ejrnl.Summary{}
+	for id, entry := range d.entries {
+		summary := entry.Summary()
+		summary.Id = id
+		summaries = append(summaries, summary)
+	}
+	return summaries, nil
+}
+
+// Init does nothing, in memory journals are ready as soon as they're created.
+func (d *Driver) Init() error {
+	return nil
+}
+
+// Close discards all of the entries. The driver can't be used afterwards.
+func (d *Driver) Close() error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.entries = nil
+	d.closed = true
+	return nil
+}
+
+// Delete moves the entry into the trash.
+func (d *Driver) Delete(ctx context.Context, id string) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if d.closed {
+		return ErrClosed
+	}
+	entry, ok := d.entries[id]
+	if !ok {
+		return fmt.Errorf("The entry %s doesn't exist", id)
+	}
+	delete(d.entries, id)
+	d.trash[id] = trashed{entry: entry, deleted: time.Now()}
+	return nil
+}
+
+// Restore moves the entry out of the trash.
+func (d *Driver) Restore(ctx context.Context, id string) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if d.closed {
+		return ErrClosed
+	}
+	deleted, ok := d.trash[id]
+	if !ok {
+		return fmt.Errorf("The entry %s isn't in the trash", id)
+	}
+	delete(d.trash, id)
+	d.entries[id] = deleted.entry
+	return nil
+}
+
+// Trashed returns the entries in the trash.
+func (d *Driver) Trashed(ctx context.Context) ([]ejrnl.Trashed, error) {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+	if d.closed {
+		return nil, ErrClosed
+	}
+	trash := []ejrnl.Trashed{}
+	for _, deleted := range d.trash {
+		trash = append(trash, ejrnl.Trashed{Summary: deleted.entry.Summary(), Deleted: deleted.deleted})
+	}
+	return trash, nil
+}
+
+// Purge removes the entries that were moved to the trash before deletedBefore.
+func (d *Driver) Purge(ctx context.Context, deletedBefore time.Time) (int, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if d.closed {
+		return 0, ErrClosed
+	}
+	purged := 0
+	for id, deleted := range d.trash {
+		if deleted.deleted.Before(deletedBefore) {
+			delete(d.trash, id)
+			purged++
+		}
+	}
+	return purged, nil
+}
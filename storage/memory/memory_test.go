@@ -0,0 +1,22 @@
+package memory
+
+import (
+	"testing"
+
+	"github.com/btobolaski/ejrnl"
+	"github.com/btobolaski/ejrnl/storage/drivertest"
+)
+
+func TestConformance(t *testing.T) {
+	drivertest.Run(t, func(t *testing.T) ejrnl.Driver {
+		return NewDriver()
+	})
+}
+
+func TestClosed(t *testing.T) {
+	d := NewDriver()
+	d.Close()
+	if err := d.Write(ejrnl.Entry{Body: "closed"}); err != ErrClosed {
+		t.Errorf("Expected ErrClosed when writing to a closed journal, got %v", err)
+	}
+}
@@ -0,0 +1,147 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+
+	"github.com/btobolaski/ejrnl"
+)
+
+// Migrate rewrites every entry, including the entries in packs and in the trash, and the index with
+// the driver's current compression and padding settings. Each entry's index entry is rebuilt so that
+// its links are indexed. Entries are rewritten where they're stored, so packed entries stay packed
+// and only one pack is held in memory at a time. If it's canceled, the entries that were already
+// rewritten keep their new settings.
+func (d *Driver) Migrate(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	unlock, err := d.lockJournal(true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	current, err := d.readIndex()
+	if err != nil {
+		return err
+	}
+	updated := make(index)
+	for id, location := range current {
+		updated[id] = location
+	}
+
+	trashed := false
+	for id, location := range current {
+		if ctx.Err() != nil {
+			break
+		}
+		if location.packed() {
+			continue
+		}
+		cyphertext, err := ioutil.ReadFile(fmt.Sprintf("%s/%s", d.directory, location.File))
+		if err != nil {
+			return err
+		}
+		entry, cyphertext, err := d.reencrypt(cyphertext)
+		if err != nil {
+			return fmt.Errorf("Failed to migrate %s because %s", id, err)
+		}
+		if err = d.writeFile(location.File, cyphertext); err != nil {
+			return err
+		}
+		rebuilt := newIndexEntry(entry, location.File)
+		rebuilt.Deleted = location.Deleted
+		updated[id] = rebuilt
+		trashed = trashed || location.trashed()
+	}
+
+	// Each pack is replaced by a new one so that a failure part way through doesn't lose any entries.
+	// Superseded copies of entries are left out the same way that Pack leaves them out.
+	packs, err := d.listPacks()
+	if err != nil {
+		return err
+	}
+	rewritten := []string{}
+	for _, pack := range packs {
+		if ctx.Err() != nil {
+			break
+		}
+		records, err := d.readPackContents(pack)
+		if err != nil {
+			return err
+		}
+		writer := &packWriter{}
+		for _, record := range records {
+			location, ok := current[record.Id]
+			if !ok || location.File != pack || location.Offset != record.Offset {
+				continue
+			}
+			cyphertext, err := d.readPacked(location)
+			if err != nil {
+				return err
+			}
+			entry, cyphertext, err := d.reencrypt(cyphertext)
+			if err != nil {
+				return fmt.Errorf("Failed to migrate %s because %s", record.Id, err)
+			}
+			writer.add(record.Id, cyphertext)
+			updated[record.Id] = newIndexEntry(entry, pack)
+		}
+		if len(writer.records) > 0 {
+			name, err := d.writePack(writer)
+			if err != nil {
+				return err
+			}
+			for _, record := range writer.records {
+				location := updated[record.Id]
+				location.File, location.Offset, location.Length = name, record.Offset, record.Length
+				updated[record.Id] = location
+			}
+		}
+		rewritten = append(rewritten, pack)
+	}
+
+	// A new snapshot also rewrites the index with the current settings
+	if err = d.writeIndex(updated); err != nil {
+		return err
+	}
+	for _, name := range rewritten {
+		if err = d.remove(name); err != nil && !os.IsNotExist(err) {
+			log.Printf("Failed to remove %s after migrating it because %s", name, err)
+		}
+	}
+	if d.hideActivity {
+		d.normalizeTime("")
+		if len(rewritten) > 0 {
+			d.normalizeTime(packDirectory)
+		}
+		if trashed {
+			d.normalizeTime(trashDirectory)
+		}
+	}
+	if err = d.commit(); err != nil {
+		return err
+	}
+	return ctx.Err()
+}
+
+// reencrypt decrypts an entry and encrypts it again with the driver's current settings.
+func (d *Driver) reencrypt(cyphertext []byte) (ejrnl.Entry, []byte, error) {
+	plaintext, err := d.decrypt(cyphertext)
+	if err != nil {
+		return ejrnl.Entry{}, nil, err
+	}
+	entry := ejrnl.Entry{}
+	if err = json.Unmarshal(plaintext, &entry); err != nil {
+		return ejrnl.Entry{}, nil, err
+	}
+	if entry.Date == nil {
+		return ejrnl.Entry{}, nil, fmt.Errorf("The entry %s doesn't have a date", entry.Id)
+	}
+	cyphertext, err = d.compression.CompressAndEncrypt(plaintext, d.key.Bytes())
+	return entry, cyphertext, err
+}
@@ -0,0 +1,95 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/btobolaski/ejrnl"
+)
+
+func TestMigrate(t *testing.T) {
+	conf := ejrnl.Config{StorageDirectory: "./migrate-test", Salt: makeSalt(32), Pow: 12}
+	d, err := driverInit(conf)
+	defer os.RemoveAll(conf.StorageDirectory)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	old := time.Now().AddDate(-1, 0, 0)
+	bodies := map[string]string{}
+	for i := 0; i < 6; i++ {
+		date := old.Add(time.Duration(i) * time.Hour)
+		id := fmt.Sprintf("%d", i)
+		bodies[id] = strings.Repeat(fmt.Sprintf("old entry %d ", i), i*10+1)
+		if err = d.Write(ejrnl.Entry{Id: id, Date: &date, Body: bodies[id]}); err != nil {
+			t.Fatalf("Failed to write entry because %s", err)
+		}
+	}
+	if _, err = d.Pack(time.Now().AddDate(0, 0, -30)); err != nil {
+		t.Fatalf("Failed to pack the journal because %s", err)
+	}
+	bodies["recent"] = "links to [[0]]"
+	if err = d.Write(ejrnl.Entry{Id: "recent", Body: bodies["recent"]}); err != nil {
+		t.Fatalf("Failed to write entry because %s", err)
+	}
+	if err = d.Delete(context.Background(), "5"); err != nil {
+		t.Fatalf("Failed to delete entry because %s", err)
+	}
+	d.Close()
+
+	conf.Compression = "none"
+	conf.Padding = "4096"
+	d, err = NewDriver(conf, "password")
+	if err != nil {
+		t.Fatalf("Failed to open journal because %s", err)
+	}
+	defer d.Close()
+	if err = d.Migrate(context.Background()); err != nil {
+		t.Fatalf("Failed to migrate because %s", err)
+	}
+
+	current, err := d.readIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	lengths := map[int64]bool{}
+	for id, location := range current {
+		switch {
+		case id == "recent":
+			if location.packed() || len(location.Links) != 1 {
+				t.Errorf("Expected the recent entry to stay loose with its link indexed, got %#v", location)
+			}
+		case location.trashed():
+			if id != "5" {
+				t.Errorf("Expected only entry 5 in the trash, got %s", id)
+			}
+		case !location.packed():
+			t.Errorf("Expected entry %s to stay packed, got %#v", id, location)
+		default:
+			lengths[location.Length] = true
+		}
+	}
+	if len(lengths) != 1 {
+		t.Errorf("Expected the packed entries to be padded to the same length, got %v", lengths)
+	}
+	if packs, _ := d.listPacks(); len(packs) != 1 {
+		t.Errorf("Expected the pack to be replaced by a single pack, got %v", packs)
+	}
+	if _, err = os.Stat(fmt.Sprintf("%s/%s", conf.StorageDirectory, indexLogFile)); !os.IsNotExist(err) {
+		t.Errorf("Expected the index to be rewritten as a snapshot, got %v", err)
+	}
+
+	if err = d.Restore(context.Background(), "5"); err != nil {
+		t.Fatalf("Failed to restore entry because %s", err)
+	}
+	for id, body := range bodies {
+		entry, err := d.Read(id)
+		if err != nil || entry.Body != body {
+			t.Errorf("Failed to read migrated entry %s, got %#v %v", id, entry, err)
+		}
+	}
+}
@@ -0,0 +1,314 @@
+package storage
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// Packs hold many entries in a single file so that large journals don't need thousands of small
+// files. A pack is the entries' encrypted files, exactly as they would be stored on their own,
+// followed by an encrypted table of contents and the length of the table of contents as a big
+// endian uint32. The index records where each packed entry is. An entry that is written again is
+// stored in its own file, which supersedes the packed copy until the packs are repacked.
+const (
+	packDirectory = "packs"
+	packExtension = ".pack"
+	// packSize is roughly the largest that a pack grows
+	packSize = 8 * 1024 * 1024
+)
+
+// packRecord is the table of contents' entry for each entry in the pack.
+type packRecord struct {
+	Id     string
+	Offset int64
+	Length int64
+}
+
+// packed returns whether the index entry is stored in a pack.
+func (e indexEntry) packed() bool {
+	return strings.HasPrefix(e.File, packDirectory+"/")
+}
+
+// readPacked decrypts the entry at the index entry's location in a pack. The caller must hold at
+// least a shared journal lock.
+func (d *Driver) readPacked(location indexEntry) ([]byte, error) {
+	file, err := os.Open(fmt.Sprintf("%s/%s", d.directory, location.File))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	cyphertext := make([]byte, location.Length)
+	if _, err = file.ReadAt(cyphertext, location.Offset); err != nil {
+		return nil, fmt.Errorf("Failed to read from %s because %s", location.File, err)
+	}
+	return cyphertext, nil
+}
+
+// readPackContents returns a pack's table of contents.
+func (d *Driver) readPackContents(name string) ([]packRecord, error) {
+	data, err := ioutil.ReadFile(fmt.Sprintf("%s/%s", d.directory, name))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 4 {
+		return nil, fmt.Errorf("The pack %s is truncated", name)
+	}
+	length := int(binary.BigEndian.Uint32(data[len(data)-4:]))
+	if length > len(data)-4 {
+		return nil, fmt.Errorf("The pack %s is truncated", name)
+	}
+	plaintext, err := d.decrypt(data[len(data)-4-length : len(data)-4])
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decrypt the contents of %s because %s", name, err)
+	}
+	records := []packRecord{}
+	err = json.Unmarshal(plaintext, &records)
+	return records, err
+}
+
+// listPacks returns the names, relative to the journal's directory, of every pack.
+func (d *Driver) listPacks() ([]string, error) {
+	files, err := ioutil.ReadDir(fmt.Sprintf("%s/%s", d.directory, packDirectory))
+	if os.IsNotExist(err) {
+		return []string{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	packs := []string{}
+	for _, file := range files {
+		if strings.HasSuffix(file.Name(), packExtension) {
+			packs = append(packs, fmt.Sprintf("%s/%s", packDirectory, file.Name()))
+		}
+	}
+	return packs, nil
+}
+
+// packWriter builds a pack in memory.
+type packWriter struct {
+	data    []byte
+	records []packRecord
+}
+
+func (p *packWriter) add(id string, cyphertext []byte) {
+	p.records = append(p.records, packRecord{Id: id, Offset: int64(len(p.data)), Length: int64(len(cyphertext))})
+	p.data = append(p.data, cyphertext...)
+}
+
+// writePack finishes the pack and writes it under a random name, which it returns.
+func (d *Driver) writePack(p *packWriter) (string, error) {
+	plaintext, err := json.Marshal(p.records)
+	if err != nil {
+		return "", err
+	}
+	contents, err := d.compression.CompressAndEncrypt(plaintext, d.key.Bytes())
+	if err != nil {
+		return "", err
+	}
+	length := make([]byte, 4)
+	binary.BigEndian.PutUint32(length, uint32(len(contents)))
+	data := append(append(p.data, contents...), length...)
+
+	random := make([]byte, 16)
+	if _, err = rand.Read(random); err != nil {
+		return "", err
+	}
+	if err = os.MkdirAll(fmt.Sprintf("%s/%s", d.directory, packDirectory), 0700); err != nil {
+		return "", err
+	}
+	name := fmt.Sprintf("%s/%s%s", packDirectory, hex.EncodeToString(random), packExtension)
+	file, err := os.OpenFile(fmt.Sprintf("%s/%s", d.directory, name), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return "", err
+	}
+	if _, err = file.Write(data); err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", err
+	}
+	if d.hideActivity {
+		d.normalizeTime(name)
+	}
+	return name, nil
+}
+
+// PackResult describes what Pack changed.
+type PackResult struct {
+	// Packed is the number of entries that were moved from their own files into packs
+	Packed int
+	// Repacked is the number of packs that were rewritten to remove superseded entries
+	Repacked int
+}
+
+// Pack moves the entries dated before cutoff out of their own files and into packs. Packs that hold
+// superseded copies of entries, which were written again after they were packed, are rewritten
+// without them.
+func (d *Driver) Pack(cutoff time.Time) (PackResult, error) {
+	unlock, err := d.lockJournal(true)
+	if err != nil {
+		return PackResult{}, err
+	}
+	defer unlock()
+	return d.pack(cutoff)
+}
+
+// pack is Pack without taking the journal lock. The caller must hold an exclusive journal lock.
+func (d *Driver) pack(cutoff time.Time) (PackResult, error) {
+	result := PackResult{}
+	current, err := d.readIndex()
+	if err != nil {
+		return result, err
+	}
+
+	// Packs are only rewritten if some of their entries are no longer current
+	packs, err := d.listPacks()
+	if err != nil {
+		return result, err
+	}
+	dissolved := []string{}
+	moving := make(map[string]indexEntry)
+	for _, pack := range packs {
+		records, err := d.readPackContents(pack)
+		if err != nil {
+			return result, err
+		}
+		live := make(map[string]indexEntry)
+		for _, record := range records {
+			entry, ok := current[record.Id]
+			if ok && entry.File == pack && entry.Offset == record.Offset {
+				live[record.Id] = entry
+			}
+		}
+		if len(live) == len(records) {
+			continue
+		}
+		dissolved = append(dissolved, pack)
+		for id, entry := range live {
+			moving[id] = entry
+		}
+	}
+
+	loose := []string{}
+	for id, entry := range current {
+		if !entry.packed() && !entry.trashed() && entry.Date.Before(cutoff) {
+			moving[id] = entry
+			loose = append(loose, entry.File)
+		}
+	}
+	if len(moving) == 0 && len(dissolved) == 0 {
+		return result, nil
+	}
+
+	// The new packs are written and the index updated before anything is removed so that a failure
+	// part way through doesn't lose any entries.
+	updated := make(index)
+	for id, entry := range current {
+		updated[id] = entry
+	}
+	writer := &packWriter{}
+	flush := func() error {
+		name, err := d.writePack(writer)
+		if err != nil {
+			return err
+		}
+		for _, record := range writer.records {
+			location := updated[record.Id]
+			location.File, location.Offset, location.Length = name, record.Offset, record.Length
+			updated[record.Id] = location
+		}
+		writer = &packWriter{}
+		return nil
+	}
+	for id, entry := range moving {
+		var cyphertext []byte
+		if entry.packed() {
+			cyphertext, err = d.readPacked(entry)
+		} else {
+			cyphertext, err = ioutil.ReadFile(fmt.Sprintf("%s/%s", d.directory, entry.File))
+		}
+		if err != nil {
+			return result, err
+		}
+		writer.add(id, cyphertext)
+		if len(writer.data) >= packSize {
+			if err = flush(); err != nil {
+				return result, err
+			}
+		}
+	}
+	if len(writer.records) > 0 {
+		if err = flush(); err != nil {
+			return result, err
+		}
+	}
+	if err = d.writeIndex(updated); err != nil {
+		return result, err
+	}
+
+	for _, name := range append(loose, dissolved...) {
+		if err = d.remove(name); err != nil && !os.IsNotExist(err) {
+			log.Printf("Failed to remove %s after packing it because %s", name, err)
+		}
+	}
+	if d.hideActivity {
+		d.normalizeTime("")
+		d.normalizeTime(packDirectory)
+	}
+	result.Packed, result.Repacked = len(loose), len(dissolved)
+	return result, d.commit()
+}
+
+// recoverPacks adds the entries in the journal's packs to the recovered index. Entries that were
+// recovered from their own files are newer than their packed copies so, they're kept.
+func (d *Driver) recoverPacks(recovered index) error {
+	packs, err := d.listPacks()
+	if err != nil {
+		return err
+	}
+	failed := 0
+	for _, pack := range packs {
+		records, err := d.readPackContents(pack)
+		if err != nil {
+			log.Printf("Failed to recover %s because %s", pack, err)
+			failed++
+			continue
+		}
+		for _, record := range records {
+			if _, ok := recovered[record.Id]; ok {
+				continue
+			}
+			location := indexEntry{File: pack, Offset: record.Offset, Length: record.Length}
+			cyphertext, err := d.readPacked(location)
+			if err != nil {
+				log.Printf("Failed to recover %s from %s because %s", record.Id, pack, err)
+				failed++
+				continue
+			}
+			entry, err := d.decryptEntry(cyphertext)
+			if err != nil {
+				log.Printf("Failed to recover %s from %s because %s", record.Id, pack, err)
+				failed++
+				continue
+			}
+			location = newIndexEntry(entry, pack)
+			location.Offset, location.Length = record.Offset, record.Length
+			recovered[entry.Id] = location
+		}
+	}
+	if failed > 0 {
+		return errors.New("Failed to recover one or more entries. See previous messages")
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package storage
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/btobolaski/ejrnl"
+)
+
+func looseFiles(t *testing.T, directory string) int {
+	files, err := ioutil.ReadDir(directory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	count := 0
+	for _, file := range files {
+		if strings.HasSuffix(file.Name(), ".cpt") && file.Name() != indexFile {
+			count++
+		}
+	}
+	return count
+}
+
+func TestPack(t *testing.T) {
+	conf := ejrnl.Config{StorageDirectory: "./pack-test", Salt: makeSalt(32), Pow: 12}
+	d, err := driverInit(conf)
+	defer os.RemoveAll(conf.StorageDirectory)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	old := time.Now().AddDate(-1, 0, 0)
+	for i := 0; i < 10; i++ {
+		date := old.Add(time.Duration(i) * time.Hour)
+		if err = d.Write(ejrnl.Entry{Id: fmt.Sprintf("%d", i), Date: &date, Body: fmt.Sprintf("old %d", i)}); err != nil {
+			t.Fatalf("Failed to write entry because %s", err)
+		}
+	}
+	if err = d.Write(ejrnl.Entry{Id: "recent", Body: "recent"}); err != nil {
+		t.Fatalf("Failed to write entry because %s", err)
+	}
+
+	result, err := d.Pack(time.Now().AddDate(0, 0, -30))
+	if err != nil {
+		t.Fatalf("Failed to pack the journal because %s", err)
+	}
+	if result.Packed != 10 || looseFiles(t, conf.StorageDirectory) != 1 {
+		t.Errorf("Expected only the old entries to be packed, got %#v", result)
+	}
+	for i := 0; i < 10; i++ {
+		entry, err := d.Read(fmt.Sprintf("%d", i))
+		if err != nil || entry.Body != fmt.Sprintf("old %d", i) {
+			t.Errorf("Failed to read packed entry %d, got %#v %v", i, entry, err)
+		}
+	}
+
+	// Rewriting a packed entry supersedes the packed copy
+	date := old
+	if err = d.Write(ejrnl.Entry{Id: "0", Date: &date, Body: "rewritten"}); err != nil {
+		t.Fatalf("Failed to write entry because %s", err)
+	}
+	if entry, err := d.Read("0"); err != nil || entry.Body != "rewritten" {
+		t.Errorf("Expected the rewritten entry, got %#v %v", entry, err)
+	}
+
+	// Repacking removes the superseded copy
+	result, err = d.Pack(time.Now().AddDate(0, 0, -30))
+	if err != nil {
+		t.Fatalf("Failed to repack the journal because %s", err)
+	}
+	if result.Packed != 1 || result.Repacked != 1 {
+		t.Errorf("Expected the superseded pack to be repacked, got %#v", result)
+	}
+	packs, _ := d.listPacks()
+	if len(packs) != 1 {
+		t.Fatalf("Expected a single pack after repacking, got %v", packs)
+	}
+	records, err := d.readPackContents(packs[0])
+	if err != nil || len(records) != 10 {
+		t.Errorf("Expected the pack to only hold the current copies, got %v %v", records, err)
+	}
+	if entry, err := d.Read("0"); err != nil || entry.Body != "rewritten" {
+		t.Errorf("Expected the rewritten entry after repacking, got %#v %v", entry, err)
+	}
+
+	data, _ := ioutil.ReadFile(fmt.Sprintf("%s/%s", conf.StorageDirectory, packs[0]))
+	if strings.Contains(string(data), "old") || strings.Contains(string(data), "rewritten") {
+		t.Error("The pack contains plaintext")
+	}
+
+	// The index can be recovered from the packs
+	d.Close()
+	os.Remove(fmt.Sprintf("%s/%s", conf.StorageDirectory, indexFile))
+	os.Remove(fmt.Sprintf("%s/%s", conf.StorageDirectory, indexLogFile))
+	d, err = driverInit(conf)
+	if err != nil {
+		t.Fatalf("Failed to recover the journal because %s", err)
+	}
+	listing, err := d.List()
+	if err != nil || len(listing) != 11 {
+		t.Errorf("Expected every entry to be recovered, got %d %v", len(listing), err)
+	}
+}
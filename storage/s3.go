@@ -0,0 +1,575 @@
+package storage
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/satori/go.uuid"
+
+	"github.com/btobolaski/ejrnl"
+	"github.com/btobolaski/ejrnl/compression"
+	"github.com/btobolaski/ejrnl/crypto"
+)
+
+// s3Retries is how many times an index update is retried when someone else changes the index.
+const s3Retries = 10
+
+// The journal's objects are named the same way as the files backend's files. The index is updated
+// with conditional puts so that journals shared between devices don't lose entries. The storage
+// directory holds a copy of the index, which is only downloaded again when its etag changes.
+const (
+	s3IndexObject   = "index.cpt"
+	s3EntriesPrefix = "entries/"
+	s3TrashPrefix   = "trash/"
+	s3CacheFile     = "index.cpt"
+	s3CacheEtagFile = "index.etag"
+)
+
+// s3Store is the s3 backend's keyStore.
+type s3Store struct {
+	client *s3Client
+	prefix string
+}
+
+func newS3Store(conf ejrnl.Config) (s3Store, error) {
+	if conf.S3 == nil || conf.S3.Bucket == "" || conf.S3.Endpoint == "" {
+		return s3Store{}, errors.New("The s3 backend needs an endpoint and a bucket in the config")
+	}
+	client, err := newS3Client(conf.S3.Endpoint, conf.S3.Region, conf.S3.Bucket, conf.S3.AccessKey, conf.S3.SecretKey)
+	return s3Store{client: client, prefix: conf.S3.Prefix}, err
+}
+
+func (s s3Store) readKeySlots() (keySlots, error) {
+	slots := keySlots{}
+	data, _, err := s.client.get(context.Background(), s.prefix+keySlotsFile, "")
+	if err != nil {
+		return slots, err
+	}
+	err = json.Unmarshal(data, &slots)
+	return slots, err
+}
+
+func (s s3Store) writeKeySlots(slots keySlots) error {
+	data, err := json.Marshal(slots)
+	if err != nil {
+		return err
+	}
+	_, err = s.client.put(context.Background(), s.prefix+keySlotsFile, data, "")
+	return err
+}
+
+// destroyKeySlots deletes the key slots. Buckets with versioning enabled keep previous versions so,
+// they need to be removed separately.
+func (s s3Store) destroyKeySlots() error {
+	return s.client.delete(context.Background(), s.prefix+keySlotsFile)
+}
+
+func (s s3Store) destroyIndex() error {
+	return s.client.delete(context.Background(), s.prefix+s3IndexObject)
+}
+
+func (s s3Store) hasEntries() bool {
+	for _, prefix := range []string{s3EntriesPrefix, s3TrashPrefix} {
+		keys, err := s.client.list(context.Background(), s.prefix+prefix)
+		if err == nil && len(keys) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+type S3Driver struct {
+	store       s3Store
+	cache       string
+	indexLock   *sync.Mutex
+	key         *crypto.Secret
+	fileKey     *crypto.Secret
+	compression compression.Options
+	// newSlot is the key slot that is written when a new journal is inited
+	newSlot *keySlot
+}
+
+// NewS3DriverWithPassword creates a driver for a journal in an S3 compatible bucket from the
+// specified config and password. The caller retains ownership of the password.
+func NewS3DriverWithPassword(conf ejrnl.Config, password *crypto.Secret) (*S3Driver, error) {
+	driver, err := unlock(conf, password, func(conf ejrnl.Config, key *crypto.Secret) (keyed, error) {
+		return NewS3DriverWithKey(conf, key)
+	})
+	if d, ok := driver.(*S3Driver); ok {
+		return d, err
+	}
+	return &S3Driver{}, err
+}
+
+// NewS3DriverWithKey creates a driver for a journal in an S3 compatible bucket from the journal's
+// key. The driver takes ownership of the key.
+func NewS3DriverWithKey(conf ejrnl.Config, key *crypto.Secret) (*S3Driver, error) {
+	driver := &S3Driver{
+		indexLock:   &sync.Mutex{},
+		key:         key,
+		fileKey:     filenameKey(key),
+		compression: compression.Options{Codec: conf.Compression},
+	}
+
+	if _, err := compression.Lookup(conf.Compression); err != nil {
+		return driver, err
+	}
+	padding, err := compression.ParsePadding(conf.Padding)
+	if err != nil {
+		return driver, err
+	}
+	driver.compression.Padding = padding
+
+	driver.store, err = newS3Store(conf)
+	if err != nil {
+		return driver, err
+	}
+	driver.cache, err = ExpandPath(conf.StorageDirectory)
+	if err != nil {
+		return driver, err
+	}
+	if err = os.MkdirAll(driver.cache, 0700); err != nil {
+		return driver, fmt.Errorf("Failed to create the cache directory because %s", err)
+	}
+
+	driver.indexLock.Lock()
+	defer driver.indexLock.Unlock()
+	if _, _, err = driver.readIndex(context.Background()); os.IsNotExist(err) {
+		return driver, &NeedsInit{msg: "the index doesn't exist"}
+	}
+	return driver, err
+}
+
+// Key returns the key that the journal is encrypted with. It is wiped when the driver is closed.
+func (d *S3Driver) Key() *crypto.Secret {
+	return d.key
+}
+
+// setKey replaces the driver's key with a new data key whose key slot is written by Init.
+func (d *S3Driver) setKey(key *crypto.Secret, slot *keySlot) {
+	d.Close()
+	d.key = key
+	d.fileKey = filenameKey(key)
+	d.newSlot = slot
+}
+
+// HasKeySlots returns whether the journal's key is wrapped. Only journals with wrapped keys can be
+// destroyed.
+func (d *S3Driver) HasKeySlots() bool {
+	_, err := d.store.readKeySlots()
+	return err == nil
+}
+
+// object returns the name of the object that the entry is stored in.
+func (d *S3Driver) object(id string) string {
+	return fmt.Sprintf("%s%s%s.cpt", d.store.prefix, s3EntriesPrefix, hex.EncodeToString(blind(d.fileKey, id)[:16]))
+}
+
+// readIndex returns the index and its etag. The cached copy is used if it's still current. The
+// caller must hold d.indexLock.
+func (d *S3Driver) readIndex(ctx context.Context) (index, string, error) {
+	cachePath := fmt.Sprintf("%s/%s", d.cache, s3CacheFile)
+	etagPath := fmt.Sprintf("%s/%s", d.cache, s3CacheEtagFile)
+	cached, cacheErr := ioutil.ReadFile(cachePath)
+	etag, etagErr := ioutil.ReadFile(etagPath)
+	if cacheErr != nil || etagErr != nil {
+		etag = nil
+	}
+
+	cyphertext, newEtag, err := d.store.client.get(ctx, d.store.prefix+s3IndexObject, string(etag))
+	if err == errNotModified {
+		cyphertext = cached
+	} else if err != nil {
+		return index{}, "", err
+	} else {
+		d.cacheIndex(cyphertext, newEtag)
+	}
+
+	plaintext, err := d.compression.DecryptAndDecompress(cyphertext, d.key.Bytes())
+	if err != nil {
+		return index{}, "", err
+	}
+	decoded, err := decodeIndex(plaintext)
+	return decoded, newEtag, err
+}
+
+// cacheIndex saves a copy of the index. A failure only means that it's downloaded again next time.
+func (d *S3Driver) cacheIndex(cyphertext []byte, etag string) {
+	err := ioutil.WriteFile(fmt.Sprintf("%s/%s", d.cache, s3CacheFile), cyphertext, 0600)
+	if err == nil {
+		err = ioutil.WriteFile(fmt.Sprintf("%s/%s", d.cache, s3CacheEtagFile), []byte(etag), 0600)
+	}
+	if err != nil {
+		log.Printf("Failed to cache the index because %s", err)
+	}
+}
+
+// writeIndex stores the index if it still has the etag. The caller must hold d.indexLock.
+func (d *S3Driver) writeIndex(ctx context.Context, index index, etag string) error {
+	plaintext, err := json.Marshal(index)
+	if err != nil {
+		return err
+	}
+	cyphertext, err := d.compression.CompressAndEncrypt(plaintext, d.key.Bytes())
+	if err != nil {
+		return err
+	}
+	newEtag, err := d.store.client.put(ctx, d.store.prefix+s3IndexObject, cyphertext, etag)
+	if err != nil {
+		return err
+	}
+	d.cacheIndex(cyphertext, newEtag)
+	return nil
+}
+
+// updateIndex applies the update to the current index. If someone else changes the index first,
+// the update is applied to their index instead.
+func (d *S3Driver) updateIndex(ctx context.Context, update func(index)) error {
+	d.indexLock.Lock()
+	defer d.indexLock.Unlock()
+	for attempt := 0; attempt < s3Retries; attempt++ {
+		current, etag, err := d.readIndex(ctx)
+		if err != nil {
+			return err
+		}
+		update(current)
+		err = d.writeIndex(ctx, current, etag)
+		if err != errPreconditionFailed {
+			return err
+		}
+	}
+	return errors.New("Gave up updating the index because it kept changing")
+}
+
+func (d *S3Driver) Write(entry ejrnl.Entry) error {
+	return d.WriteBatchContext(context.Background(), []ejrnl.Entry{entry})
+}
+
+func (d *S3Driver) WriteContext(ctx context.Context, entry ejrnl.Entry) error {
+	return d.WriteBatchContext(ctx, []ejrnl.Entry{entry})
+}
+
+// WriteBatch writes several entries and then updates the index once.
+func (d *S3Driver) WriteBatch(entries []ejrnl.Entry) error {
+	return d.WriteBatchContext(context.Background(), entries)
+}
+
+// WriteBatchContext is WriteBatch but it stops uploading entries when the context is canceled. The
+// entries that were already uploaded are still added to the index.
+func (d *S3Driver) WriteBatchContext(ctx context.Context, entries []ejrnl.Entry) error {
+	written := make(index)
+	var canceled error
+	for _, entry := range entries {
+		if canceled = ctx.Err(); canceled != nil {
+			break
+		}
+		entry = ejrnl.NormalizeDate(entry)
+		if entry.Id == "" {
+			entry.Id = fmt.Sprintf("%s", uuid.NewV4())
+		}
+		plaintext, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		cyphertext, err := d.compression.CompressAndEncrypt(plaintext, d.key.Bytes())
+		if err != nil {
+			return err
+		}
+		object := d.object(entry.Id)
+		if _, err = d.store.client.put(ctx, object, cyphertext, ""); err != nil {
+			if canceled = ctx.Err(); canceled != nil {
+				break
+			}
+			return err
+		}
+		written[entry.Id] = newIndexEntry(entry, strings.TrimPrefix(object, d.store.prefix))
+	}
+	if len(written) == 0 {
+		return canceled
+	}
+
+	// The index is updated even if the write was canceled so that it matches the uploaded entries.
+	// Entries that are written again are taken out of the trash.
+	stale := []string{}
+	err := d.updateIndex(context.Background(), func(current index) {
+		stale = stale[:0]
+		for id, entry := range written {
+			if previous, ok := current[id]; ok && previous.trashed() {
+				stale = append(stale, d.store.prefix+previous.File)
+			}
+			current[id] = entry
+		}
+	})
+	if err != nil {
+		return err
+	}
+	for _, object := range stale {
+		if err = d.store.client.delete(context.Background(), object); err != nil {
+			log.Printf("Failed to remove %s from the trash because %s", object, err)
+		}
+	}
+	return canceled
+}
+
+func (d *S3Driver) Read(id string) (ejrnl.Entry, error) {
+	return d.ReadContext(context.Background(), id)
+}
+
+func (d *S3Driver) ReadContext(ctx context.Context, id string) (ejrnl.Entry, error) {
+	cyphertext, _, err := d.store.client.get(ctx, d.object(id), "")
+	if err != nil {
+		return ejrnl.Entry{}, err
+	}
+	return d.decryptEntry(cyphertext)
+}
+
+func (d *S3Driver) decryptEntry(cyphertext []byte) (ejrnl.Entry, error) {
+	plaintext, err := d.compression.DecryptAndDecompress(cyphertext, d.key.Bytes())
+	if err != nil {
+		return ejrnl.Entry{}, err
+	}
+	entry := &ejrnl.Entry{}
+	err = json.Unmarshal(plaintext, entry)
+	return *entry, err
+}
+
+// List returns the index of all the entries stored in the journal
+func (d *S3Driver) List() (map[time.Time]string, error) {
+	return d.ListContext(context.Background())
+}
+
+func (d *S3Driver) ListContext(ctx context.Context) (map[time.Time]string, error) {
+	d.indexLock.Lock()
+	defer d.indexLock.Unlock()
+	index, _, err := d.readIndex(ctx)
+	if err != nil {
+		return map[time.Time]string{}, err
+	}
+	val := make(map[time.Time]string)
+	for id, entry := range index {
+		if !entry.trashed() {
+			val[entry.Date.Local()] = id
+		}
+	}
+	return val, nil
+}
+
+// Summaries returns the id, date, title and links of every entry.
+func (d *S3Driver) Summaries(ctx context.Context) ([]ejrnl.Summary, error) {
+	d.indexLock.Lock()
+	defer d.indexLock.Unlock()
+	index, _, err := d.readIndex(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return index.summaries(), nil
+}
+
+// Init creates the new journal. If the bucket already has entries, but no index, the index is
+// recovered from them.
+func (d *S3Driver) Init() error {
+	return d.InitContext(context.Background())
+}
+
+func (d *S3Driver) InitContext(ctx context.Context) error {
+	objects, err := d.store.client.list(ctx, d.store.prefix+s3EntriesPrefix)
+	if err != nil {
+		return fmt.Errorf("Failed to list the bucket because %s", err)
+	}
+	trash, err := d.store.client.list(ctx, d.store.prefix+s3TrashPrefix)
+	if err != nil {
+		return fmt.Errorf("Failed to list the bucket because %s", err)
+	}
+	// The new key couldn't read anything that was written since the journal was opened
+	if d.newSlot != nil && len(objects)+len(trash) > 0 {
+		return errHasEntries
+	}
+	recovered := make(index)
+	failed := 0
+	for _, object := range append(objects, trash...) {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		cyphertext, _, err := d.store.client.get(ctx, object, "")
+		if err == nil {
+			var entry ejrnl.Entry
+			entry, err = d.decryptEntry(cyphertext)
+			if err == nil {
+				recovered[entry.Id] = recoveredEntry(recovered, entry, strings.TrimPrefix(object, d.store.prefix))
+				continue
+			}
+		}
+		log.Printf("Failed to recover %s because %s", object, err)
+		failed++
+	}
+	if failed > 0 {
+		return errors.New("Failed to recover one or more entries. See previous messages")
+	}
+	if d.newSlot != nil {
+		err := d.store.writeKeySlots(keySlots{Version: 1, Slots: []keySlot{*d.newSlot}})
+		if err != nil {
+			return fmt.Errorf("Failed to write the key slots because %s", err)
+		}
+		d.newSlot = nil
+	}
+
+	d.indexLock.Lock()
+	defer d.indexLock.Unlock()
+	err = d.writeIndex(ctx, recovered, "*")
+	if err == errPreconditionFailed {
+		return errors.New("The journal was inited by someone else")
+	}
+	return err
+}
+
+// recoveredEntry returns the index entry for an entry recovered from the object. Entries in the trash
+// are treated as if they were deleted when they were recovered, unless they're also stored outside
+// of it.
+func recoveredEntry(recovered index, entry ejrnl.Entry, object string) indexEntry {
+	recovering := newIndexEntry(entry, object)
+	if !strings.HasPrefix(object, s3TrashPrefix) {
+		return recovering
+	}
+	if previous, ok := recovered[entry.Id]; ok {
+		return previous
+	}
+	now := time.Now()
+	recovering.Deleted = &now
+	return recovering
+}
+
+// Delete moves the entry's object into the trash.
+func (d *S3Driver) Delete(ctx context.Context, id string) error {
+	return d.moveTrash(ctx, id, true)
+}
+
+// Restore moves the entry's object out of the trash.
+func (d *S3Driver) Restore(ctx context.Context, id string) error {
+	return d.moveTrash(ctx, id, false)
+}
+
+// moveTrash copies the entry's object into or out of the trash, updates the index and then removes
+// the previous object.
+func (d *S3Driver) moveTrash(ctx context.Context, id string, trash bool) error {
+	d.indexLock.Lock()
+	current, _, err := d.readIndex(ctx)
+	d.indexLock.Unlock()
+	if err != nil {
+		return err
+	}
+	entry, ok := current[id]
+	if !ok || entry.trashed() == trash {
+		if trash {
+			return fmt.Errorf("The entry %s doesn't exist", id)
+		}
+		return fmt.Errorf("The entry %s isn't in the trash", id)
+	}
+
+	from := d.store.prefix + entry.File
+	to := d.object(id)
+	if trash {
+		to = d.store.prefix + s3TrashPrefix + strings.TrimPrefix(to, d.store.prefix+s3EntriesPrefix)
+	}
+	cyphertext, _, err := d.store.client.get(ctx, from, "")
+	if err != nil {
+		return err
+	}
+	if _, err = d.store.client.put(ctx, to, cyphertext, ""); err != nil {
+		return err
+	}
+	err = d.updateIndex(ctx, func(current index) {
+		moved := current[id]
+		moved.File = strings.TrimPrefix(to, d.store.prefix)
+		moved.Deleted = nil
+		if trash {
+			now := time.Now()
+			moved.Deleted = &now
+		}
+		current[id] = moved
+	})
+	if err != nil {
+		return err
+	}
+	if err = d.store.client.delete(ctx, from); err != nil {
+		log.Printf("Failed to remove %s after moving it because %s", from, err)
+	}
+	return nil
+}
+
+// Trashed returns the entries in the trash.
+func (d *S3Driver) Trashed(ctx context.Context) ([]ejrnl.Trashed, error) {
+	d.indexLock.Lock()
+	defer d.indexLock.Unlock()
+	current, _, err := d.readIndex(ctx)
+	if err != nil {
+		return nil, err
+	}
+	trashed := []ejrnl.Trashed{}
+	for id, entry := range current {
+		if entry.trashed() {
+			trashed = append(trashed, ejrnl.Trashed{Summary: entry.summary(id), Deleted: *entry.Deleted})
+		}
+	}
+	return trashed, nil
+}
+
+// Purge permanently removes the entries that were moved to the trash before deletedBefore. The index
+// is only changed if there's something to remove.
+func (d *S3Driver) Purge(ctx context.Context, deletedBefore time.Time) (int, error) {
+	expired := func(entry indexEntry) bool {
+		return entry.trashed() && entry.Deleted.Before(deletedBefore)
+	}
+	d.indexLock.Lock()
+	current, _, err := d.readIndex(ctx)
+	d.indexLock.Unlock()
+	if err != nil {
+		return 0, err
+	}
+	any := false
+	for _, entry := range current {
+		any = any || expired(entry)
+	}
+	if !any {
+		return 0, nil
+	}
+
+	objects := []string{}
+	err = d.updateIndex(ctx, func(current index) {
+		objects = objects[:0]
+		for id, entry := range current {
+			if expired(entry) {
+				objects = append(objects, d.store.prefix+entry.File)
+				delete(current, id)
+			}
+		}
+	})
+	if err != nil {
+		return 0, err
+	}
+	for _, object := range objects {
+		if err = d.store.client.delete(ctx, object); err != nil {
+			log.Printf("Failed to remove %s from the trash because %s", object, err)
+		}
+	}
+	return len(objects), nil
+}
+
+// Close wipes the journal's key from memory. The driver can't be used afterwards.
+func (d *S3Driver) Close() error {
+	if d.fileKey != nil {
+		d.fileKey.Close()
+	}
+	if d.key == nil {
+		return nil
+	}
+	return d.key.Close()
+}
@@ -0,0 +1,280 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// errPreconditionFailed is returned when a conditional put fails because the object changed.
+var errPreconditionFailed = errors.New("The object was changed by someone else")
+
+// errNotModified is returned when a conditional get finds that the cached copy is current.
+var errNotModified = errors.New("The object hasn't been modified")
+
+// s3Client is just enough of an S3 client for the s3 backend. Requests use path style urls and are
+// signed with AWS signature version 4 so, it works with S3 and with most compatible services.
+type s3Client struct {
+	endpoint  *url.URL
+	region    string
+	bucket    string
+	accessKey string
+	secretKey string
+	client    *http.Client
+	// now is replaced in tests
+	now func() time.Time
+}
+
+func newS3Client(endpoint, region, bucket, accessKey, secretKey string) (*s3Client, error) {
+	parsed, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse the s3 endpoint because %s", err)
+	}
+	if accessKey == "" {
+		accessKey = os.Getenv("AWS_ACCESS_KEY_ID")
+	}
+	if secretKey == "" {
+		secretKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+	}
+	if region == "" {
+		region = "us-east-1"
+	}
+	return &s3Client{
+		endpoint:  parsed,
+		region:    region,
+		bucket:    bucket,
+		accessKey: accessKey,
+		secretKey: secretKey,
+		client:    &http.Client{Timeout: time.Minute},
+		now:       time.Now,
+	}, nil
+}
+
+// s3Error is an unexpected response from the service.
+type s3Error struct {
+	status int
+	body   string
+}
+
+func (e *s3Error) Error() string {
+	return fmt.Sprintf("S3 responded with %d %s", e.status, e.body)
+}
+
+// uriEncode encodes a string the way signature version 4 expects.
+func uriEncode(value string, encodeSlash bool) string {
+	var encoded bytes.Buffer
+	for _, b := range []byte(value) {
+		switch {
+		case 'a' <= b && b <= 'z', 'A' <= b && b <= 'Z', '0' <= b && b <= '9', b == '-', b == '.', b == '_', b == '~':
+			encoded.WriteByte(b)
+		case b == '/' && !encodeSlash:
+			encoded.WriteByte(b)
+		default:
+			fmt.Fprintf(&encoded, "%%%02X", b)
+		}
+	}
+	return encoded.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// sign adds the signature version 4 headers to the request. Every header that is already set is
+// signed.
+func (c *s3Client) sign(req *http.Request, payloadHash string) {
+	now := c.now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", payloadHash)
+
+	headers := map[string]string{"host": req.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := []string{}
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders bytes.Buffer
+	for _, name := range names {
+		fmt.Fprintf(&canonicalHeaders, "%s:%s\n", name, headers[name])
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	query := req.URL.Query()
+	keys := []string{}
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	parameters := []string{}
+	for _, key := range keys {
+		for _, value := range query[key] {
+			parameters = append(parameters, fmt.Sprintf("%s=%s", uriEncode(key, true), uriEncode(value, true)))
+		}
+	}
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		uriEncode(req.URL.Path, false),
+		strings.Join(parameters, "&"),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := fmt.Sprintf("%s/%s/s3/aws4_request", day, c.region)
+	stringToSign := strings.Join([]string{"AWS4-HMAC-SHA256", amzDate, scope, sha256Hex([]byte(canonicalRequest))}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+c.secretKey), day)
+	key = hmacSHA256(key, c.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		c.accessKey, scope, signedHeaders, signature))
+}
+
+// do sends a signed request for the object and returns the response's body.
+func (c *s3Client) do(ctx context.Context, method, key string, query url.Values, body []byte, headers map[string]string) (*http.Response, []byte, error) {
+	target := *c.endpoint
+	target.Path = strings.TrimSuffix(target.Path, "/") + "/" + c.bucket
+	if key != "" {
+		target.Path += "/" + key
+	}
+	target.RawQuery = query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, method, target.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, nil, err
+	}
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	c.sign(req, sha256Hex(body))
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	return resp, data, err
+}
+
+// get fetches an object. If etag is set and the object still has that etag, errNotModified is
+// returned. Missing objects return os.ErrNotExist.
+func (c *s3Client) get(ctx context.Context, key, etag string) ([]byte, string, error) {
+	headers := map[string]string{}
+	if etag != "" {
+		headers["If-None-Match"] = etag
+	}
+	resp, data, err := c.do(ctx, "GET", key, url.Values{}, nil, headers)
+	if err != nil {
+		return nil, "", err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return data, resp.Header.Get("ETag"), nil
+	case http.StatusNotModified:
+		return nil, etag, errNotModified
+	case http.StatusNotFound:
+		return nil, "", os.ErrNotExist
+	}
+	return nil, "", &s3Error{status: resp.StatusCode, body: string(data)}
+}
+
+// put stores an object and returns its new etag. If etag is "*", the object must not exist. If it's
+// any other value, the object must still have that etag. errPreconditionFailed is returned when the
+// condition isn't met.
+func (c *s3Client) put(ctx context.Context, key string, data []byte, etag string) (string, error) {
+	headers := map[string]string{}
+	if etag == "*" {
+		headers["If-None-Match"] = etag
+	} else if etag != "" {
+		headers["If-Match"] = etag
+	}
+	resp, body, err := c.do(ctx, "PUT", key, url.Values{}, data, headers)
+	if err != nil {
+		return "", err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return resp.Header.Get("ETag"), nil
+	case http.StatusPreconditionFailed, http.StatusConflict:
+		return "", errPreconditionFailed
+	}
+	return "", &s3Error{status: resp.StatusCode, body: string(body)}
+}
+
+func (c *s3Client) delete(ctx context.Context, key string) error {
+	resp, body, err := c.do(ctx, "DELETE", key, url.Values{}, nil, nil)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		return &s3Error{status: resp.StatusCode, body: string(body)}
+	}
+	return nil
+}
+
+// s3Listing is the response to ListObjectsV2.
+type s3Listing struct {
+	Contents []struct {
+		Key string
+	}
+	IsTruncated           bool
+	NextContinuationToken string
+}
+
+// list returns the names of all of the objects that start with the prefix.
+func (c *s3Client) list(ctx context.Context, prefix string) ([]string, error) {
+	keys := []string{}
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		resp, body, err := c.do(ctx, "GET", "", query, nil, nil)
+		if err != nil {
+			return keys, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			return keys, &s3Error{status: resp.StatusCode, body: string(body)}
+		}
+		listing := s3Listing{}
+		if err = xml.Unmarshal(body, &listing); err != nil {
+			return keys, fmt.Errorf("Failed to parse the bucket listing because %s", err)
+		}
+		for _, object := range listing.Contents {
+			keys = append(keys, object.Key)
+		}
+		if !listing.IsTruncated || listing.NextContinuationToken == "" {
+			return keys, nil
+		}
+		token = listing.NextContinuationToken
+	}
+}
@@ -0,0 +1,516 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/satori/go.uuid"
+
+	"github.com/btobolaski/ejrnl"
+	"github.com/btobolaski/ejrnl/compression"
+	"github.com/btobolaski/ejrnl/crypto"
+)
+
+// sqliteFile is the name of the database in the journal's directory. The key slots are stored next
+// to it, the same as they are for the files backend.
+const sqliteFile = "journal.db"
+
+// sqliteCheck is encrypted and stored in the database so that an incorrect key is detected when the
+// journal is opened.
+const sqliteCheck = "ejrnl"
+
+// Every column is either encrypted or a keyed hash. Listing the journal only decrypts each entry's
+// header, which holds its id and date, and tags can be looked up by their hash.
+var sqliteSchema = []string{
+	"CREATE TABLE IF NOT EXISTS meta (name TEXT PRIMARY KEY, value BLOB NOT NULL)",
+	"CREATE TABLE IF NOT EXISTS entries (id BLOB PRIMARY KEY, header BLOB NOT NULL, data BLOB NOT NULL)",
+	"CREATE TABLE IF NOT EXISTS tags (entry BLOB NOT NULL, tag BLOB NOT NULL, PRIMARY KEY (entry, tag))",
+	sqliteTrashSchema,
+}
+
+// sqliteTrashSchema holds deleted entries. It's also created when a database from before the trash
+// existed is opened.
+const sqliteTrashSchema = "CREATE TABLE IF NOT EXISTS trash (id BLOB PRIMARY KEY, header BLOB NOT NULL, data BLOB NOT NULL)"
+
+// sqliteHeader is the part of an entry that is needed to list the journal.
+type sqliteHeader struct {
+	Id    string
+	Date  time.Time
+	Zone  string   `json:",omitempty"`
+	Title string   `json:",omitempty"`
+	Links []string `json:",omitempty"`
+	// Deleted is when the entry was moved to the trash
+	Deleted *time.Time `json:",omitempty"`
+}
+
+type SQLiteDriver struct {
+	directory   string
+	db          *sql.DB
+	lock        *sync.Mutex
+	key         *crypto.Secret
+	blindKey    *crypto.Secret
+	compression compression.Options
+	// secureDelete makes SQLite overwrite deleted content
+	secureDelete bool
+	// newSlot is the key slot that is written when a new journal is inited
+	newSlot *keySlot
+}
+
+// NewSQLiteDriverWithPassword creates a SQLite backed driver from the specified config and password.
+// The caller retains ownership of the password.
+func NewSQLiteDriverWithPassword(conf ejrnl.Config, password *crypto.Secret) (*SQLiteDriver, error) {
+	driver, err := unlock(conf, password, func(conf ejrnl.Config, key *crypto.Secret) (keyed, error) {
+		return NewSQLiteDriverWithKey(conf, key)
+	})
+	if d, ok := driver.(*SQLiteDriver); ok {
+		return d, err
+	}
+	return &SQLiteDriver{}, err
+}
+
+// NewSQLiteDriverWithKey creates a SQLite backed driver from the journal's key. The driver takes
+// ownership of the key.
+func NewSQLiteDriverWithKey(conf ejrnl.Config, key *crypto.Secret) (*SQLiteDriver, error) {
+	driver := &SQLiteDriver{
+		lock:         &sync.Mutex{},
+		key:          key,
+		blindKey:     subkey(key, "ejrnl sqlite"),
+		compression:  compression.Options{Codec: conf.Compression},
+		secureDelete: conf.SecureDelete,
+	}
+
+	if _, err := compression.Lookup(conf.Compression); err != nil {
+		return driver, err
+	}
+	padding, err := compression.ParsePadding(conf.Padding)
+	if err != nil {
+		return driver, err
+	}
+	driver.compression.Padding = padding
+
+	driver.directory, err = ExpandPath(conf.StorageDirectory)
+	if err != nil {
+		return driver, err
+	}
+	if _, err = os.Stat(driver.path()); os.IsNotExist(err) {
+		return driver, &NeedsInit{msg: "the database doesn't exist"}
+	}
+	if err = driver.open(); err != nil {
+		return driver, err
+	}
+
+	var check []byte
+	err = driver.db.QueryRow("SELECT value FROM meta WHERE name = 'check'").Scan(&check)
+	if err != nil {
+		return driver, fmt.Errorf("Failed to read the database because %s", err)
+	}
+	plaintext, err := crypto.Decrypt(check, key.Bytes())
+	if err != nil {
+		return driver, err
+	}
+	if string(plaintext) != sqliteCheck {
+		return driver, errors.New("The database's check value is incorrect")
+	}
+	if _, err = driver.db.Exec(sqliteTrashSchema); err != nil {
+		return driver, fmt.Errorf("Failed to create the trash because %s", err)
+	}
+	return driver, nil
+}
+
+func (d *SQLiteDriver) path() string {
+	return fmt.Sprintf("%s/%s", d.directory, sqliteFile)
+}
+
+// open opens the database. Writes are serialized through a single connection.
+func (d *SQLiteDriver) open() error {
+	dsn := fmt.Sprintf("file:%s?_busy_timeout=5000", d.path())
+	if d.secureDelete {
+		dsn += "&_secure_delete=on"
+	}
+	db, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		return fmt.Errorf("Failed to open the database because %s", err)
+	}
+	db.SetMaxOpenConns(1)
+	d.db = db
+	return nil
+}
+
+// Key returns the key that the journal is encrypted with. It is wiped when the driver is closed.
+func (d *SQLiteDriver) Key() *crypto.Secret {
+	return d.key
+}
+
+// setKey replaces the driver's key with a new data key whose key slot is written by Init.
+func (d *SQLiteDriver) setKey(key *crypto.Secret, slot *keySlot) {
+	d.Close()
+	d.key = key
+	d.blindKey = subkey(key, "ejrnl sqlite")
+	d.newSlot = slot
+}
+
+// HasKeySlots returns whether the journal's key is wrapped. Only journals with wrapped keys can be
+// destroyed.
+func (d *SQLiteDriver) HasKeySlots() bool {
+	return hasKeySlots(d.directory)
+}
+
+func (d *SQLiteDriver) Write(entry ejrnl.Entry) error {
+	return d.WriteBatchContext(context.Background(), []ejrnl.Entry{entry})
+}
+
+func (d *SQLiteDriver) WriteContext(ctx context.Context, entry ejrnl.Entry) error {
+	return d.WriteBatchContext(ctx, []ejrnl.Entry{entry})
+}
+
+// WriteBatch writes several entries in a single transaction.
+func (d *SQLiteDriver) WriteBatch(entries []ejrnl.Entry) error {
+	return d.WriteBatchContext(context.Background(), entries)
+}
+
+// WriteBatchContext writes several entries in a single transaction, which is rolled back if the
+// context is canceled.
+func (d *SQLiteDriver) WriteBatchContext(ctx context.Context, entries []ejrnl.Entry) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if err = d.write(ctx, tx, entry); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (d *SQLiteDriver) write(ctx context.Context, tx *sql.Tx, entry ejrnl.Entry) error {
+	entry = ejrnl.NormalizeDate(entry)
+	if entry.Id == "" {
+		entry.Id = fmt.Sprintf("%s", uuid.NewV4())
+	}
+
+	plaintext, err := json.Marshal(sqliteHeader{Id: entry.Id, Date: *entry.Date, Zone: entry.Zone, Title: entry.Title, Links: ejrnl.Links(entry.Body)})
+	if err != nil {
+		return err
+	}
+	header, err := crypto.Encrypt(plaintext, d.key.Bytes())
+	if err != nil {
+		return err
+	}
+	plaintext, err = json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	data, err := d.compression.CompressAndEncrypt(plaintext, d.key.Bytes())
+	if err != nil {
+		return err
+	}
+
+	id := blind(d.blindKey, entry.Id)
+	_, err = tx.ExecContext(ctx, "INSERT OR REPLACE INTO entries (id, header, data) VALUES (?, ?, ?)", id, header, data)
+	if err != nil {
+		return err
+	}
+	if _, err = tx.ExecContext(ctx, "DELETE FROM tags WHERE entry = ?", id); err != nil {
+		return err
+	}
+	// Entries that are written again are taken out of the trash
+	if _, err = tx.ExecContext(ctx, "DELETE FROM trash WHERE id = ?", id); err != nil {
+		return err
+	}
+	for _, tag := range entry.Tags {
+		_, err = tx.ExecContext(ctx, "INSERT OR IGNORE INTO tags (entry, tag) VALUES (?, ?)", id, blind(d.blindKey, "tag:"+tag))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *SQLiteDriver) Read(id string) (ejrnl.Entry, error) {
+	return d.ReadContext(context.Background(), id)
+}
+
+func (d *SQLiteDriver) ReadContext(ctx context.Context, id string) (ejrnl.Entry, error) {
+	var data []byte
+	err := d.db.QueryRowContext(ctx, "SELECT data FROM entries WHERE id = ?", blind(d.blindKey, id)).Scan(&data)
+	if err == sql.ErrNoRows {
+		return ejrnl.Entry{}, fmt.Errorf("The entry %s doesn't exist", id)
+	} else if err != nil {
+		return ejrnl.Entry{}, err
+	}
+
+	plaintext, err := d.compression.DecryptAndDecompress(data, d.key.Bytes())
+	if err != nil {
+		return ejrnl.Entry{}, err
+	}
+	entry := &ejrnl.Entry{}
+	err = json.Unmarshal(plaintext, entry)
+	return *entry, err
+}
+
+// List returns the index of all the entries stored in the journal
+func (d *SQLiteDriver) List() (map[time.Time]string, error) {
+	return d.ListContext(context.Background())
+}
+
+func (d *SQLiteDriver) ListContext(ctx context.Context) (map[time.Time]string, error) {
+	return d.list(ctx, "SELECT header FROM entries")
+}
+
+// Tagged returns the index of the entries with the tag. Tags are stored as keyed hashes so, they can
+// be looked up without decrypting every entry.
+func (d *SQLiteDriver) Tagged(tag string) (map[time.Time]string, error) {
+	return d.list(context.Background(), "SELECT e.header FROM entries e JOIN tags t ON t.entry = e.id WHERE t.tag = ?", blind(d.blindKey, "tag:"+tag))
+}
+
+// list returns the index of the entries returned by the query.
+func (d *SQLiteDriver) list(ctx context.Context, query string, args ...interface{}) (map[time.Time]string, error) {
+	val := make(map[time.Time]string)
+	headers, err := d.headers(ctx, query, args...)
+	for _, header := range headers {
+		val[header.Date.Local()] = header.Id
+	}
+	return val, err
+}
+
+// Summaries returns the id, date, title and links of every entry.
+func (d *SQLiteDriver) Summaries(ctx context.Context) ([]ejrnl.Summary, error) {
+	headers, err := d.headers(ctx, "SELECT header FROM entries")
+	summaries := make([]ejrnl.Summary, len(headers))
+	for i, header := range headers {
+		summaries[i] = header.summary()
+	}
+	return summaries, err
+}
+
+func (d *SQLiteDriver) decryptHeader(cyphertext []byte) (sqliteHeader, error) {
+	header := sqliteHeader{}
+	plaintext, err := crypto.Decrypt(cyphertext, d.key.Bytes())
+	if err != nil {
+		return header, err
+	}
+	err = json.Unmarshal(plaintext, &header)
+	return header, err
+}
+
+func (h sqliteHeader) summary() ejrnl.Summary {
+	return ejrnl.Summary{Id: h.Id, Date: h.Date, Zone: h.Zone, Title: h.Title, Links: h.Links}
+}
+
+// headers decrypts the headers returned by the query.
+func (d *SQLiteDriver) headers(ctx context.Context, query string, args ...interface{}) ([]sqliteHeader, error) {
+	headers := []sqliteHeader{}
+	rows, err := d.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return headers, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var cyphertext []byte
+		if err = rows.Scan(&cyphertext); err != nil {
+			return headers, err
+		}
+		header, err := d.decryptHeader(cyphertext)
+		if err != nil {
+			return headers, err
+		}
+		headers = append(headers, header)
+	}
+	return headers, rows.Err()
+}
+
+// Delete moves the entry into the trash table. Its tags are removed until it's restored.
+func (d *SQLiteDriver) Delete(ctx context.Context, id string) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	blinded := blind(d.blindKey, id)
+	var cyphertext, data []byte
+	err := d.db.QueryRowContext(ctx, "SELECT header, data FROM entries WHERE id = ?", blinded).Scan(&cyphertext, &data)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("The entry %s doesn't exist", id)
+	} else if err != nil {
+		return err
+	}
+	header, err := d.decryptHeader(cyphertext)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	header.Deleted = &now
+	plaintext, err := json.Marshal(header)
+	if err != nil {
+		return err
+	}
+	if cyphertext, err = crypto.Encrypt(plaintext, d.key.Bytes()); err != nil {
+		return err
+	}
+
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	statements := []struct {
+		query string
+		args  []interface{}
+	}{
+		{"INSERT OR REPLACE INTO trash (id, header, data) VALUES (?, ?, ?)", []interface{}{blinded, cyphertext, data}},
+		{"DELETE FROM entries WHERE id = ?", []interface{}{blinded}},
+		{"DELETE FROM tags WHERE entry = ?", []interface{}{blinded}},
+	}
+	for _, statement := range statements {
+		if _, err = tx.ExecContext(ctx, statement.query, statement.args...); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Restore moves the entry out of the trash table.
+func (d *SQLiteDriver) Restore(ctx context.Context, id string) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	var data []byte
+	err := d.db.QueryRowContext(ctx, "SELECT data FROM trash WHERE id = ?", blind(d.blindKey, id)).Scan(&data)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("The entry %s isn't in the trash", id)
+	} else if err != nil {
+		return err
+	}
+	plaintext, err := d.compression.DecryptAndDecompress(data, d.key.Bytes())
+	if err != nil {
+		return err
+	}
+	entry := ejrnl.Entry{}
+	if err = json.Unmarshal(plaintext, &entry); err != nil {
+		return err
+	}
+
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err = d.write(ctx, tx, entry); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// Trashed returns the entries in the trash.
+func (d *SQLiteDriver) Trashed(ctx context.Context) ([]ejrnl.Trashed, error) {
+	headers, err := d.headers(ctx, "SELECT header FROM trash")
+	trashed := make([]ejrnl.Trashed, 0, len(headers))
+	for _, header := range headers {
+		if header.Deleted != nil {
+			trashed = append(trashed, ejrnl.Trashed{Summary: header.summary(), Deleted: *header.Deleted})
+		}
+	}
+	return trashed, err
+}
+
+// Purge permanently removes the entries that were moved to the trash before deletedBefore.
+func (d *SQLiteDriver) Purge(ctx context.Context, deletedBefore time.Time) (int, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	rows, err := d.db.QueryContext(ctx, "SELECT id, header FROM trash")
+	if err != nil {
+		return 0, err
+	}
+	expired := [][]byte{}
+	for rows.Next() {
+		var id, cyphertext []byte
+		if err = rows.Scan(&id, &cyphertext); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		header, err := d.decryptHeader(cyphertext)
+		if err != nil {
+			rows.Close()
+			return 0, err
+		}
+		if header.Deleted != nil && header.Deleted.Before(deletedBefore) {
+			expired = append(expired, id)
+		}
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil || len(expired) == 0 {
+		return 0, err
+	}
+
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	for _, id := range expired {
+		if _, err = tx.ExecContext(ctx, "DELETE FROM trash WHERE id = ?", id); err != nil {
+			tx.Rollback()
+			return 0, err
+		}
+	}
+	return len(expired), tx.Commit()
+}
+
+// Init creates the new journal
+func (d *SQLiteDriver) Init() error {
+	return d.InitContext(context.Background())
+}
+
+func (d *SQLiteDriver) InitContext(ctx context.Context) error {
+	if _, err := os.Stat(d.directory); os.IsNotExist(err) {
+		os.MkdirAll(d.directory, 0700)
+	}
+
+	if d.newSlot != nil {
+		err := directoryStore(d.directory).writeKeySlots(keySlots{Version: 1, Slots: []keySlot{*d.newSlot}})
+		if err != nil {
+			return fmt.Errorf("Failed to write the key slots because %s", err)
+		}
+		d.newSlot = nil
+	}
+
+	if d.db == nil {
+		if err := d.open(); err != nil {
+			return err
+		}
+	}
+	for _, statement := range sqliteSchema {
+		if _, err := d.db.ExecContext(ctx, statement); err != nil {
+			return fmt.Errorf("Failed to create the database because %s", err)
+		}
+	}
+	check, err := crypto.Encrypt([]byte(sqliteCheck), d.key.Bytes())
+	if err != nil {
+		return err
+	}
+	_, err = d.db.ExecContext(ctx, "INSERT OR REPLACE INTO meta (name, value) VALUES ('check', ?)", check)
+	return err
+}
+
+// Close closes the database and wipes the journal's key from memory. The driver can't be used
+// afterwards.
+func (d *SQLiteDriver) Close() error {
+	if d.db != nil {
+		d.db.Close()
+		d.db = nil
+	}
+	if d.blindKey != nil {
+		d.blindKey.Close()
+	}
+	if d.key == nil {
+		return nil
+	}
+	return d.key.Close()
+}
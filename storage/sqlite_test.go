@@ -0,0 +1,105 @@
+package storage
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/btobolaski/ejrnl"
+	"github.com/btobolaski/ejrnl/crypto"
+	"github.com/btobolaski/ejrnl/storage/drivertest"
+)
+
+func secret(password string) *crypto.Secret {
+	return crypto.SecretFromBytes([]byte(password))
+}
+
+// openInit opens and inits a new journal with any of the backends.
+func openInit(t *testing.T, conf ejrnl.Config) Journal {
+	d, err := Open(conf, secret("password"))
+	if _, ok := err.(*NeedsInit); !ok {
+		t.Fatalf("Error wasn't NeedsInit %#v", err)
+	}
+	if err = d.Init(); err != nil {
+		t.Fatalf("Failed to init the journal because %s", err)
+	}
+	return d
+}
+
+func sqliteConfig(t *testing.T) ejrnl.Config {
+	directory, err := ioutil.TempDir("", "ejrnl-sqlite")
+	if err != nil {
+		t.Fatalf("Failed to create a directory because %s", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(directory) })
+	return ejrnl.Config{StorageDirectory: directory, Salt: makeSalt(32), Pow: 12, Backend: SQLiteBackend}
+}
+
+func TestSQLiteConformance(t *testing.T) {
+	drivertest.Run(t, func(t *testing.T) ejrnl.Driver {
+		return openInit(t, sqliteConfig(t))
+	})
+}
+
+func TestSQLiteReopen(t *testing.T) {
+	conf := sqliteConfig(t)
+	d := openInit(t, conf)
+	if !d.HasKeySlots() {
+		t.Error("A new journal didn't get a wrapped key")
+	}
+	date := time.Date(2016, 12, 24, 0, 32, 58, 0, time.UTC)
+	if err := d.Write(ejrnl.Entry{Id: "1", Date: &date, Body: "persisted"}); err != nil {
+		t.Fatalf("Failed to write entry because %s", err)
+	}
+	d.Close()
+
+	if _, err := Open(conf, secret("incorrect")); err == nil {
+		t.Error("The journal was opened with an incorrect password")
+	}
+
+	d, err := Open(conf, secret("password"))
+	if err != nil {
+		t.Fatalf("Failed to reopen the journal because %s", err)
+	}
+	defer d.Close()
+	entry, err := d.Read("1")
+	if err != nil || entry.Body != "persisted" {
+		t.Errorf("Failed to read entry after reopening, got %#v %v", entry, err)
+	}
+}
+
+func TestSQLiteTagged(t *testing.T) {
+	conf := sqliteConfig(t)
+	d := openInit(t, conf).(*SQLiteDriver)
+	defer d.Close()
+
+	for id, tags := range map[string][]string{"1": {"work"}, "2": {"home", "work"}, "3": nil} {
+		if err := d.Write(ejrnl.Entry{Id: id, Body: id, Tags: tags}); err != nil {
+			t.Fatalf("Failed to write entry because %s", err)
+		}
+	}
+	if err := d.Write(ejrnl.Entry{Id: "1", Body: "retagged", Tags: []string{"home"}}); err != nil {
+		t.Fatalf("Failed to rewrite entry because %s", err)
+	}
+
+	tagged, err := d.Tagged("work")
+	if err != nil {
+		t.Fatalf("Failed to look up tag because %s", err)
+	}
+	if len(tagged) != 1 {
+		t.Errorf("Expected only entry 2 to be tagged work, got %v", tagged)
+	}
+	for _, id := range tagged {
+		if id != "2" {
+			t.Errorf("Expected only entry 2 to be tagged work, got %v", tagged)
+		}
+	}
+}
+
+func TestUnknownBackend(t *testing.T) {
+	conf := ejrnl.Config{StorageDirectory: "./unknown-backend", Salt: makeSalt(32), Pow: 12, Backend: "tape"}
+	if _, err := Open(conf, secret("password")); err == nil {
+		t.Error("Expected an unknown backend to fail")
+	}
+}
@@ -1,12 +1,13 @@
 package storage
 
 import (
-	"encoding/base64"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
+	mathrand "math/rand"
 	"os"
 	"os/user"
 	"regexp"
@@ -22,29 +23,81 @@ import (
 )
 
 type Driver struct {
-	directory string
-	key       []byte
-	indexLock *sync.RWMutex
+	directory   string
+	key         *crypto.Secret
+	indexLock   *sync.RWMutex
+	cache       *indexCache
+	compression compression.Options
+	fileKey     *crypto.Secret
+	// hideActivity normalizes modification times and rewrites coverWrites random entries on every
+	// write
+	hideActivity bool
+	coverWrites  int
+	// secureDelete shreds superseded files instead of just removing them
+	secureDelete bool
+	// useGit commits every change to git
+	useGit    bool
+	gitRemote string
+	// newSlot is the key slot that is written when a new journal is inited
+	newSlot *keySlot
 }
 
-// NewDriver creates a new storage driver from the specified config and password.
+// NewDriver creates a new storage driver from the specified config and password. The password
+// can't be wiped from memory so, NewDriverWithPassword should be preferred.
 func NewDriver(conf ejrnl.Config, password string) (*Driver, error) {
-	salt, err := base64.StdEncoding.DecodeString(conf.Salt)
-	if err != nil {
-		return &Driver{}, fmt.Errorf("Failed to decode salt because %s", err)
-	}
+	secret := crypto.SecretFromBytes([]byte(password))
+	defer secret.Close()
+	return NewDriverWithPassword(conf, secret)
+}
 
-	key, err := crypto.GenerateKey([]byte(password), salt, conf.Pow)
-	if err != nil {
-		return &Driver{}, err
+// NewDriverWithPassword creates a new storage driver from the specified config and password. The
+// caller retains ownership of the password.
+func NewDriverWithPassword(conf ejrnl.Config, password *crypto.Secret) (*Driver, error) {
+	driver, err := unlock(conf, password, func(conf ejrnl.Config, key *crypto.Secret) (keyed, error) {
+		return NewDriverWithKey(conf, key)
+	})
+	if _, ok := err.(*NeedsInit); err != nil && !ok && conf.Git {
+		// Key slots aren't committed so, a clone of the journal doesn't have them
+		if directory, expandErr := ExpandPath(conf.StorageDirectory); expandErr == nil && !hasKeySlots(directory) {
+			err = fmt.Errorf("%s. The journal doesn't have a %s file, which isn't stored in git. If it was cloned, "+
+				"copy %s from another copy of the journal or restore the key with ejrnl recover-key", err, keySlotsFile, keySlotsFile)
+		}
 	}
+	if d, ok := driver.(*Driver); ok {
+		return d, err
+	}
+	return &Driver{}, err
+}
 
+// NewDriverWithKey creates a new storage driver from a key that was previously derived from the
+// journal's password, such as one restored from a backup. The driver takes ownership of the key.
+func NewDriverWithKey(conf ejrnl.Config, key *crypto.Secret) (*Driver, error) {
 	driver := &Driver{
-		directory: conf.StorageDirectory,
-		key:       key,
-		indexLock: &sync.RWMutex{},
+		directory:    conf.StorageDirectory,
+		key:          key,
+		fileKey:      filenameKey(key),
+		indexLock:    &sync.RWMutex{},
+		cache:        &indexCache{},
+		hideActivity: conf.HideActivity,
+		coverWrites:  conf.CoverWrites,
+		secureDelete: conf.SecureDelete,
+		useGit:       conf.Git,
+		gitRemote:    conf.GitRemote,
+		compression: compression.Options{
+			Codec:        conf.Compression,
+			Dictionaries: compression.NewDictionaries(),
+		},
 	}
 
+	if _, err := compression.Lookup(conf.Compression); err != nil {
+		return driver, err
+	}
+	padding, err := compression.ParsePadding(conf.Padding)
+	if err != nil {
+		return driver, err
+	}
+	driver.compression.Padding = padding
+
 	err = driver.checkExists()
 	if err != nil {
 		return driver, err
@@ -53,22 +106,66 @@ func NewDriver(conf ejrnl.Config, password string) (*Driver, error) {
 	return driver, nil
 }
 
-// checkExists checks whether the journal already exists
-func (d *Driver) checkExists() error {
+// Key returns the key that the journal is encrypted with. It is wiped when the driver is closed.
+func (d *Driver) Key() *crypto.Secret {
+	return d.key
+}
+
+// setKey replaces the driver's key with a new data key whose key slot is written by Init.
+func (d *Driver) setKey(key *crypto.Secret, slot *keySlot) {
+	d.Close()
+	d.key = key
+	d.fileKey = filenameKey(key)
+	d.newSlot = slot
+}
+
+// ExpandPath replaces ~ with the current user's home directory.
+func ExpandPath(path string) (string, error) {
 	current, err := user.Current()
 	if err != nil {
-		return fmt.Errorf("Can't retrieve the current user's information because %s", err)
+		return path, fmt.Errorf("Can't retrieve the current user's information because %s", err)
 	}
+	return strings.Replace(path, "~", current.HomeDir, -1), nil
+}
 
-	path := strings.Replace(d.directory, "~", current.HomeDir, -1)
+// hasEntries returns whether the directory contains any encrypted files, including packs and
+// trashed entries.
+func hasEntries(directory string) bool {
+	for _, subdirectory := range []string{"", packDirectory, trashDirectory} {
+		files, err := ioutil.ReadDir(fmt.Sprintf("%s/%s", directory, subdirectory))
+		if err != nil {
+			continue
+		}
+		for _, file := range files {
+			if strings.HasSuffix(file.Name(), ".cpt") || strings.HasSuffix(file.Name(), packExtension) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// checkExists checks whether the journal already exists
+func (d *Driver) checkExists() error {
+	path, err := ExpandPath(d.directory)
+	if err != nil {
+		return err
+	}
 	d.directory = path
 
-	if _, err := os.Stat(fmt.Sprintf("%s/index.cpt", path)); os.IsNotExist(err) {
+	if err = d.loadDictionaries(); err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(fmt.Sprintf("%s/%s", path, indexFile)); os.IsNotExist(err) {
 		return &NeedsInit{msg: "the index doesn't exist"}
 	}
 
-	d.indexLock.RLock()
-	defer d.indexLock.RUnlock()
+	unlock, err := d.lockJournal(false)
+	if err != nil {
+		return err
+	}
+	defer unlock()
 
 	_, err = d.readIndex()
 
@@ -76,57 +173,156 @@ func (d *Driver) checkExists() error {
 }
 
 func (d *Driver) Write(entry ejrnl.Entry) error {
-	if entry.Date == nil {
-		now := time.Now()
-		entry.Date = &now
+	return d.WriteBatchContext(context.Background(), []ejrnl.Entry{entry})
+}
+
+func (d *Driver) WriteContext(ctx context.Context, entry ejrnl.Entry) error {
+	return d.WriteBatchContext(ctx, []ejrnl.Entry{entry})
+}
+
+// WriteBatch writes several entries at once. The index is only appended to once and, when activity
+// is hidden, the entries are written in a random order.
+func (d *Driver) WriteBatch(entries []ejrnl.Entry) error {
+	return d.WriteBatchContext(context.Background(), entries)
+}
+
+// WriteBatchContext is WriteBatch but it stops writing entries when the context is canceled. The
+// entries that were already written are still added to the index.
+func (d *Driver) WriteBatchContext(ctx context.Context, entries []ejrnl.Entry) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if d.hideActivity {
+		shuffled := make([]ejrnl.Entry, len(entries))
+		for i, j := range mathrand.Perm(len(entries)) {
+			shuffled[i] = entries[j]
+		}
+		entries = shuffled
+	}
+
+	written := make(index)
+	for _, entry := range entries {
+		if ctx.Err() != nil {
+			break
+		}
+		entry = ejrnl.NormalizeDate(entry)
+		if entry.Id == "" {
+			entry.Id = fmt.Sprintf("%s", uuid.NewV4())
+		}
+		plaintext, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		cyphertext, err := d.compression.CompressAndEncrypt(plaintext, d.key.Bytes())
+		if err != nil {
+			return err
+		}
+
+		file := d.filename(entry.Id)
+		err = d.writeFile(file, cyphertext)
+		if err != nil {
+			return err
+		}
+		written[entry.Id] = newIndexEntry(entry, file)
 	}
-	if entry.Id == "" {
-		entry.Id = fmt.Sprintf("%s", uuid.NewV4())
+
+	if len(written) == 0 {
+		return ctx.Err()
 	}
-	plaintext, err := json.Marshal(entry)
+	unlock, err := d.lockJournal(true)
 	if err != nil {
 		return err
 	}
-	cyphertext, err := compression.CompressAndEncrypt(plaintext, d.key)
+	defer unlock()
+	current, err := d.readIndex()
 	if err != nil {
 		return err
 	}
+	// Entries that are written again are taken out of the trash
+	stale := []string{}
+	for id := range written {
+		if previous, ok := current[id]; ok && previous.trashed() {
+			stale = append(stale, previous.File)
+		}
+	}
+	if err = d.appendIndex(indexRecord{Set: written}); err != nil {
+		return err
+	}
+	for _, name := range stale {
+		if err = d.remove(name); err != nil && !os.IsNotExist(err) {
+			log.Printf("Failed to remove %s from the trash because %s", name, err)
+		}
+	}
 
-	var previousDate time.Time
-
-	if _, err = os.Stat(fmt.Sprintf("%s/%s.cpt", d.directory, entry.Id)); err == nil {
-		old, err := d.Read(entry.Id)
-		if err != nil {
-			log.Printf("Failed to read previous entry because %s", err)
-		} else {
-			previousDate = *old.Date
+	// Entries written before filenames were hashed are moved to their new name
+	for id, entry := range written {
+		legacy, ok := legacyFile(id)
+		if !ok || legacy == entry.File {
+			continue
+		}
+		err = d.remove(legacy)
+		if err != nil && !os.IsNotExist(err) {
+			log.Printf("Failed to remove the previous copy of %s because %s", id, err)
 		}
 	}
 
-	err = ioutil.WriteFile(fmt.Sprintf("%s/%s.cpt", d.directory, entry.Id), cyphertext, 0600)
-	if err != nil {
+	if d.hideActivity {
+		d.rewriteRandom(written)
+		d.normalizeTime("")
+	}
+	if err = d.commit(); err != nil {
 		return err
 	}
+	return ctx.Err()
+}
 
-	d.indexLock.Lock()
-	defer d.indexLock.Unlock()
-	index, err := d.readIndex()
-	if err != nil {
-		return err
+func (d *Driver) ReadContext(ctx context.Context, id string) (ejrnl.Entry, error) {
+	if err := ctx.Err(); err != nil {
+		return ejrnl.Entry{}, err
 	}
-	delete(index, previousDate)
-	index[*entry.Date] = entry.Id
-	err = d.writeIndex(index)
-	return err
+	return d.Read(id)
 }
 
+// Read reads the entry from its own file if it has one and otherwise from the pack that it's in.
 func (d *Driver) Read(id string) (ejrnl.Entry, error) {
-	bytes, err := ioutil.ReadFile(fmt.Sprintf("%s/%s.cpt", d.directory, id))
+	entry, err := d.readFile(d.filename(id))
+	if !os.IsNotExist(err) {
+		return entry, err
+	}
+
+	unlock, lockErr := d.lockJournal(false)
+	if lockErr != nil {
+		return entry, lockErr
+	}
+	defer unlock()
+	current, indexErr := d.readIndex()
+	if indexErr != nil {
+		return entry, indexErr
+	}
+	if location, ok := current[id]; ok && location.packed() {
+		cyphertext, err := d.readPacked(location)
+		if err != nil {
+			return ejrnl.Entry{}, err
+		}
+		return d.decryptEntry(cyphertext)
+	}
+	if legacy, ok := legacyFile(id); ok {
+		return d.readFile(legacy)
+	}
+	return entry, err
+}
+
+// readFile decrypts the entry stored in the specified file.
+func (d *Driver) readFile(name string) (ejrnl.Entry, error) {
+	bytes, err := ioutil.ReadFile(fmt.Sprintf("%s/%s", d.directory, name))
 	if err != nil {
 		return ejrnl.Entry{}, err
 	}
+	return d.decryptEntry(bytes)
+}
 
-	plaintext, err := compression.DecryptAndDecompress(bytes, d.key)
+func (d *Driver) decryptEntry(bytes []byte) (ejrnl.Entry, error) {
+	plaintext, err := d.decrypt(bytes)
 	if err != nil {
 		return ejrnl.Entry{}, err
 	}
@@ -136,66 +332,80 @@ func (d *Driver) Read(id string) (ejrnl.Entry, error) {
 	return *entry, err
 }
 
+func (d *Driver) ListContext(ctx context.Context) (map[time.Time]string, error) {
+	if err := ctx.Err(); err != nil {
+		return map[time.Time]string{}, err
+	}
+	return d.List()
+}
+
 // List returns the index of all the entries stored in the journal
 func (d *Driver) List() (map[time.Time]string, error) {
-	d.indexLock.RLock()
-	defer d.indexLock.RUnlock()
+	unlock, err := d.lockJournal(false)
+	if err != nil {
+		return map[time.Time]string{}, err
+	}
+	defer unlock()
 	index, err := d.readIndex()
 	if err != nil {
 		return map[time.Time]string{}, err
 	}
 	val := make(map[time.Time]string)
-	for key, value := range index {
-		val[key.Local()] = value
+	for id, entry := range index {
+		if !entry.trashed() {
+			val[entry.Date.Local()] = id
+		}
 	}
 	return val, nil
 }
 
-// readIndex reads the index from the disk. The caller must have at least a read lock on d.indexLock
-func (d *Driver) readIndex() (map[time.Time]string, error) {
-	index := make(map[time.Time]string)
-	cyphertext, err := ioutil.ReadFile(fmt.Sprintf("%s/index.cpt", d.directory))
+// Summaries returns the id, date, title and links of every entry.
+func (d *Driver) Summaries(ctx context.Context) ([]ejrnl.Summary, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	unlock, err := d.lockJournal(false)
 	if err != nil {
-		return index, err
+		return nil, err
 	}
-
-	plaintext, err := compression.DecryptAndDecompress(cyphertext, d.key)
+	defer unlock()
+	index, err := d.readIndex()
 	if err != nil {
-		return index, err
+		return nil, err
 	}
+	return index.summaries(), nil
+}
 
-	p := &index
-	err = json.Unmarshal(plaintext, p)
-	return *p, err
+// Init creates the new journal
+func (d *Driver) Init() error {
+	return d.InitContext(context.Background())
 }
 
-// writeIndex writes an updated index file. Note that the caller must have the a lock on d.indexLock
-func (d *Driver) writeIndex(index map[time.Time]string) error {
-	plaintext, err := json.Marshal(index)
-	if err != nil {
-		return err
+// InitContext creates the new journal. If it's canceled while the index is being recovered, the
+// journal still needs to be inited.
+func (d *Driver) InitContext(ctx context.Context) error {
+	if _, err := os.Stat(d.directory); os.IsNotExist(err) {
+		os.MkdirAll(d.directory, 0700)
 	}
-
-	cyphertext, err := compression.CompressAndEncrypt(plaintext, d.key)
+	unlock, err := d.lockJournal(true)
 	if err != nil {
 		return err
 	}
+	defer unlock()
+	if d.useGit {
+		if err := d.gitInit(); err != nil {
+			return err
+		}
+	}
 
-	err = ioutil.WriteFile(fmt.Sprintf("%s/index.cpt", d.directory), cyphertext, 0600)
-	return err
-}
-
-// Init creates the new journal
-func (d *Driver) Init() error {
-	d.indexLock.Lock()
-	defer d.indexLock.Unlock()
-	if _, err := os.Stat(d.directory); os.IsNotExist(err) {
-		os.MkdirAll(d.directory, 0700)
+	// The new key couldn't read anything that was written since the journal was opened
+	if d.newSlot != nil && hasEntries(d.directory) {
+		return errHasEntries
 	}
 
 	files, err := ioutil.ReadDir(d.directory)
 	if err != nil {
-		return fmt.Errorf("Failed to read directory for journal because %s")
+		return fmt.Errorf("Failed to read directory for journal because %s", err)
 	}
 	previousEntries := []os.FileInfo{}
 	for _, file := range files {
@@ -204,18 +414,25 @@ func (d *Driver) Init() error {
 		}
 	}
 
-	emptyIndex := make(map[time.Time]string)
+	emptyIndex := make(index)
 
 	if len(previousEntries) > 0 {
-		entryReader := make(chan *ejrnl.Entry, len(previousEntries))
+		type recovered struct {
+			entry *ejrnl.Entry
+			file  string
+		}
+		entryReader := make(chan recovered, len(previousEntries))
 		reader := func(f os.FileInfo) {
-			id := strings.Replace(f.Name(), ".cpt", "", 1)
-			entry, err := d.Read(id)
+			if ctx.Err() != nil {
+				entryReader <- recovered{file: f.Name()}
+				return
+			}
+			entry, err := d.readFile(f.Name())
 			if err != nil {
 				log.Printf("Failed to recover %s because %s", f.Name(), err)
-				entryReader <- nil
+				entryReader <- recovered{file: f.Name()}
 			} else {
-				entryReader <- &entry
+				entryReader <- recovered{entry: &entry, file: f.Name()}
 			}
 		}
 		for _, file := range previousEntries {
@@ -228,15 +445,17 @@ func (d *Driver) Init() error {
 
 		for complete < len(previousEntries) {
 			select {
-			case entry := <-entryReader:
+			case result := <-entryReader:
 				complete++
-				if entry == nil {
+				if result.entry == nil {
 					failed++
 				} else {
-					emptyIndex[*entry.Date] = entry.Id
+					emptyIndex[result.entry.Id] = newIndexEntry(*result.entry, result.file)
 				}
 			case <-timer.C:
 				return errors.New("Timed out waiting for recovery to finish")
+			case <-ctx.Done():
+				return ctx.Err()
 			}
 		}
 		if failed > 0 {
@@ -244,5 +463,47 @@ func (d *Driver) Init() error {
 		}
 	}
 
-	return d.writeIndex(emptyIndex)
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+	if err = d.recoverPacks(emptyIndex); err != nil {
+		return err
+	}
+	if err = d.recoverTrash(ctx, emptyIndex); err != nil {
+		return err
+	}
+	if d.newSlot != nil {
+		err := directoryStore(d.directory).writeKeySlots(keySlots{Version: 1, Slots: []keySlot{*d.newSlot}})
+		if err != nil {
+			return fmt.Errorf("Failed to write the key slots because %s", err)
+		}
+		d.newSlot = nil
+		if d.hideActivity {
+			d.normalizeTime(keySlotsFile)
+		}
+	}
+	if err = d.writeIndex(emptyIndex); err != nil {
+		return err
+	}
+	if d.hideActivity {
+		d.normalizeTime("")
+	}
+	return d.commit()
+}
+
+// Close wipes the journal's key and the cached index from memory. The driver can't be used
+// afterwards.
+func (d *Driver) Close() error {
+	if d.cache != nil {
+		d.cache.lock.Lock()
+		d.cache.index = nil
+		d.cache.lock.Unlock()
+	}
+	if d.fileKey != nil {
+		d.fileKey.Close()
+	}
+	if d.key == nil {
+		return nil
+	}
+	return d.key.Close()
 }
@@ -0,0 +1,224 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/btobolaski/ejrnl"
+)
+
+// Deleted entries are moved into the trash directory, still encrypted, and marked as deleted in the
+// index. They're hidden from everything except the trash until they're restored or purged.
+const trashDirectory = "trash"
+
+// trashed returns whether the index entry is in the trash.
+func (e indexEntry) trashed() bool {
+	return e.Deleted != nil
+}
+
+// Delete moves the entry into the trash.
+func (d *Driver) Delete(ctx context.Context, id string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	unlock, err := d.lockJournal(true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	current, err := d.readIndex()
+	if err != nil {
+		return err
+	}
+	entry, ok := current[id]
+	if !ok || entry.trashed() {
+		return fmt.Errorf("The entry %s doesn't exist", id)
+	}
+
+	// The entry is copied into the trash and the index updated before the original is removed so
+	// that a failure part way through doesn't lose it
+	var cyphertext []byte
+	if entry.packed() {
+		cyphertext, err = d.readPacked(entry)
+	} else {
+		cyphertext, err = ioutil.ReadFile(fmt.Sprintf("%s/%s", d.directory, entry.File))
+	}
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(fmt.Sprintf("%s/%s", d.directory, trashDirectory), 0700); err != nil {
+		return err
+	}
+	trashed := entry
+	trashed.File = fmt.Sprintf("%s/%s", trashDirectory, d.filename(id))
+	trashed.Offset, trashed.Length = 0, 0
+	now := time.Now()
+	trashed.Deleted = &now
+	if err = d.writeFile(trashed.File, cyphertext); err != nil {
+		return err
+	}
+	if err = d.appendIndex(indexRecord{Set: index{id: trashed}}); err != nil {
+		return err
+	}
+
+	// Packed copies are removed the next time that their pack is repacked
+	if !entry.packed() {
+		if err = d.remove(entry.File); err != nil && !os.IsNotExist(err) {
+			log.Printf("Failed to remove %s after moving it to the trash because %s", entry.File, err)
+		}
+	}
+	if d.hideActivity {
+		d.normalizeTime("")
+		d.normalizeTime(trashDirectory)
+	}
+	return d.commit()
+}
+
+// Restore moves the entry out of the trash.
+func (d *Driver) Restore(ctx context.Context, id string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	unlock, err := d.lockJournal(true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	current, err := d.readIndex()
+	if err != nil {
+		return err
+	}
+	entry, ok := current[id]
+	if !ok || !entry.trashed() {
+		return fmt.Errorf("The entry %s isn't in the trash", id)
+	}
+
+	cyphertext, err := ioutil.ReadFile(fmt.Sprintf("%s/%s", d.directory, entry.File))
+	if err != nil {
+		return err
+	}
+	restored := entry
+	restored.File = d.filename(id)
+	restored.Deleted = nil
+	if err = d.writeFile(restored.File, cyphertext); err != nil {
+		return err
+	}
+	if err = d.appendIndex(indexRecord{Set: index{id: restored}}); err != nil {
+		return err
+	}
+	if err = d.remove(entry.File); err != nil && !os.IsNotExist(err) {
+		log.Printf("Failed to remove %s after restoring it because %s", entry.File, err)
+	}
+	if d.hideActivity {
+		d.normalizeTime("")
+		d.normalizeTime(trashDirectory)
+	}
+	return d.commit()
+}
+
+// Trashed returns the entries in the trash.
+func (d *Driver) Trashed(ctx context.Context) ([]ejrnl.Trashed, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	unlock, err := d.lockJournal(false)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	current, err := d.readIndex()
+	if err != nil {
+		return nil, err
+	}
+	trashed := []ejrnl.Trashed{}
+	for id, entry := range current {
+		if entry.trashed() {
+			trashed = append(trashed, ejrnl.Trashed{Summary: entry.summary(id), Deleted: *entry.Deleted})
+		}
+	}
+	return trashed, nil
+}
+
+// Purge permanently removes the entries that were moved to the trash before deletedBefore. The index
+// is only changed if there's something to remove. The packs are rewritten without the purged entries'
+// packed copies so that recovering the index doesn't bring them back.
+func (d *Driver) Purge(ctx context.Context, deletedBefore time.Time) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	unlock, err := d.lockJournal(true)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+	current, err := d.readIndex()
+	if err != nil {
+		return 0, err
+	}
+	record := indexRecord{}
+	files := []string{}
+	for id, entry := range current {
+		if entry.trashed() && entry.Deleted.Before(deletedBefore) {
+			record.Remove = append(record.Remove, id)
+			files = append(files, entry.File)
+		}
+	}
+	if len(record.Remove) == 0 {
+		return 0, nil
+	}
+	if err = d.appendIndex(record); err != nil {
+		return 0, err
+	}
+	for _, name := range files {
+		if err = d.remove(name); err != nil && !os.IsNotExist(err) {
+			log.Printf("Failed to remove %s from the trash because %s", name, err)
+		}
+	}
+	if d.hideActivity {
+		d.normalizeTime(trashDirectory)
+	}
+	// Entries dated after the zero time aren't packed so, this only rewrites packs with stale copies
+	if _, err = d.pack(time.Time{}); err != nil {
+		return len(record.Remove), fmt.Errorf("Failed to remove the purged entries from their packs because %s", err)
+	}
+	return len(record.Remove), d.commit()
+}
+
+// recoverTrash adds the entries in the trash to the recovered index. When they were deleted isn't
+// known so, they're treated as if they were deleted when their files were last modified. Unlike the
+// rest of the journal, entries in the trash that can't be recovered are skipped.
+func (d *Driver) recoverTrash(ctx context.Context, recovered index) error {
+	files, err := ioutil.ReadDir(fmt.Sprintf("%s/%s", d.directory, trashDirectory))
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+		if !strings.HasSuffix(file.Name(), ".cpt") {
+			continue
+		}
+		name := fmt.Sprintf("%s/%s", trashDirectory, file.Name())
+		entry, err := d.readFile(name)
+		if err != nil {
+			log.Printf("Failed to recover %s because %s", name, err)
+			continue
+		}
+		if _, ok := recovered[entry.Id]; ok {
+			continue
+		}
+		deleted := file.ModTime()
+		trashed := newIndexEntry(entry, name)
+		trashed.Deleted = &deleted
+		recovered[entry.Id] = trashed
+	}
+	return nil
+}
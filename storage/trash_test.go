@@ -0,0 +1,118 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/btobolaski/ejrnl"
+)
+
+func TestTrashPackedAndRecovered(t *testing.T) {
+	conf := ejrnl.Config{StorageDirectory: "./trash-test", Salt: makeSalt(32), Pow: 12}
+	d, err := driverInit(conf)
+	defer os.RemoveAll(conf.StorageDirectory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	old := time.Now().AddDate(-1, 0, 0)
+	if err = d.Write(ejrnl.Entry{Id: "packed", Date: &old, Body: "packed"}); err != nil {
+		t.Fatalf("Failed to write entry because %s", err)
+	}
+	if err = d.Write(ejrnl.Entry{Id: "loose", Body: "loose"}); err != nil {
+		t.Fatalf("Failed to write entry because %s", err)
+	}
+	if _, err = d.Pack(time.Now().AddDate(0, 0, -30)); err != nil {
+		t.Fatalf("Failed to pack the journal because %s", err)
+	}
+	for _, id := range []string{"packed", "loose"} {
+		if err = d.Delete(ctx, id); err != nil {
+			t.Fatalf("Failed to delete %s because %s", id, err)
+		}
+	}
+	if looseFiles(t, conf.StorageDirectory) != 0 {
+		t.Error("Expected the deleted entry's file to be moved into the trash")
+	}
+	// The deleted entry is dropped from its pack and isn't packed again
+	if result, err := d.Pack(time.Now().AddDate(0, 0, 1)); err != nil || result.Packed != 0 || result.Repacked != 1 {
+		t.Errorf("Expected the pack to be dissolved, got %#v %v", result, err)
+	}
+	d.Close()
+
+	// The trash is recovered along with the rest of the journal
+	os.Remove(fmt.Sprintf("%s/%s", conf.StorageDirectory, indexFile))
+	os.Remove(fmt.Sprintf("%s/%s", conf.StorageDirectory, indexLogFile))
+	d, err = NewDriver(conf, "password")
+	if _, ok := err.(*NeedsInit); !ok {
+		t.Fatalf("Expected the journal without an index to need init, got %v", err)
+	}
+	defer d.Close()
+	if err = d.Init(); err != nil {
+		t.Fatalf("Failed to recover the index because %s", err)
+	}
+	listing, err := d.List()
+	if err != nil || len(listing) != 0 {
+		t.Errorf("Expected the recovered entries to still be in the trash, got %v %v", listing, err)
+	}
+	for _, id := range []string{"packed", "loose"} {
+		if err = d.Restore(ctx, id); err != nil {
+			t.Fatalf("Failed to restore %s because %s", id, err)
+		}
+		if entry, err := d.Read(id); err != nil || entry.Body != id {
+			t.Errorf("Failed to read the restored entry, got %#v %v", entry, err)
+		}
+	}
+}
+
+func TestPurgePacked(t *testing.T) {
+	conf := ejrnl.Config{StorageDirectory: "./purge-packed-test", Salt: makeSalt(32), Pow: 12}
+	d, err := driverInit(conf)
+	defer os.RemoveAll(conf.StorageDirectory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	for i, id := range []string{"purged", "kept"} {
+		old := time.Now().AddDate(-1, 0, i)
+		if err = d.Write(ejrnl.Entry{Id: id, Date: &old, Body: id}); err != nil {
+			t.Fatalf("Failed to write entry because %s", err)
+		}
+	}
+	if _, err = d.Pack(time.Now()); err != nil {
+		t.Fatalf("Failed to pack the journal because %s", err)
+	}
+	if err = d.Delete(ctx, "purged"); err != nil {
+		t.Fatalf("Failed to delete the entry because %s", err)
+	}
+	if purged, err := d.Purge(ctx, time.Now().Add(time.Minute)); err != nil || purged != 1 {
+		t.Fatalf("Expected the entry to be purged, got %d %v", purged, err)
+	}
+	d.Close()
+
+	// The purged entry doesn't come back from its pack when the index is recovered
+	os.Remove(fmt.Sprintf("%s/%s", conf.StorageDirectory, indexFile))
+	os.Remove(fmt.Sprintf("%s/%s", conf.StorageDirectory, indexLogFile))
+	d, err = NewDriver(conf, "password")
+	if _, ok := err.(*NeedsInit); !ok {
+		t.Fatalf("Expected the journal without an index to need init, got %v", err)
+	}
+	defer d.Close()
+	if err = d.Init(); err != nil {
+		t.Fatalf("Failed to recover the index because %s", err)
+	}
+	listing, err := d.List()
+	if err != nil || len(listing) != 1 {
+		t.Errorf("Expected only the kept entry to be recovered, got %v %v", listing, err)
+	}
+	if _, err = d.Read("purged"); err == nil {
+		t.Error("The purged entry was recovered from its pack")
+	}
+	if entry, err := d.Read("kept"); err != nil || entry.Body != "kept" {
+		t.Errorf("Failed to read the kept entry, got %#v %v", entry, err)
+	}
+}
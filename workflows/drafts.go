@@ -0,0 +1,271 @@
+package workflows
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+
+	"github.com/btobolaski/ejrnl"
+	"github.com/btobolaski/ejrnl/crypto"
+)
+
+// draftExtension is the extension of encrypted drafts.
+const draftExtension = ".draft"
+
+// memoryDirectories are where drafts are edited, in order of preference, when a directory isn't
+// chosen. Only the ones that are memory backed are used.
+var memoryDirectories = []string{os.Getenv("XDG_RUNTIME_DIR"), "/dev/shm", "/run/shm"}
+
+// editorArguments stop editors from writing swap, backup, undo and history files, which would
+// contain the plaintext, beside the draft or in the user's home directory.
+var editorArguments = map[string][]string{
+	"vim":   {"-n", "-i", "NONE", "--cmd", "set nobackup nowritebackup noundofile"},
+	"nvim":  {"-n", "-i", "NONE", "--cmd", "set nobackup nowritebackup noundofile"},
+	"emacs": {"--eval", "(setq make-backup-files nil auto-save-default nil create-lockfiles nil)"},
+}
+
+// Drafts is where entries are edited. The plaintext only exists in a private directory, which is
+// memory backed unless TempDir is set, while the editor is open. Drafts that can't be saved are
+// encrypted with Key and kept in Directory.
+type Drafts struct {
+	// TempDir is where the private directory is created. If it's empty, a memory backed directory,
+	// such as /dev/shm, is used and editing fails if there isn't one
+	TempDir string
+	// Directory is where encrypted drafts are kept
+	Directory string
+	Key       *crypto.Secret
+	// Secure shreds files instead of just removing them
+	Secure bool
+}
+
+// List returns the names of the drafts that are kept.
+func (d Drafts) List() ([]string, error) {
+	files, err := ioutil.ReadDir(d.Directory)
+	if os.IsNotExist(err) {
+		return []string{}, nil
+	} else if err != nil {
+		return []string{}, err
+	}
+	names := []string{}
+	for _, file := range files {
+		if strings.HasSuffix(file.Name(), draftExtension) {
+			names = append(names, strings.TrimSuffix(file.Name(), draftExtension))
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Resume opens a draft in the user's editor and then writes it to the journal, after which the
+// draft is removed.
+func (d Drafts) Resume(driver ejrnl.Driver, name string) error {
+	text, err := d.read(name)
+	if err != nil {
+		return err
+	}
+	defer crypto.Wipe(text)
+	// The draft is only saved again if it's changed so, it's kept if it still can't be written
+	if err = d.edit(text, name, writeText(driver)); err != nil {
+		return err
+	}
+	return d.Discard(name)
+}
+
+// Discard removes a draft.
+func (d Drafts) Discard(name string) error {
+	path, err := d.path(name)
+	if err != nil {
+		return err
+	}
+	return removeFile(path, d.Secure)
+}
+
+func (d Drafts) path(name string) (string, error) {
+	if name == "" || name != filepath.Base(name) {
+		return "", fmt.Errorf("%s isn't the name of a draft", name)
+	}
+	return fmt.Sprintf("%s/%s%s", d.Directory, name, draftExtension), nil
+}
+
+func (d Drafts) read(name string) ([]byte, error) {
+	path, err := d.path(name)
+	if err != nil {
+		return []byte{}, err
+	}
+	cyphertext, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return []byte{}, fmt.Errorf("Couldn't find the draft %s", name)
+	} else if err != nil {
+		return []byte{}, err
+	}
+	text, err := crypto.Decrypt(cyphertext, d.Key.Bytes())
+	if err != nil {
+		return []byte{}, fmt.Errorf("Failed to decrypt the draft %s because %s", name, err)
+	}
+	return text, nil
+}
+
+// save encrypts the draft and keeps it, replacing the draft with the same name. A name is generated
+// if it's empty. The text is overwritten by the encryption.
+func (d Drafts) save(text []byte, name string) (string, error) {
+	if d.Key == nil {
+		return name, errors.New("Drafts can't be saved without the journal's key")
+	}
+	if name == "" {
+		random := make([]byte, 8)
+		if _, err := rand.Read(random); err != nil {
+			return name, err
+		}
+		name = hex.EncodeToString(random)
+	}
+	path, err := d.path(name)
+	if err != nil {
+		return name, err
+	}
+	if err = os.MkdirAll(d.Directory, 0700); err != nil {
+		return name, err
+	}
+	cyphertext, err := crypto.Encrypt(text, d.Key.Bytes())
+	if err != nil {
+		return name, err
+	}
+	return name, ioutil.WriteFile(path, cyphertext, 0600)
+}
+
+// privateDirectory creates a directory that only the user can access for the plaintext.
+func (d Drafts) privateDirectory() (string, error) {
+	parent := d.TempDir
+	if parent == "" {
+		for _, directory := range memoryDirectories {
+			if directory != "" && isMemoryBacked(directory) {
+				parent = directory
+				break
+			}
+		}
+	}
+	if parent == "" {
+		return "", errors.New("Couldn't find a memory backed directory to edit the entry in. " +
+			"Choose a directory with --temp-dir, the entry will be written there unencrypted while it's edited")
+	}
+	directory, err := ioutil.TempDir(parent, "ejrnl-")
+	if err != nil {
+		return directory, err
+	}
+	return directory, os.Chmod(directory, 0700)
+}
+
+// edit opens the text in the user's editor and then calls write with the edited text. If editing
+// is interrupted or the edited text can't be written, it's saved as the named draft as long as it
+// was changed. The private directory is always removed.
+func (d Drafts) edit(text []byte, name string, write func(text []byte) error) error {
+	directory, err := d.privateDirectory()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := removeTree(directory, d.Secure); err != nil {
+			log.Printf("Failed to remove %s because %s", directory, err)
+		}
+	}()
+
+	path := fmt.Sprintf("%s/entry.ejrnl", directory)
+	if err = ioutil.WriteFile(path, text, 0600); err != nil {
+		return err
+	}
+	editErr := runEditor(path)
+	edited, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	defer crypto.Wipe(edited)
+	if editErr == nil {
+		editErr = write(edited)
+	}
+	if editErr == nil || bytes.Equal(edited, text) {
+		return editErr
+	}
+	if name, err = d.save(edited, name); err != nil {
+		return fmt.Errorf("%s and the draft couldn't be saved because %s", editErr, err)
+	}
+	fmt.Printf("an error was encountered, the draft was saved encrypted as %s\n", name)
+	return editErr
+}
+
+// editorCommand returns the command that opens the user's editor.
+func editorCommand(path string) *exec.Cmd {
+	command := os.ExpandEnv("$EDITOR")
+	for _, editor := range []string{"/usr/bin/edit", "/usr/bin/editor", "/usr/bin/vim", "/usr/bin/vi"} {
+		if command != "" {
+			break
+		}
+		if _, err := os.Stat(editor); err == nil {
+			command = editor
+		}
+	}
+	known, ok := editorArguments[editorName(command)]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "%s isn't an editor that ejrnl can stop from writing swap and backup files so, they may contain the entry's plaintext\n", command)
+	}
+	arguments := append(append([]string{}, known...), path)
+	return exec.Command(command, arguments...)
+}
+
+// editorName returns the name that editorArguments knows the editor by. Editors such as
+// /usr/bin/editor and /usr/bin/vi are usually links to the real editor, such as vim.basic.
+func editorName(command string) string {
+	if path, err := exec.LookPath(command); err == nil {
+		if resolved, err := filepath.EvalSymlinks(path); err == nil {
+			command = resolved
+		}
+	}
+	name := filepath.Base(command)
+	if i := strings.Index(name, "."); i > 0 {
+		name = name[:i]
+	}
+	return name
+}
+
+// runEditor runs the user's editor. If ejrnl is interrupted or terminated while it's open, the
+// editor is stopped so that the draft can be cleaned up.
+func runEditor(path string) error {
+	cmd := editorCommand(path)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+	select {
+	case err := <-done:
+		return err
+	case received := <-signals:
+		cmd.Process.Kill()
+		<-done
+		return fmt.Errorf("Editing was stopped by %s", received)
+	}
+}
+
+// removeFile removes the file, shredding it if secure is set.
+func removeFile(path string, secure bool) error {
+	if secure {
+		return crypto.Shred(path)
+	}
+	return os.Remove(path)
+}
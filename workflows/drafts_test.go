@@ -0,0 +1,145 @@
+package workflows
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/btobolaski/ejrnl/crypto"
+	"github.com/btobolaski/ejrnl/storage/memory"
+)
+
+// useEditor sets $EDITOR to a script that replaces the file it's given with the text.
+func useEditor(t *testing.T, directory, text string) {
+	script := fmt.Sprintf("%s/editor", directory)
+	contents := fmt.Sprintf("#!/bin/sh\ncat > \"$1\" <<'EOF'\n%sEOF\n", text)
+	if err := ioutil.WriteFile(script, []byte(contents), 0700); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("EDITOR", script)
+}
+
+func TestDrafts(t *testing.T) {
+	directory, err := ioutil.TempDir("", "ejrnl-drafts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(directory)
+	tempDir := fmt.Sprintf("%s/temp", directory)
+	if err = os.Mkdir(tempDir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	drafts := Drafts{
+		TempDir:   tempDir,
+		Directory: fmt.Sprintf("%s/drafts", directory),
+		Key:       crypto.SecretFromBytes(bytes.Repeat([]byte{1}, 32)),
+	}
+	driver := memory.NewDriver()
+
+	useEditor(t, directory, "id: first\n---\nwritten\n")
+	if err = NewEntry(driver, drafts); err != nil {
+		t.Fatalf("Failed to create an entry because %s", err)
+	}
+	if entry, err := driver.Read("first"); err != nil || entry.Body != "written\n" {
+		t.Errorf("Expected the edited entry to be written, got %#v %v", entry, err)
+	}
+
+	// An entry that can't be read is kept encrypted
+	useEditor(t, directory, "id: [broken\n---\nsecret words\n")
+	if err = EditEntry(driver, "first", drafts); err == nil {
+		t.Fatal("Expected the broken entry to fail")
+	}
+	names, err := drafts.List()
+	if err != nil || len(names) != 1 {
+		t.Fatalf("Expected a draft to be kept, got %v %v", names, err)
+	}
+	cyphertext, err := ioutil.ReadFile(fmt.Sprintf("%s/%s.draft", drafts.Directory, names[0]))
+	if err != nil || strings.Contains(string(cyphertext), "secret words") {
+		t.Errorf("Expected the draft to be encrypted, got %v", err)
+	}
+	files, err := ioutil.ReadDir(tempDir)
+	if err != nil || len(files) != 0 {
+		t.Errorf("Expected the private directory to be removed, got %d files %v", len(files), err)
+	}
+
+	useEditor(t, directory, "id: first\n---\nsecret words\n")
+	if err = drafts.Resume(driver, names[0]); err != nil {
+		t.Fatalf("Failed to resume the draft because %s", err)
+	}
+	if entry, err := driver.Read("first"); err != nil || entry.Body != "secret words\n" {
+		t.Errorf("Expected the resumed draft to be written, got %#v %v", entry, err)
+	}
+	if names, err = drafts.List(); err != nil || len(names) != 0 {
+		t.Errorf("Expected the resumed draft to be removed, got %v %v", names, err)
+	}
+}
+
+func TestNewEntryUnchanged(t *testing.T) {
+	directory, err := ioutil.TempDir("", "ejrnl-unchanged")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(directory)
+	drafts := Drafts{TempDir: directory}
+	driver := memory.NewDriver()
+
+	t.Setenv("EDITOR", "true")
+	if err = NewEntry(driver, drafts); err != nil {
+		t.Fatalf("Failed to create an entry because %s", err)
+	}
+	if entries, err := driver.List(); err != nil || len(entries) != 0 {
+		t.Errorf("Expected the unchanged entry to be thrown away, got %v %v", entries, err)
+	}
+
+	// An entry with only metadata was still changed
+	script := fmt.Sprintf("%s/editor", directory)
+	if err = ioutil.WriteFile(script, []byte("#!/bin/sh\nsed -i '1i mood: calm' \"$1\"\n"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("EDITOR", script)
+	if err = NewEntry(driver, drafts); err != nil {
+		t.Fatalf("Failed to create an entry because %s", err)
+	}
+	entries, err := driver.List()
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("Expected the entry to be written, got %v %v", entries, err)
+	}
+	for _, id := range entries {
+		if entry, err := driver.Read(id); err != nil || entry.Mood != "calm" {
+			t.Errorf("Expected the entry's mood to be written, got %#v %v", entry, err)
+		}
+	}
+}
+
+func TestEditorArguments(t *testing.T) {
+	t.Setenv("EDITOR", "/usr/local/bin/vim")
+	cmd := editorCommand("/dev/shm/ejrnl-1/entry.ejrnl")
+	arguments := strings.Join(cmd.Args, " ")
+	if !strings.Contains(arguments, " -n ") || !strings.HasSuffix(arguments, " /dev/shm/ejrnl-1/entry.ejrnl") {
+		t.Errorf("Expected vim's swap file to be disabled, got %s", arguments)
+	}
+
+	t.Setenv("EDITOR", "nano")
+	if cmd = editorCommand("entry.ejrnl"); len(cmd.Args) != 2 {
+		t.Errorf("Expected unknown editors to only be given the file, got %v", cmd.Args)
+	}
+}
+
+func TestEditorArgumentsLinked(t *testing.T) {
+	// /usr/bin/editor is usually a link to the real editor, such as vim.basic
+	directory := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(directory, "vim.basic"), []byte("#!/bin/sh\n"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(directory, "vim.basic"), filepath.Join(directory, "editor")); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("EDITOR", filepath.Join(directory, "editor"))
+	if arguments := strings.Join(editorCommand("entry.ejrnl").Args, " "); !strings.Contains(arguments, " -n ") {
+		t.Errorf("Expected the linked vim's swap file to be disabled, got %s", arguments)
+	}
+}
@@ -0,0 +1,98 @@
+package workflows
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"sort"
+
+	"github.com/btobolaski/ejrnl"
+)
+
+// ForEachEntry calls fn with every entry in the journal, oldest first. The entries are read and
+// decrypted by workers goroutines, or one per cpu if workers <= 0, but fn is only called by one
+// goroutine at a time. Walking the journal stops at the first error.
+func ForEachEntry(ctx context.Context, driver ejrnl.Driver, workers int, fn func(ejrnl.Entry) error) error {
+	listing, err := ejrnl.ListContext(ctx, driver)
+	if err != nil {
+		return err
+	}
+	sorted := timeSlice{}
+	for date := range listing {
+		sorted = append(sorted, date)
+	}
+	sort.Sort(sorted)
+	ids := make([]string, len(sorted))
+	for i, date := range sorted {
+		ids[i] = listing[date]
+	}
+	return ReadEntries(ctx, driver, ids, workers, fn)
+}
+
+// ReadEntries calls fn with each of the entries in the same order as ids. They're read in parallel
+// the same way as ForEachEntry. Only a few entries more than the number of workers are held in
+// memory at once.
+func ReadEntries(ctx context.Context, driver ejrnl.Driver, ids []string, workers int, fn func(ejrnl.Entry) error) error {
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		entry ejrnl.Entry
+		err   error
+	}
+	results := make([]chan result, len(ids))
+	for i := range results {
+		results[i] = make(chan result, 1)
+	}
+	// window bounds how far the workers can get ahead of fn
+	window := make(chan struct{}, 2*workers)
+	jobs := make(chan int)
+	go func() {
+		defer close(jobs)
+		for i := range ids {
+			select {
+			case window <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			select {
+			case jobs <- i:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	for w := 0; w < workers; w++ {
+		go func() {
+			for i := range jobs {
+				entry, err := ejrnl.ReadContext(ctx, driver, ids[i])
+				results[i] <- result{entry: entry, err: err}
+			}
+		}()
+	}
+
+	for i, id := range ids {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		var read result
+		select {
+		case read = <-results[i]:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		<-window
+		if read.err != nil && ctx.Err() != nil {
+			return ctx.Err()
+		} else if read.err != nil {
+			return fmt.Errorf("Failed to read %s because %s", id, read.err)
+		}
+		if err := fn(read.entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package workflows
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/btobolaski/ejrnl"
+	"github.com/btobolaski/ejrnl/storage/memory"
+)
+
+// shuffledDriver takes longer to read older entries so that the workers finish out of order.
+type shuffledDriver struct {
+	*memory.Driver
+}
+
+func (d shuffledDriver) Read(id string) (ejrnl.Entry, error) {
+	entry, err := d.Driver.Read(id)
+	if err == nil {
+		time.Sleep(time.Duration(entry.Date.Unix()%7) * time.Millisecond)
+	}
+	return entry, err
+}
+
+func writeEntries(t *testing.T, count int) *memory.Driver {
+	driver := memory.NewDriver()
+	start := time.Date(2016, 12, 24, 0, 32, 58, 0, time.UTC)
+	for i := 0; i < count; i++ {
+		date := start.Add(time.Duration(i) * time.Second)
+		if err := driver.Write(ejrnl.Entry{Id: fmt.Sprintf("%d", i), Date: &date}); err != nil {
+			t.Fatalf("Failed to write entry because %s", err)
+		}
+	}
+	return driver
+}
+
+func TestForEachEntry(t *testing.T) {
+	driver := shuffledDriver{writeEntries(t, 50)}
+	read := []string{}
+	err := ForEachEntry(context.Background(), driver, 4, func(entry ejrnl.Entry) error {
+		read = append(read, entry.Id)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to read the entries because %s", err)
+	}
+	if len(read) != 50 {
+		t.Fatalf("Expected every entry to be read, got %v", read)
+	}
+	for i, id := range read {
+		if id != fmt.Sprintf("%d", i) {
+			t.Fatalf("The entries weren't delivered in date order, got %v", read)
+		}
+	}
+}
+
+func TestForEachEntryStops(t *testing.T) {
+	driver := writeEntries(t, 50)
+	stop := errors.New("stop")
+	calls := 0
+	err := ForEachEntry(context.Background(), driver, 4, func(entry ejrnl.Entry) error {
+		calls++
+		if calls == 3 {
+			return stop
+		}
+		return nil
+	})
+	if err != stop || calls != 3 {
+		t.Errorf("Expected the walk to stop at the first error, got %v after %d entries", err, calls)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	calls = 0
+	err = ForEachEntry(ctx, driver, 4, func(entry ejrnl.Entry) error {
+		calls++
+		cancel()
+		return nil
+	})
+	if err != context.Canceled || calls != 1 {
+		t.Errorf("Expected the walk to stop when it was canceled, got %v after %d entries", err, calls)
+	}
+
+	err = ReadEntries(context.Background(), driver, []string{"1", "missing"}, 2, func(ejrnl.Entry) error { return nil })
+	if err == nil {
+		t.Error("Expected an error when an entry can't be read")
+	}
+}
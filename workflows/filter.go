@@ -0,0 +1,159 @@
+package workflows
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/btobolaski/ejrnl"
+)
+
+// Filter selects entries by their metadata. Empty parts of the filter match every entry.
+type Filter struct {
+	// Tags must all be on the entry
+	Tags []string
+	// Title and Location match if they're part of the entry's title or location's name, ignoring
+	// case
+	Title    string
+	Location string
+	Mood     string
+	// Fields must all be set on the entry. An empty value matches any value
+	Fields map[string]string
+	// Since and Until are the first and last days, as 2006-01-02, of the entries that match. Days
+	// are in Zone so, with the original zone, an entry matches if it was that day where it was
+	// written. Print and ListEntries also show dates in Zone.
+	Since, Until string
+	Zone         ejrnl.DisplayZone
+}
+
+// Empty returns whether the filter matches every entry.
+func (f Filter) Empty() bool {
+	return len(f.Tags) == 0 && f.Title == "" && f.Location == "" && f.Mood == "" && len(f.Fields) == 0 &&
+		f.Since == "" && f.Until == ""
+}
+
+// SetDays sets the first and last days that match, either of which can be empty.
+func (f *Filter) SetDays(since, until string) error {
+	for _, day := range []string{since, until} {
+		if _, err := time.Parse(dayFormat, day); day != "" && err != nil {
+			return fmt.Errorf("%s isn't a day in the format 2006-01-02", day)
+		}
+	}
+	f.Since, f.Until = since, until
+	return nil
+}
+
+// AddField adds a field to the filter written as name=value, or just name to match any value.
+func (f *Filter) AddField(field string) error {
+	parts := append(strings.SplitN(field, "=", 2), "")
+	if parts[0] == "" {
+		return fmt.Errorf("The field filter %s doesn't have a name", field)
+	}
+	if f.Fields == nil {
+		f.Fields = make(map[string]string)
+	}
+	f.Fields[parts[0]] = parts[1]
+	return nil
+}
+
+func containsFold(value, part string) bool {
+	return strings.Contains(strings.ToLower(value), strings.ToLower(part))
+}
+
+// Matches returns whether the entry matches the filter.
+func (f Filter) Matches(entry ejrnl.Entry) bool {
+	for _, tag := range f.Tags {
+		found := false
+		for _, entryTag := range entry.Tags {
+			found = found || entryTag == tag
+		}
+		if !found {
+			return false
+		}
+	}
+	if f.Title != "" && !containsFold(entry.Title, f.Title) {
+		return false
+	}
+	if f.Location != "" && (entry.Location == nil || !containsFold(entry.Location.Name, f.Location)) {
+		return false
+	}
+	if f.Mood != "" && !strings.EqualFold(entry.Mood, f.Mood) {
+		return false
+	}
+	for name, value := range f.Fields {
+		entryValue, ok := entry.Fields[name]
+		if !ok || (value != "" && entryValue != value) {
+			return false
+		}
+	}
+	if f.Since != "" || f.Until != "" {
+		if entry.Date == nil {
+			return false
+		}
+		// Days in this format sort the same as strings
+		day := f.Zone.In(*entry.Date, entry.Zone).Format(dayFormat)
+		if (f.Since != "" && day < f.Since) || (f.Until != "" && day > f.Until) {
+			return false
+		}
+	}
+	return true
+}
+
+// dayFormat is how days are written in filters and links.
+const dayFormat = "2006-01-02"
+
+// errEnough stops reading entries once enough have been found.
+var errEnough = errors.New("Found enough entries")
+
+// Matching calls fn with the most recent count entries, or every entry if count <= 0, that match
+// the filter. The entries are delivered most recent first.
+func Matching(ctx context.Context, driver ejrnl.Driver, filter Filter, count int, fn func(ejrnl.Entry) error) error {
+	listing, sorted, err := Listing(ctx, driver)
+	if err != nil {
+		return err
+	}
+	ids := make([]string, len(sorted))
+	for i, date := range sorted {
+		ids[i] = listing[date]
+	}
+	found := 0
+	err = ReadEntries(ctx, driver, ids, 0, func(entry ejrnl.Entry) error {
+		if !filter.Matches(entry) {
+			return nil
+		}
+		if err := fn(entry); err != nil {
+			return err
+		}
+		found++
+		if count > 0 && found >= count {
+			return errEnough
+		}
+		return nil
+	})
+	if err == errEnough {
+		return nil
+	}
+	return err
+}
+
+// Summaries returns the id, date, title and links of every entry, most recent first. Drivers that
+// don't keep titles in their index have every entry read.
+func Summaries(ctx context.Context, driver ejrnl.Driver) ([]ejrnl.Summary, error) {
+	summaries := []ejrnl.Summary{}
+	if summarizer, ok := driver.(ejrnl.Summarizer); ok {
+		var err error
+		if summaries, err = summarizer.Summaries(ctx); err != nil {
+			return summaries, err
+		}
+		sort.Slice(summaries, func(i, j int) bool { return summaries[i].Date.After(summaries[j].Date) })
+		return summaries, nil
+	}
+	err := Matching(ctx, driver, Filter{}, 0, func(entry ejrnl.Entry) error {
+		summaries = append(summaries, entry.Summary())
+		return nil
+	})
+	return summaries, err
+}
@@ -0,0 +1,95 @@
+package workflows
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/btobolaski/ejrnl"
+	"github.com/btobolaski/ejrnl/storage/memory"
+)
+
+var exampleMetadata = `date: 2016-12-24T00:32:58Z
+tags:
+- Test
+title: A title
+location:
+  latitude: 51.5
+  longitude: -0.12
+  name: London
+mood: happy
+fields:
+  weather: rain
+---
+This is the body`
+
+func TestMetadataRoundTrip(t *testing.T) {
+	entry, err := Read([]byte(exampleMetadata))
+	if err != nil {
+		t.Fatalf("Failed to parse the entry because %s", err)
+	}
+	if entry.Title != "A title" || entry.Mood != "happy" || entry.Fields["weather"] != "rain" {
+		t.Errorf("The metadata wasn't read, got %#v", entry)
+	}
+	if entry.Location == nil || entry.Location.Name != "London" || entry.Location.Latitude != 51.5 {
+		t.Errorf("The location wasn't read, got %#v", entry.Location)
+	}
+	if display := Format(entry); display != exampleMetadata {
+		t.Errorf("Formatted entry doesn't match expected.\ngot:      '%s'\nexpected: '%s'", display, exampleMetadata)
+	}
+}
+
+func TestFilter(t *testing.T) {
+	entry, err := Read([]byte(exampleMetadata))
+	if err != nil {
+		t.Fatalf("Failed to parse the entry because %s", err)
+	}
+	tests := []struct {
+		filter  Filter
+		matches bool
+	}{
+		{Filter{}, true},
+		{Filter{Tags: []string{"Test"}}, true},
+		{Filter{Tags: []string{"Test", "Other"}}, false},
+		{Filter{Title: "title"}, true},
+		{Filter{Title: "other"}, false},
+		{Filter{Location: "london"}, true},
+		{Filter{Location: "paris"}, false},
+		{Filter{Mood: "Happy"}, true},
+		{Filter{Mood: "sad"}, false},
+		{Filter{Fields: map[string]string{"weather": ""}}, true},
+		{Filter{Fields: map[string]string{"weather": "rain"}}, true},
+		{Filter{Fields: map[string]string{"weather": "sun"}}, false},
+		{Filter{Fields: map[string]string{"temperature": ""}}, false},
+	}
+	for _, test := range tests {
+		if test.filter.Matches(entry) != test.matches {
+			t.Errorf("Expected %#v to match: %t", test.filter, test.matches)
+		}
+	}
+}
+
+func TestMatching(t *testing.T) {
+	driver := memory.NewDriver()
+	start := time.Date(2016, 12, 24, 0, 32, 58, 0, time.UTC)
+	for i, mood := range []string{"happy", "sad", "happy", "happy"} {
+		date := start.Add(time.Duration(i) * time.Hour)
+		if err := driver.Write(ejrnl.Entry{Id: string(rune('a' + i)), Date: &date, Mood: mood, Title: mood}); err != nil {
+			t.Fatalf("Failed to write entry because %s", err)
+		}
+	}
+
+	found := []string{}
+	err := Matching(context.Background(), driver, Filter{Mood: "happy"}, 2, func(entry ejrnl.Entry) error {
+		found = append(found, entry.Id)
+		return nil
+	})
+	if err != nil || len(found) != 2 || found[0] != "d" || found[1] != "c" {
+		t.Errorf("Expected the two most recent happy entries, got %v %v", found, err)
+	}
+
+	summaries, err := Summaries(context.Background(), driver)
+	if err != nil || len(summaries) != 4 || summaries[0].Id != "d" || summaries[1].Title != "happy" {
+		t.Errorf("Expected the summaries most recent first, got %v %v", summaries, err)
+	}
+}
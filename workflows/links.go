@@ -0,0 +1,103 @@
+package workflows
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/btobolaski/ejrnl"
+)
+
+// Resolver resolves links against the journal. A link's target is either an entry's id, the exact
+// date of an entry, in RFC 3339, or a day, 2006-01-02, which resolves to the first entry on that day
+// in the display zone. The entries that link to each entry are found when it's created because day
+// links depend on the display zone so, they can't be resolved when the index is written.
+type Resolver struct {
+	ids map[string]ejrnl.Summary
+	// sorted is every entry, oldest first
+	sorted []ejrnl.Summary
+	// days maps each day, in the display zone, to the first entry on it
+	days map[string]string
+	// backlinks maps each entry to the entries that link to it, oldest first
+	backlinks map[string][]ejrnl.Summary
+}
+
+// NewResolver creates a resolver for the journal's summaries.
+func NewResolver(summaries []ejrnl.Summary, zone ejrnl.DisplayZone) *Resolver {
+	r := &Resolver{
+		ids:       make(map[string]ejrnl.Summary),
+		sorted:    make([]ejrnl.Summary, len(summaries)),
+		days:      make(map[string]string),
+		backlinks: make(map[string][]ejrnl.Summary),
+	}
+	copy(r.sorted, summaries)
+	sort.Slice(r.sorted, func(i, j int) bool { return r.sorted[i].Date.Before(r.sorted[j].Date) })
+	for _, summary := range r.sorted {
+		r.ids[summary.Id] = summary
+		day := zone.In(summary.Date, summary.Zone).Format(dayFormat)
+		if _, ok := r.days[day]; !ok {
+			r.days[day] = summary.Id
+		}
+	}
+	for _, summary := range r.sorted {
+		linked := make(map[string]bool)
+		for _, target := range summary.Links {
+			if id, ok := r.Resolve(target); ok && !linked[id] {
+				linked[id] = true
+				r.backlinks[id] = append(r.backlinks[id], summary)
+			}
+		}
+	}
+	return r
+}
+
+// Resolve returns the id of the entry that the link's target refers to.
+func (r *Resolver) Resolve(target string) (string, bool) {
+	if _, ok := r.ids[target]; ok {
+		return target, true
+	}
+	if date, err := time.Parse(time.RFC3339, target); err == nil {
+		i := sort.Search(len(r.sorted), func(i int) bool { return !r.sorted[i].Date.Before(date) })
+		if i < len(r.sorted) && r.sorted[i].Date.Equal(date) {
+			return r.sorted[i].Id, true
+		}
+		return "", false
+	}
+	if _, err := time.Parse(dayFormat, target); err != nil {
+		return "", false
+	}
+	id, ok := r.days[target]
+	return id, ok
+}
+
+// Backlinks returns the entries that link to the entry, oldest first.
+func (r *Resolver) Backlinks(id string) []ejrnl.Summary {
+	return append([]ejrnl.Summary{}, r.backlinks[id]...)
+}
+
+// Backlinks returns the entries that link to the entry, oldest first. Links to days are resolved in
+// the zone.
+func Backlinks(ctx context.Context, driver ejrnl.Driver, id string, zone ejrnl.DisplayZone) ([]ejrnl.Summary, error) {
+	summaries, err := Summaries(ctx, driver)
+	if err != nil {
+		return nil, err
+	}
+	resolver := NewResolver(summaries, zone)
+	if _, ok := resolver.ids[id]; !ok {
+		return nil, fmt.Errorf("The entry %s doesn't exist", id)
+	}
+	return resolver.Backlinks(id), nil
+}
+
+// PrintBacklinks outputs the date, in the zone, id and title of the entries that link to the entry.
+func PrintBacklinks(ctx context.Context, driver ejrnl.Driver, id string, zone ejrnl.DisplayZone) error {
+	backlinks, err := Backlinks(ctx, driver, id, zone)
+	if err != nil {
+		return err
+	}
+	for _, summary := range backlinks {
+		printSummary(summary, zone)
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package workflows
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/btobolaski/ejrnl"
+	"github.com/btobolaski/ejrnl/storage/memory"
+)
+
+func TestLinks(t *testing.T) {
+	links := ejrnl.Links("See [[a]], [[ 2016-12-24 ]] and [[a]] but not [[]] or [single]")
+	if !reflect.DeepEqual(links, []string{"a", "2016-12-24"}) {
+		t.Errorf("Incorrect links %v", links)
+	}
+}
+
+func TestBacklinks(t *testing.T) {
+	driver := memory.NewDriver()
+	first := time.Date(2016, 12, 24, 9, 0, 0, 0, time.Local)
+	second := first.Add(time.Hour)
+	third := first.AddDate(0, 0, 1)
+	entries := []ejrnl.Entry{
+		{Id: "a", Date: &first},
+		{Id: "b", Date: &second, Body: "Following up on [[a]]"},
+		{Id: "c", Date: &third, Body: "Yesterday was [[2016-12-24]], then [[" + second.Format(time.RFC3339) + "]] and [[missing]]"},
+	}
+	for _, entry := range entries {
+		if err := driver.Write(entry); err != nil {
+			t.Fatalf("Failed to write entry because %s", err)
+		}
+	}
+
+	summaries, err := Summaries(context.Background(), driver)
+	if err != nil {
+		t.Fatalf("Failed to summarize the journal because %s", err)
+	}
+	resolver := NewResolver(summaries, ejrnl.LocalZone)
+	cases := map[string]string{
+		"a":                         "a",
+		"2016-12-24":                "a",
+		"2016-12-25":                "c",
+		"2016-12-26":                "",
+		"missing":                   "",
+		second.Format(time.RFC3339): "b",
+		second.Add(time.Minute).Format(time.RFC3339): "",
+	}
+	for target, expected := range cases {
+		if id, _ := resolver.Resolve(target); id != expected {
+			t.Errorf("Expected %s to resolve to %q, got %q", target, expected, id)
+		}
+	}
+
+	backlinks, err := Backlinks(context.Background(), driver, "a", ejrnl.LocalZone)
+	if err != nil || len(backlinks) != 2 || backlinks[0].Id != "b" || backlinks[1].Id != "c" {
+		t.Errorf("Incorrect backlinks for a %v %v", backlinks, err)
+	}
+	backlinks, err = Backlinks(context.Background(), driver, "b", ejrnl.LocalZone)
+	if err != nil || len(backlinks) != 1 || backlinks[0].Id != "c" {
+		t.Errorf("Incorrect backlinks for b %v %v", backlinks, err)
+	}
+	if _, err = Backlinks(context.Background(), driver, "missing", ejrnl.LocalZone); err == nil {
+		t.Error("Expected an error for an entry that doesn't exist")
+	}
+}
@@ -0,0 +1,252 @@
+package workflows
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/btobolaski/ejrnl"
+	"github.com/btobolaski/ejrnl/crypto"
+)
+
+// StagingDirectory is where a rekey or backend migration writes the new journal. It's beside the
+// journal so that it can be renamed into the journal's place.
+func StagingDirectory(journalDir string) string {
+	return swapPath(journalDir, "new")
+}
+
+// previousDirectory is where the journal is kept while the new journal is moved into its place.
+func previousDirectory(journalDir string) string {
+	return swapPath(journalDir, "old")
+}
+
+// swapMarker exists while the new journal is being moved into the journal's place. FinishSwap does
+// nothing without it.
+func swapMarker(journalDir string) string {
+	return swapPath(journalDir, "swap")
+}
+
+// swapPath returns a hidden path beside the journal whose name is specific to ejrnl so that it
+// doesn't collide with the user's own files, such as a backup in journal.old.
+func swapPath(journalDir, suffix string) string {
+	journalDir = filepath.Clean(journalDir)
+	return filepath.Join(filepath.Dir(journalDir), fmt.Sprintf(".%s.ejrnl-%s", filepath.Base(journalDir), suffix))
+}
+
+// Rekey copies the journal, and its dictionaries, into a new journal, in stagingDir, with a
+// different key, checks that every entry reads back the same and then replaces the journal with it.
+// stagingDir must be on the same filesystem as the journal. If it's canceled, the journal isn't
+// replaced and running it again with the same new journal only copies the entries that are missing.
+// If secure is set, the old journal is shredded instead of just removed. The trash isn't copied so,
+// it has to be emptied, or its entries restored, first.
+func Rekey(ctx context.Context, oldDriver, newDriver ejrnl.Driver, journalDir, stagingDir string, secure bool) error {
+	if err := checkTrashEmpty(ctx, oldDriver); err != nil {
+		return err
+	}
+	if err := copyDictionaries(oldDriver, newDriver); err != nil {
+		return err
+	}
+	if err := copyEntries(ctx, oldDriver, newDriver); err != nil {
+		return err
+	}
+	if err := verifyEntries(ctx, oldDriver, newDriver); err != nil {
+		return err
+	}
+	return swapJournal(journalDir, stagingDir, secure)
+}
+
+// MigrateBackend copies the journal into a new journal, in stagingDir, that uses a different
+// storage backend and then replaces the journal with it the same way as Rekey.
+func MigrateBackend(ctx context.Context, oldDriver, newDriver ejrnl.Driver, journalDir, stagingDir string, secure bool) error {
+	return Rekey(ctx, oldDriver, newDriver, journalDir, stagingDir, secure)
+}
+
+// checkTrashEmpty returns an error if the journal has entries in the trash, which would be lost when
+// it's replaced.
+func checkTrashEmpty(ctx context.Context, driver ejrnl.Driver) error {
+	trash, ok := driver.(ejrnl.Trash)
+	if !ok {
+		return nil
+	}
+	trashed, err := trash.Trashed(ctx)
+	if err != nil {
+		return err
+	}
+	if len(trashed) > 0 {
+		return fmt.Errorf("The journal has %d entries in the trash, restore them or empty it with ejrnl trash first", len(trashed))
+	}
+	return nil
+}
+
+// copyDictionaries adds the dictionaries that the new journal is missing so that it compresses the
+// entries the same way. Journals that can't keep dictionaries compress them without one instead.
+func copyDictionaries(from, to ejrnl.Driver) error {
+	source, ok := from.(ejrnl.DictionaryStore)
+	if !ok {
+		return nil
+	}
+	destination, ok := to.(ejrnl.DictionaryStore)
+	if !ok {
+		return nil
+	}
+	existing := destination.Dictionaries()
+	for _, dictionary := range source.Dictionaries() {
+		if hasDictionary(existing, dictionary) {
+			continue
+		}
+		if err := destination.AddDictionary(dictionary); err != nil {
+			return fmt.Errorf("Failed to copy a dictionary because %s", err)
+		}
+	}
+	return nil
+}
+
+func hasDictionary(dictionaries [][]byte, dictionary []byte) bool {
+	for _, existing := range dictionaries {
+		if bytes.Equal(existing, dictionary) {
+			return true
+		}
+	}
+	return false
+}
+
+// copyEntries writes every entry in one journal to another. Entries that are already the same in
+// the other journal, such as the ones copied before an interruption, aren't written again.
+func copyEntries(ctx context.Context, from, to ejrnl.Driver) error {
+	listing, err := ejrnl.ListContext(ctx, to)
+	if err != nil {
+		return err
+	}
+	copied := make(map[string]bool, len(listing))
+	for _, id := range listing {
+		copied[id] = true
+	}
+	return ForEachEntry(ctx, from, 0, func(entry ejrnl.Entry) error {
+		if copied[entry.Id] {
+			if existing, err := ejrnl.ReadContext(ctx, to, entry.Id); err == nil && sameEntry(entry, existing) {
+				return nil
+			}
+		}
+		return ejrnl.WriteContext(ctx, to, entry)
+	})
+}
+
+// verifyEntries checks that the other journal has exactly the same entries and dictionaries, that
+// each of the entries can be read with its key and that nothing was moved to the trash meanwhile.
+func verifyEntries(ctx context.Context, from, to ejrnl.Driver) error {
+	if err := checkTrashEmpty(ctx, from); err != nil {
+		return err
+	}
+	if source, ok := from.(ejrnl.DictionaryStore); ok {
+		if destination, ok := to.(ejrnl.DictionaryStore); ok {
+			existing := destination.Dictionaries()
+			for _, dictionary := range source.Dictionaries() {
+				if !hasDictionary(existing, dictionary) {
+					return errors.New("Failed to verify the new journal because it's missing a dictionary")
+				}
+			}
+		}
+	}
+	original, err := ejrnl.ListContext(ctx, from)
+	if err != nil {
+		return err
+	}
+	copied, err := ejrnl.ListContext(ctx, to)
+	if err != nil {
+		return err
+	}
+	if len(copied) != len(original) {
+		return fmt.Errorf("Failed to verify the new journal because it has %d entries instead of %d", len(copied), len(original))
+	}
+	return ForEachEntry(ctx, from, 0, func(entry ejrnl.Entry) error {
+		existing, err := ejrnl.ReadContext(ctx, to, entry.Id)
+		if err != nil {
+			return fmt.Errorf("Failed to verify %s because %s", entry.Id, err)
+		}
+		if !sameEntry(entry, existing) {
+			return fmt.Errorf("Failed to verify %s because the new journal's copy is different", entry.Id)
+		}
+		return nil
+	})
+}
+
+// sameEntry returns whether both entries have the same contents.
+func sameEntry(a, b ejrnl.Entry) bool {
+	return a.Id == b.Id && Format(a) == Format(b)
+}
+
+// swapJournal renames the new journal in stagingDir into the journal's place. The journal is
+// renamed aside first and only removed once the new journal is in place so that FinishSwap can
+// complete an interrupted swap, which is recorded by the swap marker.
+func swapJournal(journalDir, stagingDir string, secure bool) error {
+	previous := previousDirectory(journalDir)
+	if exists(previous) {
+		return fmt.Errorf("Failed to move the journal aside because %s already exists", previous)
+	}
+	if err := ioutil.WriteFile(swapMarker(journalDir), nil, 0600); err != nil {
+		return fmt.Errorf("Failed to record the swap because %s", err)
+	}
+	if err := os.Rename(journalDir, previous); err != nil {
+		os.Remove(swapMarker(journalDir))
+		return fmt.Errorf("Failed to move the journal aside because %s", err)
+	}
+	if err := os.Rename(stagingDir, journalDir); err != nil {
+		if restoreErr := os.Rename(previous, journalDir); restoreErr != nil {
+			return fmt.Errorf("Failed to move the new journal into place because %s. The journal is at %s", err, previous)
+		}
+		os.Remove(swapMarker(journalDir))
+		return fmt.Errorf("Failed to move the new journal into place because %s", err)
+	}
+	if err := removeTree(previous, secure); err != nil {
+		return err
+	}
+	return os.Remove(swapMarker(journalDir))
+}
+
+// FinishSwap completes a swap that was interrupted and returns whether there was one. Nothing is
+// done unless the swap marker shows that a swap was in progress. If the new journal was moved into
+// place, the old journal is removed. Otherwise, the old journal is moved back.
+func FinishSwap(journalDir, stagingDir string, secure bool) (bool, error) {
+	marker := swapMarker(journalDir)
+	if !exists(marker) {
+		return false, nil
+	}
+	previous := previousDirectory(journalDir)
+	if !exists(previous) {
+		// The swap either hadn't started or had already removed the old journal
+		return false, os.Remove(marker)
+	}
+	if !exists(journalDir) {
+		source := stagingDir
+		if !exists(stagingDir) {
+			source = previous
+		}
+		if err := os.Rename(source, journalDir); err != nil {
+			return true, fmt.Errorf("Failed to finish replacing the journal because %s", err)
+		}
+		if source == previous {
+			return true, os.Remove(marker)
+		}
+	}
+	if err := removeTree(previous, secure); err != nil {
+		return true, err
+	}
+	return true, os.Remove(marker)
+}
+
+func exists(path string) bool {
+	_, err := os.Lstat(path)
+	return err == nil
+}
+
+// removeTree removes the directory, shredding its files if secure is set.
+func removeTree(directory string, secure bool) error {
+	if secure {
+		return crypto.ShredTree(directory)
+	}
+	return os.RemoveAll(directory)
+}
@@ -0,0 +1,175 @@
+package workflows
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/btobolaski/ejrnl"
+	"github.com/btobolaski/ejrnl/storage/memory"
+)
+
+// countingDriver counts the entries written to it.
+type countingDriver struct {
+	ejrnl.Driver
+	writes int
+}
+
+func (d *countingDriver) Write(entry ejrnl.Entry) error {
+	d.writes++
+	return d.Driver.Write(entry)
+}
+
+// journalDirs creates a journal's directory and the staging directory beside it, each containing a
+// file with the same name as the directory.
+func journalDirs(t *testing.T) (string, string) {
+	parent, err := ioutil.TempDir("", "ejrnl-swap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(parent) })
+	journalDir := fmt.Sprintf("%s/journal", parent)
+	for _, directory := range []string{journalDir, StagingDirectory(journalDir)} {
+		if err = os.Mkdir(directory, 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err = ioutil.WriteFile(fmt.Sprintf("%s/%s", directory, filepath.Base(directory)), nil, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return journalDir, StagingDirectory(journalDir)
+}
+
+// holds returns whether the journal's directory has the file that was created in the directory.
+func holds(journalDir, directory string) bool {
+	return exists(fmt.Sprintf("%s/%s", journalDir, filepath.Base(directory)))
+}
+
+func TestRekeyResumes(t *testing.T) {
+	journalDir, stagingDir := journalDirs(t)
+	oldDriver := writeEntries(t, 10)
+	// An interrupted rekey copied some of the entries and one of them has changed since
+	newDriver := &countingDriver{Driver: writeEntries(t, 4)}
+	stale, err := oldDriver.Read("3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.Body = "changed"
+	if err = oldDriver.Write(stale); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = Rekey(context.Background(), oldDriver, newDriver, journalDir, stagingDir, false); err != nil {
+		t.Fatalf("Failed to rekey because %s", err)
+	}
+	if newDriver.writes != 7 {
+		t.Errorf("Expected only the missing and changed entries to be written, got %d writes", newDriver.writes)
+	}
+	if entry, err := newDriver.Read("3"); err != nil || entry.Body != "changed" {
+		t.Errorf("Expected the changed entry to be copied again, got %#v %v", entry, err)
+	}
+	if !holds(journalDir, stagingDir) {
+		t.Error("Expected the new journal to replace the journal")
+	}
+	if exists(stagingDir) || exists(previousDirectory(journalDir)) || exists(swapMarker(journalDir)) {
+		t.Error("Expected the staging directory, the old journal and the swap marker to be gone")
+	}
+}
+
+func TestRekeyVerifies(t *testing.T) {
+	journalDir, stagingDir := journalDirs(t)
+	newDriver := writeEntries(t, 11)
+	err := Rekey(context.Background(), writeEntries(t, 10), newDriver, journalDir, stagingDir, false)
+	if err == nil {
+		t.Fatal("Expected a new journal with an extra entry to fail verification")
+	}
+	if !holds(journalDir, journalDir) || !exists(stagingDir) {
+		t.Error("Expected the journal to be kept when verification fails")
+	}
+}
+
+func TestRekeyRefusesTrash(t *testing.T) {
+	journalDir, stagingDir := journalDirs(t)
+	oldDriver := writeEntries(t, 10)
+	if err := oldDriver.Delete(context.Background(), "3"); err != nil {
+		t.Fatal(err)
+	}
+	newDriver := &countingDriver{Driver: memory.NewDriver()}
+	if err := Rekey(context.Background(), oldDriver, newDriver, journalDir, stagingDir, false); err == nil {
+		t.Fatal("Expected a journal with entries in the trash to be refused")
+	}
+	if newDriver.writes != 0 || !holds(journalDir, journalDir) {
+		t.Error("Expected nothing to be copied and the journal to be kept")
+	}
+}
+
+func TestFinishSwap(t *testing.T) {
+	// Interrupted after the journal was moved aside
+	journalDir, stagingDir := journalDirs(t)
+	startSwap(t, journalDir)
+	if err := os.Rename(journalDir, previousDirectory(journalDir)); err != nil {
+		t.Fatal(err)
+	}
+	finished, err := FinishSwap(journalDir, stagingDir, true)
+	if err != nil || !finished {
+		t.Fatalf("Expected the swap to be finished, got %v %v", finished, err)
+	}
+	if !holds(journalDir, stagingDir) || exists(previousDirectory(journalDir)) || exists(swapMarker(journalDir)) {
+		t.Error("Expected the new journal to be moved into place and the old one and the marker removed")
+	}
+
+	// Interrupted while removing the old journal
+	startSwap(t, journalDir)
+	if err = os.Mkdir(previousDirectory(journalDir), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if finished, err = FinishSwap(journalDir, stagingDir, false); err != nil || !finished {
+		t.Fatalf("Expected the swap to be finished, got %v %v", finished, err)
+	}
+	if exists(previousDirectory(journalDir)) || !exists(journalDir) {
+		t.Error("Expected only the old journal to be removed")
+	}
+
+	// The new journal is missing so the old one is put back
+	startSwap(t, journalDir)
+	if err = os.Rename(journalDir, previousDirectory(journalDir)); err != nil {
+		t.Fatal(err)
+	}
+	if finished, err = FinishSwap(journalDir, stagingDir, false); err != nil || !finished {
+		t.Fatalf("Expected the swap to be finished, got %v %v", finished, err)
+	}
+	if !exists(journalDir) || exists(previousDirectory(journalDir)) {
+		t.Error("Expected the old journal to be moved back")
+	}
+
+	if finished, err = FinishSwap(journalDir, stagingDir, false); err != nil || finished {
+		t.Errorf("Expected nothing to finish, got %v %v", finished, err)
+	}
+}
+
+func TestFinishSwapWithoutMarker(t *testing.T) {
+	journalDir, stagingDir := journalDirs(t)
+	// Neither the user's own backup nor a directory left by something else is a swap
+	for _, directory := range []string{journalDir + ".old", previousDirectory(journalDir)} {
+		if err := os.Mkdir(directory, 0700); err != nil {
+			t.Fatal(err)
+		}
+	}
+	finished, err := FinishSwap(journalDir, stagingDir, false)
+	if err != nil || finished {
+		t.Errorf("Expected nothing to finish, got %v %v", finished, err)
+	}
+	if !exists(journalDir+".old") || !exists(previousDirectory(journalDir)) || !holds(journalDir, journalDir) {
+		t.Error("Expected every directory to be left alone")
+	}
+}
+
+// startSwap writes the marker that swapJournal writes before it moves the journal.
+func startSwap(t *testing.T, journalDir string) {
+	if err := ioutil.WriteFile(swapMarker(journalDir), nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
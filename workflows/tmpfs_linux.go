@@ -0,0 +1,20 @@
+//go:build linux
+// +build linux
+
+package workflows
+
+import (
+	"syscall"
+)
+
+// tmpfsMagic identifies tmpfs in statfs results.
+const tmpfsMagic = 0x01021994
+
+// isMemoryBacked returns whether the directory is on a tmpfs.
+func isMemoryBacked(directory string) bool {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(directory, &stat); err != nil {
+		return false
+	}
+	return stat.Type == tmpfsMagic
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+// +build !linux
+
+package workflows
+
+// isMemoryBacked can't tell whether a directory is memory backed on this platform so, drafts are
+// only edited in a directory that's chosen explicitly.
+func isMemoryBacked(directory string) bool {
+	return false
+}
@@ -0,0 +1,82 @@
+package workflows
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/btobolaski/ejrnl"
+)
+
+// DefaultTrashDays is how many days deleted entries are kept when the config doesn't say.
+const DefaultTrashDays = 30
+
+func trash(driver ejrnl.Driver) (ejrnl.Trash, error) {
+	trash, ok := driver.(ejrnl.Trash)
+	if !ok {
+		return nil, errors.New("The journal's backend doesn't support deleting entries")
+	}
+	return trash, nil
+}
+
+// Delete moves the entry into the trash.
+func Delete(ctx context.Context, driver ejrnl.Driver, id string) error {
+	trash, err := trash(driver)
+	if err != nil {
+		return err
+	}
+	return trash.Delete(ctx, id)
+}
+
+// Restore moves the entry out of the trash.
+func Restore(ctx context.Context, driver ejrnl.Driver, id string) error {
+	trash, err := trash(driver)
+	if err != nil {
+		return err
+	}
+	return trash.Restore(ctx, id)
+}
+
+// PrintTrash outputs when each entry in the trash was deleted followed by its date, in the zone, id
+// and title. The most recently deleted entries are first.
+func PrintTrash(ctx context.Context, driver ejrnl.Driver, zone ejrnl.DisplayZone) error {
+	trash, err := trash(driver)
+	if err != nil {
+		return err
+	}
+	trashed, err := trash.Trashed(ctx)
+	if err != nil {
+		return err
+	}
+	sort.Slice(trashed, func(i, j int) bool { return trashed[i].Deleted.After(trashed[j].Deleted) })
+	for _, entry := range trashed {
+		fmt.Printf("Deleted %s: ", entry.Deleted.Local().Format("2006-01-02 15:04"))
+		printSummary(entry.Summary, zone)
+	}
+	return nil
+}
+
+// EmptyTrash permanently removes every entry in the trash and returns how many it removed.
+func EmptyTrash(ctx context.Context, driver ejrnl.Driver) (int, error) {
+	trash, err := trash(driver)
+	if err != nil {
+		return 0, err
+	}
+	return trash.Purge(ctx, time.Now())
+}
+
+// PurgeTrash permanently removes the entries that have been in the trash for more than days. If
+// days is 0, DefaultTrashDays is used and, if it's negative, nothing is removed. Drivers without a
+// trash are left alone.
+func PurgeTrash(ctx context.Context, driver ejrnl.Driver, days int) (int, error) {
+	trash, ok := driver.(ejrnl.Trash)
+	if !ok || days < 0 {
+		return 0, nil
+	}
+	if days == 0 {
+		days = DefaultTrashDays
+	}
+	return trash.Purge(ctx, time.Now().AddDate(0, 0, -days))
+}
@@ -0,0 +1,57 @@
+package workflows
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/btobolaski/ejrnl"
+	"github.com/btobolaski/ejrnl/storage/memory"
+)
+
+// agedTrash reports every deletion as having happened days ago.
+type agedTrash struct {
+	*memory.Driver
+	days int
+}
+
+func (d agedTrash) Purge(ctx context.Context, deletedBefore time.Time) (int, error) {
+	return d.Driver.Purge(ctx, deletedBefore.AddDate(0, 0, d.days))
+}
+
+func TestPurgeTrash(t *testing.T) {
+	ctx := context.Background()
+	driver := writeEntries(t, 3)
+	for _, id := range []string{"0", "1"} {
+		if err := Delete(ctx, driver, id); err != nil {
+			t.Fatalf("Failed to delete %s because %s", id, err)
+		}
+	}
+
+	if purged, err := PurgeTrash(ctx, agedTrash{driver, 10}, 0); err != nil || purged != 0 {
+		t.Errorf("Expected entries deleted 10 days ago to be kept, got %d %v", purged, err)
+	}
+	if purged, err := PurgeTrash(ctx, agedTrash{driver, 40}, -1); err != nil || purged != 0 {
+		t.Errorf("Expected a negative retention to keep everything, got %d %v", purged, err)
+	}
+	if err := Restore(ctx, driver, "1"); err != nil {
+		t.Fatalf("Failed to restore the entry because %s", err)
+	}
+	if purged, err := PurgeTrash(ctx, agedTrash{driver, 40}, 0); err != nil || purged != 1 {
+		t.Errorf("Expected entries deleted 40 days ago to be purged, got %d %v", purged, err)
+	}
+	listing, err := driver.List()
+	if err != nil || len(listing) != 2 {
+		t.Errorf("Expected the restored entry to be kept, got %v %v", listing, err)
+	}
+
+	if err = Delete(ctx, driver, "2"); err != nil {
+		t.Fatalf("Failed to delete the entry because %s", err)
+	}
+	if purged, err := EmptyTrash(ctx, driver); err != nil || purged != 1 {
+		t.Errorf("Expected the trash to be emptied, got %d %v", purged, err)
+	}
+	if err = Delete(ctx, struct{ ejrnl.Driver }{driver}, "1"); err == nil {
+		t.Error("Expected an error from a driver without a trash")
+	}
+}
@@ -2,21 +2,21 @@ package workflows
 
 import (
 	"bytes"
+	"context"
 	"crypto/rand"
 	"encoding/base64"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
-	"os"
-	"os/exec"
 	"sort"
-	"strings"
 	"time"
 
-	"github.com/termie/go-shutil"
 	"gopkg.in/yaml.v2"
 
 	"github.com/btobolaski/ejrnl"
+	"github.com/btobolaski/ejrnl/backup"
+	"github.com/btobolaski/ejrnl/crypto"
 )
 
 func MakeSalt(b int) string {
@@ -41,9 +41,28 @@ func Import(path string, driver ejrnl.Driver) error {
 	return driver.Write(entry)
 }
 
+// BackupKey writes count recovery sheets for the journal's key to out. Any threshold of the sheets
+// can be used to recover the key.
+func BackupKey(key *crypto.Secret, config ejrnl.Config, count, threshold int, out io.Writer) error {
+	sheets, err := backup.NewSheets(key.Bytes(), config.Salt, config.Pow, count, threshold)
+	if err != nil {
+		return err
+	}
+	for i, sheet := range sheets {
+		if i > 0 {
+			fmt.Fprint(out, "\n-------------------------------------\n\n")
+		}
+		if err = sheet.Render(out); err != nil {
+			return err
+		}
+		crypto.Wipe(sheet.Share)
+	}
+	return nil
+}
+
 // Init inits the specified driver
-func Init(driver ejrnl.Driver) error {
-	return driver.Init()
+func Init(ctx context.Context, driver ejrnl.Driver) error {
+	return ejrnl.InitContext(ctx, driver)
 }
 
 // DefaultConfig returns the default configuration file.
@@ -55,143 +74,122 @@ func DefaultConfig() ejrnl.Config {
 	}
 }
 
-func Print(driver ejrnl.Driver, count int) error {
-	listing, sorted, err := Listing(driver)
-	if err != nil {
+// Print outputs the most recent count entries that match the filter, with their dates in the
+// filter's zone. If count <= 0, it outputs all of them.
+func Print(ctx context.Context, driver ejrnl.Driver, count int, filter Filter) error {
+	return Matching(ctx, driver, filter, count, func(entry ejrnl.Entry) error {
+		if entry.Date != nil {
+			date := filter.Zone.In(*entry.Date, entry.Zone)
+			entry.Date = &date
+		}
+		_, err := fmt.Printf("%s\n\n-------------------------------------\n\n", Format(entry))
 		return err
-	}
-	if count <= 0 {
-		count = len(sorted)
-	} else if count > len(sorted) {
-		count = len(sorted)
-	}
+	})
+}
 
-	for i := 0; i < count; i++ {
-		entry, err := driver.Read(listing[sorted[i]])
-		if err != nil {
-			return err
-		}
-		fmt.Printf("%s\n\n-------------------------------------\n\n", Format(entry))
+// ListEntries outputs the date, in the filter's zone, id and title of the most recent count of
+// entries that match the filter. If count <= 0, it outputs all of the entries
+func ListEntries(ctx context.Context, driver ejrnl.Driver, count int, filter Filter) error {
+	if !filter.Empty() {
+		return Matching(ctx, driver, filter, count, func(entry ejrnl.Entry) error {
+			printSummary(entry.Summary(), filter.Zone)
+			return nil
+		})
 	}
-	return nil
-}
 
-// ListEntries outputs the date and id of the most recent count of entries. If count <= 0, it
-// outputs all of the entries
-func ListEntries(driver ejrnl.Driver, count int) error {
-	index, sorted, err := Listing(driver)
+	summaries, err := Summaries(ctx, driver)
 	if err != nil {
 		return err
 	}
-
-	if count <= 0 || count > len(sorted) {
-		count = len(sorted)
+	if count <= 0 || count > len(summaries) {
+		count = len(summaries)
 	}
-
-	for i := 0; i < count; i++ {
-		fmt.Printf("%s - %s\n", sorted[i], index[sorted[i]])
+	for _, summary := range summaries[:count] {
+		printSummary(summary, filter.Zone)
 	}
 	return nil
 }
 
+func printSummary(summary ejrnl.Summary, zone ejrnl.DisplayZone) {
+	date := zone.In(summary.Date, summary.Zone)
+	if summary.Title == "" {
+		fmt.Printf("%s - %s\n", date, summary.Id)
+	} else {
+		fmt.Printf("%s - %s - %s\n", date, summary.Id, summary.Title)
+	}
+}
+
 // NewEntry creates a new entry in the expected format and then opens the user's editor for them to
-// edit the entry.
-func NewEntry(driver ejrnl.Driver, tempDir string) error {
+// edit the entry. If it can't be saved, it's kept as an encrypted draft.
+func NewEntry(driver ejrnl.Driver, drafts Drafts) error {
 	date := time.Now()
-	entry := ejrnl.Entry{Date: &date}
-	tempFile := strings.Replace(fmt.Sprintf("%s/%s.ejrnl", tempDir, date), " ", "-", -1)
-	err := ioutil.WriteFile(tempFile, []byte(Format(entry)), 0600)
-	if err != nil {
-		return err
-	}
-	err = editFile(tempFile)
-	if err != nil {
-		return err
-	}
-	bytes, err := ioutil.ReadFile(tempFile)
-	if err != nil {
-		return err
-	}
-	readEntry, err := Read(bytes)
-	if err != nil {
-		return err
-	}
-	if readEntry.Body == entry.Body && len(readEntry.Tags) == 0 && readEntry.Id == "" {
-		println("entry wasn't changed, not adding it to the journal")
-		return os.Remove(tempFile)
-	}
-	err = driver.Write(readEntry)
-	if err != nil {
-		return err
-	}
-	return os.Remove(tempFile)
+	entry := ejrnl.Entry{Date: &date, Zone: ejrnl.CurrentZone()}
+	return drafts.edit([]byte(Format(entry)), "", func(text []byte) error {
+		readEntry, err := Read(text)
+		if err != nil {
+			return err
+		}
+		if sameEntry(readEntry, entry) {
+			println("entry wasn't changed, not adding it to the journal")
+			return nil
+		}
+		return driver.Write(readEntry)
+	})
 }
 
-// EditEntry decrypts the specified entry and then opens the user's editor and saves the edits
-func EditEntry(driver ejrnl.Driver, id, tempDir string) error {
+// EditEntry decrypts the specified entry and then opens the user's editor and saves the edits. If
+// they can't be saved, they're kept as an encrypted draft.
+func EditEntry(driver ejrnl.Driver, id string, drafts Drafts) error {
 	entry, err := driver.Read(id)
 	if err != nil {
 		return err
 	}
-
-	tempFile := strings.Replace(fmt.Sprintf("%s/%s.ejrnl", tempDir, entry.Id), " ", "-", -1)
-	err = ioutil.WriteFile(tempFile, []byte(Format(entry)), 0600)
-	if err != nil {
-		return err
-	}
-	err = editFile(tempFile)
-	if err != nil {
-		fmt.Printf("an error was encountered, the editted file still exists at %s\n", tempFile)
-		return err
-	}
-	bytes, err := ioutil.ReadFile(tempFile)
-	if err != nil {
-		fmt.Printf("an error was encountered, the editted file still exists at %s\n", tempFile)
-		return err
-	}
-	entry, err = Read(bytes)
-	if err != nil {
-		fmt.Printf("an error was encountered, the editted file still exists at %s\n", tempFile)
-		return err
-	}
-	err = driver.Write(entry)
-	if err != nil {
-		fmt.Printf("an error was encountered, the editted file still exists at %s\n", tempFile)
-		return err
-	}
-	err = os.Remove(tempFile)
-	if err != nil {
-		fmt.Printf("an error was encountered, the editted file still exists at %s\n", tempFile)
-	}
-	return err
+	return drafts.edit([]byte(Format(entry)), "", writeText(driver))
 }
 
-func Rekey(oldDriver, newDriver ejrnl.Driver, journalDir, tempDir string) error {
-	toTransfer, err := oldDriver.List()
-	if err != nil {
-		return err
-	}
-
-	for _, id := range toTransfer {
-		entry, err := oldDriver.Read(id)
-		if err != nil {
-			return err
-		}
-		err = newDriver.Write(entry)
+// writeText returns a function that parses an entry and writes it to the journal.
+func writeText(driver ejrnl.Driver) func([]byte) error {
+	return func(text []byte) error {
+		entry, err := Read(text)
 		if err != nil {
 			return err
 		}
+		return driver.Write(entry)
 	}
+}
+
+// migrateBatch is how many entries Migrate gives a BatchWriter at once.
+const migrateBatch = 256
 
-	if err = os.RemoveAll(journalDir); err != nil {
-		fmt.Printf("Failed to remove old journal directory, %s. Rekeyed journal is at %s", journalDir, tempDir)
+// Migrate rewrites every entry so that it is stored with the driver's current compression and
+// padding settings. Drivers that implement ejrnl.Migrator rewrite everything they store themselves
+// and the others are given the entries in batches. If it's canceled, the entries that were already
+// rewritten keep their new settings.
+func Migrate(ctx context.Context, driver ejrnl.Driver) error {
+	if migrator, ok := driver.(ejrnl.Migrator); ok {
+		return migrator.Migrate(ctx)
+	}
+	_, batching := driver.(ejrnl.BatchWriter)
+	entries := []ejrnl.Entry{}
+	err := ForEachEntry(ctx, driver, 0, func(entry ejrnl.Entry) error {
+		if !batching {
+			return ejrnl.WriteContext(ctx, driver, entry)
+		}
+		entries = append(entries, entry)
+		if len(entries) < migrateBatch {
+			return nil
+		}
+		err := ejrnl.WriteBatchContext(ctx, driver, entries)
+		entries = entries[:0]
 		return err
-	}
-	err = shutil.CopyTree(tempDir, journalDir, nil)
+	})
 	if err != nil {
-		fmt.Printf("Failed to rename %s to %s. Journal is in unknown state.\n", tempDir, journalDir)
+		return err
+	}
+	if len(entries) > 0 {
+		return ejrnl.WriteBatchContext(ctx, driver, entries)
 	}
-	return err
+	return nil
 }
 
 type timeSlice []time.Time
@@ -212,10 +210,10 @@ func (ts timeSlice) Swap(i, j int) {
 
 // Listing gets a listing of all of the entries and sorts the listing's index by reverse
 // chronological order
-func Listing(driver ejrnl.Driver) (map[time.Time]string, []time.Time, error) {
-	listing, err := driver.List()
+func Listing(ctx context.Context, driver ejrnl.Driver) (map[time.Time]string, []time.Time, error) {
+	listing, err := ejrnl.ListContext(ctx, driver)
 	if err != nil {
-		return listing, []time.Time{}, nil
+		return listing, []time.Time{}, err
 	}
 	keys := []time.Time{}
 	for i := range listing {
@@ -257,23 +255,3 @@ func Format(entry ejrnl.Entry) string {
 
 	return fmt.Sprintf("%s---\n%s", header, body)
 }
-
-func editFile(path string) error {
-	command := os.ExpandEnv("$EDITOR")
-	if command == "" {
-		if _, err := os.Stat("/usr/bin/edit"); err == nil {
-			command = "/usr/bin/edit"
-		} else if os.Stat("/usr/bin/editor"); err == nil {
-			command = "/usr/bin/editor"
-		} else if os.Stat("/usr/bin/vim"); err == nil {
-			command = "/usr/bin/vim"
-		} else if os.Stat("/usr/bin/vi"); err == nil {
-			command = "/usr/bin/vi"
-		}
-	}
-	cmd := exec.Command(command, path)
-	cmd.Stdin = os.Stdin
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	return cmd.Run()
-}
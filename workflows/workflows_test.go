@@ -1,12 +1,19 @@
 package workflows
 
 import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
 	"os"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/btobolaski/ejrnl"
+	"github.com/btobolaski/ejrnl/crypto"
 	"github.com/btobolaski/ejrnl/storage"
+	"github.com/btobolaski/ejrnl/storage/memory"
 )
 
 var exampleEntry = `date: 2016-12-24T00:32:58Z
@@ -59,7 +66,7 @@ func TestInit(t *testing.T) {
 		return
 	}
 
-	err = Init(driver)
+	err = Init(context.Background(), driver)
 	defer os.RemoveAll(conf.StorageDirectory)
 	if err != nil {
 		t.Errorf("Failed to init the driver because %s", err)
@@ -67,28 +74,16 @@ func TestInit(t *testing.T) {
 }
 
 func TestImport(t *testing.T) {
-	conf := ejrnl.Config{
-		StorageDirectory: "../workflow-import",
-		Salt:             MakeSalt(32),
-		Pow:              12,
-	}
-
-	driver, err := storage.NewDriver(conf, "password")
-	if _, ok := err.(*storage.NeedsInit); !ok {
-		t.Errorf("Expected driver to need init but got err instead: %s", err)
-		return
-	}
-
-	err = Init(driver)
-	defer os.RemoveAll(conf.StorageDirectory)
+	driver := memory.NewDriver()
+	err := Import("./import_test.md", driver)
 	if err != nil {
-		t.Errorf("Failed to init the driver because %s", err)
+		t.Errorf("Failed to import file because %s", err)
 		return
 	}
 
-	err = Import("./import_test.md", driver)
-	if err != nil {
-		t.Errorf("Failed to import file because %s", err)
+	listing, err := driver.List()
+	if err != nil || len(listing) != 1 {
+		t.Errorf("Expected one imported entry, got %v %v", listing, err)
 	}
 }
 
@@ -107,27 +102,9 @@ func TestDefaultConfig(t *testing.T) {
 }
 
 func TestListing(t *testing.T) {
-	conf := ejrnl.Config{
-		StorageDirectory: "../workflow-listing",
-		Salt:             MakeSalt(32),
-		Pow:              12,
-	}
-
-	driver, err := storage.NewDriver(conf, "password")
-	if _, ok := err.(*storage.NeedsInit); !ok {
-		t.Errorf("Expected driver to need init but got err instead: %s", err)
-		return
-	}
-
-	err = Init(driver)
-	defer os.RemoveAll(conf.StorageDirectory)
-	if err != nil {
-		t.Errorf("Failed to init the driver because %s", err)
-		return
-	}
-
+	driver := memory.NewDriver()
 	date := time.Date(2015, 12, 24, 0, 32, 58, 0, time.UTC)
-	err = driver.Write(ejrnl.Entry{
+	err := driver.Write(ejrnl.Entry{
 		Id:   "1",
 		Date: &date,
 	})
@@ -145,7 +122,7 @@ func TestListing(t *testing.T) {
 		return
 	}
 
-	listing, sorted, err := Listing(driver)
+	listing, sorted, err := Listing(context.Background(), driver)
 	if err != nil {
 		t.Errorf("Failed to get listing because %s", err)
 		return
@@ -168,7 +145,7 @@ func TestRekey(t *testing.T) {
 		return
 	}
 
-	err = Init(driver)
+	err = Init(context.Background(), driver)
 	defer os.RemoveAll(conf.StorageDirectory)
 	if err != nil {
 		t.Errorf("Failed to init the driver because %s", err)
@@ -211,7 +188,7 @@ func TestRekey(t *testing.T) {
 	}
 	defer os.RemoveAll(newConfig.StorageDirectory)
 
-	if err = Rekey(driver, newDriver, conf.StorageDirectory, newConfig.StorageDirectory); err != nil {
+	if err = Rekey(context.Background(), driver, newDriver, conf.StorageDirectory, newConfig.StorageDirectory, false); err != nil {
 		t.Errorf("Failed to rekey because %s", err)
 		return
 	}
@@ -222,7 +199,7 @@ func TestRekey(t *testing.T) {
 		return
 	}
 
-	listing, sorted, err := Listing(driver)
+	listing, sorted, err := Listing(context.Background(), driver)
 	if err != nil {
 		t.Errorf("Failed to get listing because %s", err)
 		return
@@ -231,3 +208,288 @@ func TestRekey(t *testing.T) {
 		t.Errorf("Sorting was incorrect %v", sorted)
 	}
 }
+
+func TestBackupKey(t *testing.T) {
+	conf := ejrnl.Config{Salt: MakeSalt(32), Pow: 12}
+	key := crypto.SecretFromBytes([]byte("0123456789abcdef"))
+	defer key.Close()
+
+	output := new(bytes.Buffer)
+	if err := BackupKey(key, conf, 3, 2, output); err != nil {
+		t.Errorf("Failed to back up key because %s", err)
+		return
+	}
+	if strings.Count(output.String(), "ejrnl key recovery sheet") != 3 {
+		t.Errorf("Expected 3 sheets in the output:\n%s", output.String())
+	}
+	if !strings.Contains(output.String(), conf.Salt) {
+		t.Error("The sheets didn't contain the salt")
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	conf := ejrnl.Config{
+		StorageDirectory: "../workflow-migrate",
+		Salt:             MakeSalt(32),
+		Pow:              12,
+	}
+
+	driver, err := storage.NewDriver(conf, "password")
+	if _, ok := err.(*storage.NeedsInit); !ok {
+		t.Errorf("Expected driver to need init but got err instead: %s", err)
+		return
+	}
+
+	err = Init(context.Background(), driver)
+	defer os.RemoveAll(conf.StorageDirectory)
+	if err != nil {
+		t.Errorf("Failed to init the driver because %s", err)
+		return
+	}
+
+	for i, body := range []string{"short", strings.Repeat("a much longer entry ", 20)} {
+		date := time.Date(2015, 12, 24+i, 0, 32, 58, 0, time.UTC)
+		err = driver.Write(ejrnl.Entry{Id: fmt.Sprintf("%d", i), Date: &date, Body: body})
+		if err != nil {
+			t.Errorf("Failed to write entry because %s", err)
+			return
+		}
+	}
+
+	conf.Compression = "none"
+	conf.Padding = "1024"
+	driver, err = storage.NewDriver(conf, "password")
+	if err != nil {
+		t.Errorf("Failed to open journal because %s", err)
+		return
+	}
+	if err = Migrate(context.Background(), driver); err != nil {
+		t.Errorf("Failed to migrate because %s", err)
+		return
+	}
+
+	files, err := ioutil.ReadDir(conf.StorageDirectory)
+	if err != nil {
+		t.Errorf("Failed to list journal because %s", err)
+		return
+	}
+	sizes := map[int64]bool{}
+	for _, file := range files {
+		if strings.HasSuffix(file.Name(), ".cpt") && file.Name() != "index.cpt" {
+			sizes[file.Size()] = true
+		}
+	}
+	if len(sizes) != 1 {
+		t.Errorf("Migrated entries weren't padded to the same size, %v", sizes)
+	}
+	entry, err := driver.Read("1")
+	if err != nil || entry.Body != strings.Repeat("a much longer entry ", 20) {
+		t.Errorf("Failed to read migrated entry, got %v %v", entry, err)
+	}
+}
+
+func TestMigrateBackend(t *testing.T) {
+	conf := ejrnl.Config{
+		StorageDirectory: "../workflow-migrate-backend",
+		Salt:             MakeSalt(32),
+		Pow:              12,
+	}
+	defer os.RemoveAll(conf.StorageDirectory)
+
+	driver, err := storage.Open(conf, crypto.SecretFromBytes([]byte("password")))
+	if _, ok := err.(*storage.NeedsInit); !ok {
+		t.Errorf("Expected driver to need init but got err instead: %s", err)
+		return
+	}
+	if err = Init(context.Background(), driver); err != nil {
+		t.Errorf("Failed to init the driver because %s", err)
+		return
+	}
+	date := time.Date(2015, 12, 24, 0, 32, 58, 0, time.UTC)
+	if err = driver.Write(ejrnl.Entry{Id: "1", Date: &date, Body: "moved", Tags: []string{"Test"}}); err != nil {
+		t.Errorf("Failed to write entry because %s", err)
+		return
+	}
+
+	for _, backend := range []string{storage.SQLiteBackend, storage.FilesBackend} {
+		newConfig := conf
+		newConfig.StorageDirectory = fmt.Sprintf("../workflow-migrate-backend-%s", backend)
+		newConfig.Backend = backend
+		key := crypto.SecretFromBytes(append([]byte{}, driver.Key().Bytes()...))
+		newDriver, err := storage.OpenWithKey(newConfig, key)
+		if _, ok := err.(*storage.NeedsInit); !ok {
+			t.Errorf("Failed to create destination driver because %s", err)
+			return
+		}
+		defer os.RemoveAll(newConfig.StorageDirectory)
+		if err = newDriver.Init(); err != nil {
+			t.Errorf("Failed to init destination driver because %s", err)
+			return
+		}
+		if err = storage.CopyKeySlots(conf, newConfig); err != nil {
+			t.Errorf("Failed to copy the key slots because %s", err)
+			return
+		}
+		err = MigrateBackend(context.Background(), driver, newDriver, conf.StorageDirectory, newConfig.StorageDirectory, true)
+		driver.Close()
+		newDriver.Close()
+		if err != nil {
+			t.Errorf("Failed to migrate to %s because %s", backend, err)
+			return
+		}
+
+		conf.Backend = backend
+		driver, err = storage.Open(conf, crypto.SecretFromBytes([]byte("password")))
+		if err != nil {
+			t.Errorf("Failed to open the %s journal because %s", backend, err)
+			return
+		}
+		entry, err := driver.Read("1")
+		if err != nil || entry.Body != "moved" || !entry.Date.Equal(date) {
+			t.Errorf("Failed to read the entry from the %s journal, got %#v %v", backend, entry, err)
+		}
+	}
+	driver.Close()
+}
+
+func TestRekeyCanceled(t *testing.T) {
+	journalDir, err := ioutil.TempDir("", "ejrnl-journal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(journalDir)
+	tempDir, err := ioutil.TempDir("", "ejrnl-rekey")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = Rekey(ctx, writeEntries(t, 10), memory.NewDriver(), journalDir, tempDir, false)
+	if err != context.Canceled {
+		t.Errorf("Expected the rekey to be canceled, got %v", err)
+	}
+	if _, err = os.Stat(journalDir); err != nil {
+		t.Errorf("The journal was replaced even though the rekey was canceled, %s", err)
+	}
+}
+
+func TestRekeySQLite(t *testing.T) {
+	parent, err := ioutil.TempDir("", "ejrnl-rekey-sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(parent)
+	conf := ejrnl.Config{
+		StorageDirectory: fmt.Sprintf("%s/journal", parent),
+		Salt:             MakeSalt(32),
+		Pow:              12,
+		Backend:          storage.SQLiteBackend,
+	}
+
+	driver, err := storage.Open(conf, crypto.SecretFromBytes([]byte("password")))
+	if _, ok := err.(*storage.NeedsInit); !ok {
+		t.Fatalf("Expected driver to need init but got err instead: %s", err)
+	}
+	if err = Init(context.Background(), driver); err != nil {
+		t.Fatalf("Failed to init the driver because %s", err)
+	}
+	date := time.Date(2015, 12, 24, 0, 32, 58, 0, time.UTC)
+	if err = driver.Write(ejrnl.Entry{Id: "1", Date: &date, Body: "rekeyed"}); err != nil {
+		t.Fatalf("Failed to write entry because %s", err)
+	}
+
+	newConfig := conf
+	newConfig.StorageDirectory = StagingDirectory(conf.StorageDirectory)
+	newDriver, err := storage.Open(newConfig, crypto.SecretFromBytes([]byte("new password")))
+	if _, ok := err.(*storage.NeedsInit); !ok {
+		t.Fatalf("Failed to create destination driver because %s", err)
+	}
+	if err = newDriver.Init(); err != nil {
+		t.Fatalf("Failed to init destination driver because %s", err)
+	}
+	err = Rekey(context.Background(), driver, newDriver, conf.StorageDirectory, newConfig.StorageDirectory, false)
+	driver.Close()
+	newDriver.Close()
+	if err != nil {
+		t.Fatalf("Failed to rekey because %s", err)
+	}
+
+	if driver, err = storage.Open(conf, crypto.SecretFromBytes([]byte("password"))); err == nil {
+		driver.Close()
+		t.Error("The old password still opens the journal")
+	}
+	driver, err = storage.Open(conf, crypto.SecretFromBytes([]byte("new password")))
+	if err != nil {
+		t.Fatalf("Failed to open the rekeyed journal because %s", err)
+	}
+	defer driver.Close()
+	if entry, err := driver.Read("1"); err != nil || entry.Body != "rekeyed" {
+		t.Errorf("Failed to read the entry from the rekeyed journal, got %#v %v", entry, err)
+	}
+}
+
+func TestRekeyCopiesDictionaries(t *testing.T) {
+	parent, err := ioutil.TempDir("", "ejrnl-rekey-dictionaries")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(parent)
+	conf := ejrnl.Config{
+		StorageDirectory: fmt.Sprintf("%s/journal", parent),
+		Salt:             MakeSalt(32),
+		Pow:              12,
+		Compression:      "zstd",
+	}
+
+	driver, err := storage.NewDriver(conf, "password")
+	if _, ok := err.(*storage.NeedsInit); !ok {
+		t.Fatalf("Expected driver to need init but got err instead: %s", err)
+	}
+	if err = Init(context.Background(), driver); err != nil {
+		t.Fatalf("Failed to init the driver because %s", err)
+	}
+	for i := 0; i < 20; i++ {
+		date := time.Date(2016, 12, i+1, 8, 0, 0, 0, time.UTC)
+		entry := ejrnl.Entry{
+			Date: &date,
+			Body: fmt.Sprintf("Went for a walk in the morning and wrote %d pages in the afternoon.", i),
+			Id:   fmt.Sprintf("%d", i),
+		}
+		if err = driver.Write(entry); err != nil {
+			t.Fatalf("Failed to write entry because %s", err)
+		}
+	}
+	if _, err = driver.TrainDictionary(); err != nil {
+		t.Fatalf("Failed to train dictionary because %s", err)
+	}
+
+	newConfig := conf
+	newConfig.StorageDirectory = StagingDirectory(conf.StorageDirectory)
+	newDriver, err := storage.NewDriver(newConfig, "new password")
+	if _, ok := err.(*storage.NeedsInit); !ok {
+		t.Fatalf("Failed to create destination driver because %s", err)
+	}
+	if err = newDriver.Init(); err != nil {
+		t.Fatalf("Failed to init destination driver because %s", err)
+	}
+	err = Rekey(context.Background(), driver, newDriver, conf.StorageDirectory, newConfig.StorageDirectory, false)
+	driver.Close()
+	newDriver.Close()
+	if err != nil {
+		t.Fatalf("Failed to rekey because %s", err)
+	}
+
+	driver, err = storage.NewDriver(conf, "new password")
+	if err != nil {
+		t.Fatalf("Failed to open the rekeyed journal because %s", err)
+	}
+	defer driver.Close()
+	if dictionaries := driver.Dictionaries(); len(dictionaries) != 1 {
+		t.Errorf("Expected the dictionary to be copied, got %d dictionaries", len(dictionaries))
+	}
+	if entry, err := driver.Read("3"); err != nil || !strings.Contains(entry.Body, "wrote 3 pages") {
+		t.Errorf("Failed to read the entry from the rekeyed journal, got %#v %v", entry, err)
+	}
+}
@@ -0,0 +1,74 @@
+package workflows
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/btobolaski/ejrnl"
+	"github.com/btobolaski/ejrnl/storage/memory"
+)
+
+const tokyoEntry = `id: tokyo
+date: 2016-12-25T01:00:00+09:00
+zone: Asia/Tokyo
+---
+Written on Christmas morning
+`
+
+func TestOriginalZone(t *testing.T) {
+	entry, err := Read([]byte(tokyoEntry))
+	if err != nil {
+		t.Fatalf("Failed to parse the entry because %s", err)
+	}
+	if entry.Zone != "Asia/Tokyo" {
+		t.Fatalf("Expected the entry's zone to be read, got %s", entry.Zone)
+	}
+	driver := memory.NewDriver()
+	if err = driver.Write(entry); err != nil {
+		t.Fatalf("Failed to write the entry because %s", err)
+	}
+	summaries, err := Summaries(context.Background(), driver)
+	if err != nil || len(summaries) != 1 {
+		t.Fatalf("Failed to summarize the journal, got %v %v", summaries, err)
+	}
+
+	original := ejrnl.OriginalZone.In(summaries[0].Date, summaries[0].Zone)
+	if original.Format("2006-01-02 15:04 MST") != "2016-12-25 01:00 JST" {
+		t.Errorf("Expected the date in the zone it was written in, got %s", original)
+	}
+	if local := ejrnl.LocalZone.In(summaries[0].Date, summaries[0].Zone); local.Location() != time.Local || !local.Equal(original) {
+		t.Errorf("Expected the same time in the local zone, got %s", local)
+	}
+	unknown := ejrnl.OriginalZone.In(*entry.Date, "Nowhere/Unknown")
+	if _, offset := unknown.Zone(); offset != 9*60*60 {
+		t.Errorf("Expected the offset that the entry was written with, got %s", unknown)
+	}
+
+	tests := []struct {
+		filter  Filter
+		matches bool
+	}{
+		{Filter{Since: "2016-12-25", Zone: ejrnl.OriginalZone}, true},
+		{Filter{Until: "2016-12-24", Zone: ejrnl.OriginalZone}, false},
+		{Filter{Since: "2016-12-25", Until: "2016-12-25", Zone: ejrnl.OriginalZone}, true},
+		{Filter{Since: entry.Date.Local().Format(dayFormat), Until: entry.Date.Local().Format(dayFormat)}, true},
+		{Filter{Since: "2016-12-26"}, false},
+	}
+	for _, test := range tests {
+		if test.filter.Matches(entry) != test.matches {
+			t.Errorf("Expected %#v to match: %t", test.filter, test.matches)
+		}
+	}
+	resolver := NewResolver(summaries, ejrnl.OriginalZone)
+	if id, ok := resolver.Resolve("2016-12-25"); !ok || id != "tokyo" {
+		t.Errorf("Expected the day link to resolve in the zone the entry was written in, got %q", id)
+	}
+	if _, ok := resolver.Resolve("2016-12-24"); ok {
+		t.Error("Expected the day before not to resolve")
+	}
+
+	if err = (&Filter{}).SetDays("2016-12-24", "Christmas"); err == nil {
+		t.Error("Expected an error for a day in the wrong format")
+	}
+}
@@ -0,0 +1,75 @@
+package ejrnl
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DisplayZone chooses the zone that entries' dates are shown in.
+type DisplayZone string
+
+const (
+	// LocalZone shows dates in the machine's zone
+	LocalZone DisplayZone = "local"
+	// OriginalZone shows dates in the zone that each entry was written in
+	OriginalZone DisplayZone = "original"
+)
+
+// ParseDisplayZone parses the name of a display zone. An empty name is the local zone.
+func ParseDisplayZone(name string) (DisplayZone, error) {
+	switch DisplayZone(strings.ToLower(name)) {
+	case "", LocalZone:
+		return LocalZone, nil
+	case OriginalZone:
+		return OriginalZone, nil
+	}
+	return LocalZone, fmt.Errorf("The timezone must be either local or original, not %s", name)
+}
+
+// locations caches the zones that have been loaded so that listing a journal only reads each zone's
+// definition once.
+var locations sync.Map
+
+// In returns the date in the display zone. zone is the name of the zone the entry was written in.
+// Entries without one, or whose zone isn't known on this machine, are shown with the offset that
+// they were written with.
+func (z DisplayZone) In(date time.Time, zone string) time.Time {
+	if z != OriginalZone {
+		return date.Local()
+	}
+	if zone == "" {
+		return date
+	}
+	if location, ok := locations.Load(zone); ok {
+		return date.In(location.(*time.Location))
+	}
+	location, err := time.LoadLocation(zone)
+	if err != nil {
+		return date
+	}
+	locations.Store(zone, location)
+	return date.In(location)
+}
+
+// CurrentZone returns the name of the machine's zone, such as Asia/Tokyo, or an empty string if it
+// can't be determined.
+func CurrentZone() string {
+	if zone := os.Getenv("TZ"); zone != "" {
+		if _, err := time.LoadLocation(zone); err == nil {
+			return zone
+		}
+		return ""
+	}
+	target, err := os.Readlink("/etc/localtime")
+	if err != nil {
+		return ""
+	}
+	i := strings.Index(target, "zoneinfo/")
+	if i < 0 {
+		return ""
+	}
+	return target[i+len("zoneinfo/"):]
+}